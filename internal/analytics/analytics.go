@@ -0,0 +1,277 @@
+// Package analytics aggregates a user's training history into volume,
+// frequency and progression series. All aggregation happens in postgres so
+// the cost does not grow with the number of workouts sent over the wire.
+package analytics
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	BucketDay   = "day"
+	BucketWeek  = "week"
+	BucketMonth = "month"
+)
+
+var ErrInvalidBucket = errors.New("bucket must be one of day, week or month")
+
+// volumeSQL is the tonnage of a set; warm-up sets are left out everywhere.
+const volumeSQL = `COALESCE(s.reps, 0) * COALESCE(s.weight, 0)`
+
+// estimated1RMSQL mirrors store.EstimateOneRepMax: Brzycki up to ten reps,
+// Epley above.
+const estimated1RMSQL = `CASE
+		WHEN s.reps IS NULL OR s.reps <= 0 OR COALESCE(s.weight, 0) <= 0 THEN NULL
+		WHEN s.reps = 1 THEN s.weight
+		WHEN s.reps <= 10 THEN s.weight * 36 / (37 - s.reps)
+		ELSE s.weight * (1 + s.reps / 30.0)
+	END`
+
+// bucketSQL truncates performed_at in the timezone the workout was logged
+// in, so a Sunday evening session stays in its own week.
+const bucketSQL = `date_trunc($2, w.performed_at AT TIME ZONE w.timezone)`
+
+type StatsQuery struct {
+	UserID     int
+	From       time.Time
+	To         time.Time
+	Bucket     string
+	ExerciseID *int
+}
+
+func (q StatsQuery) Validate() error {
+	switch q.Bucket {
+	case BucketDay, BucketWeek, BucketMonth:
+	default:
+		return ErrInvalidBucket
+	}
+	if !q.To.After(q.From) {
+		return errors.New("to must be after from")
+	}
+	return nil
+}
+
+type Summary struct {
+	Sessions             int     `json:"sessions"`
+	TotalVolume          float64 `json:"total_volume"`
+	TotalDurationMinutes int     `json:"total_duration_minutes"`
+	AvgDurationMinutes   float64 `json:"avg_duration_minutes"`
+	CaloriesBurned       int     `json:"calories_burned"`
+}
+
+type Bucket struct {
+	Start time.Time `json:"start"`
+	Summary
+}
+
+type MuscleGroupVolume struct {
+	Start  time.Time `json:"start"`
+	Muscle string    `json:"muscle"`
+	Sets   int       `json:"sets"`
+	Volume float64   `json:"volume"`
+}
+
+type ProgressionPoint struct {
+	Start        time.Time `json:"start"`
+	MaxWeight    float64   `json:"max_weight"`
+	Estimated1RM float64   `json:"estimated_1rm"`
+	Volume       float64   `json:"volume"`
+}
+
+type ExerciseProgression struct {
+	ExerciseID   int                `json:"exercise_id"`
+	ExerciseName string             `json:"exercise_name"`
+	Points       []ProgressionPoint `json:"points"`
+}
+
+type Stats struct {
+	From         time.Time             `json:"from"`
+	To           time.Time             `json:"to"`
+	Bucket       string                `json:"bucket"`
+	Summary      Summary               `json:"summary"`
+	Buckets      []*Bucket             `json:"buckets"`
+	MuscleGroups []MuscleGroupVolume   `json:"muscle_groups"`
+	Exercises    []ExerciseProgression `json:"exercises"`
+}
+
+type Store interface {
+	GetStats(q StatsQuery) (*Stats, error)
+}
+
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (pg *PostgresStore) GetStats(q StatsQuery) (*Stats, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{
+		From:         q.From,
+		To:           q.To,
+		Bucket:       q.Bucket,
+		Buckets:      []*Bucket{},
+		MuscleGroups: []MuscleGroupVolume{},
+		Exercises:    []ExerciseProgression{},
+	}
+
+	buckets, err := pg.sessionBuckets(q)
+	if err != nil {
+		return nil, fmt.Errorf("analytics sessions: %w", err)
+	}
+	if err := pg.addVolume(q, buckets); err != nil {
+		return nil, fmt.Errorf("analytics volume: %w", err)
+	}
+	for _, bucket := range buckets {
+		stats.Buckets = append(stats.Buckets, bucket)
+		stats.Summary.Sessions += bucket.Sessions
+		stats.Summary.TotalVolume += bucket.TotalVolume
+		stats.Summary.TotalDurationMinutes += bucket.TotalDurationMinutes
+		stats.Summary.CaloriesBurned += bucket.CaloriesBurned
+	}
+	sort.Slice(stats.Buckets, func(i, j int) bool { return stats.Buckets[i].Start.Before(stats.Buckets[j].Start) })
+	if stats.Summary.Sessions > 0 {
+		stats.Summary.AvgDurationMinutes = float64(stats.Summary.TotalDurationMinutes) / float64(stats.Summary.Sessions)
+	}
+
+	if stats.MuscleGroups, err = pg.muscleGroupVolume(q); err != nil {
+		return nil, fmt.Errorf("analytics muscle groups: %w", err)
+	}
+	if stats.Exercises, err = pg.progression(q); err != nil {
+		return nil, fmt.Errorf("analytics progression: %w", err)
+	}
+	return stats, nil
+}
+
+func (pg *PostgresStore) sessionBuckets(q StatsQuery) (map[time.Time]*Bucket, error) {
+	query := `
+	SELECT ` + bucketSQL + ` AS bucket,
+		COUNT(*),
+		COALESCE(SUM(w.duration_minutes), 0),
+		COALESCE(AVG(w.duration_minutes), 0),
+		COALESCE(SUM(w.calories_burned), 0)
+	FROM workouts w
+	WHERE w.user_id = $1 AND w.performed_at >= $3 AND w.performed_at < $4
+	GROUP BY bucket
+	`
+	rows, err := pg.db.Query(query, q.UserID, q.Bucket, q.From, q.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := map[time.Time]*Bucket{}
+	for rows.Next() {
+		bucket := &Bucket{}
+		err := rows.Scan(&bucket.Start, &bucket.Sessions, &bucket.TotalDurationMinutes, &bucket.AvgDurationMinutes, &bucket.CaloriesBurned)
+		if err != nil {
+			return nil, err
+		}
+		buckets[bucket.Start] = bucket
+	}
+	return buckets, rows.Err()
+}
+
+func (pg *PostgresStore) addVolume(q StatsQuery, buckets map[time.Time]*Bucket) error {
+	query := `
+	SELECT ` + bucketSQL + ` AS bucket, COALESCE(SUM(` + volumeSQL + `), 0)
+	FROM workout_sets s
+	INNER JOIN workout_entries e ON e.id = s.entry_id
+	INNER JOIN workouts w ON w.id = e.workout_id
+	WHERE w.user_id = $1 AND w.performed_at >= $3 AND w.performed_at < $4 AND s.set_type <> 'warmup'
+	GROUP BY bucket
+	`
+	rows, err := pg.db.Query(query, q.UserID, q.Bucket, q.From, q.To)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var start time.Time
+		var volume float64
+		if err := rows.Scan(&start, &volume); err != nil {
+			return err
+		}
+		if bucket, ok := buckets[start]; ok {
+			bucket.TotalVolume = volume
+		}
+	}
+	return rows.Err()
+}
+
+func (pg *PostgresStore) muscleGroupVolume(q StatsQuery) ([]MuscleGroupVolume, error) {
+	query := `
+	SELECT ` + bucketSQL + ` AS bucket, m.muscle, COUNT(*), COALESCE(SUM(` + volumeSQL + `), 0)
+	FROM workout_sets s
+	INNER JOIN workout_entries e ON e.id = s.entry_id
+	INNER JOIN workouts w ON w.id = e.workout_id
+	INNER JOIN exercises x ON x.id = e.exercise_id
+	CROSS JOIN LATERAL unnest(x.primary_muscles) AS m (muscle)
+	WHERE w.user_id = $1 AND w.performed_at >= $3 AND w.performed_at < $4 AND s.set_type <> 'warmup'
+	GROUP BY bucket, m.muscle
+	ORDER BY bucket, m.muscle
+	`
+	rows, err := pg.db.Query(query, q.UserID, q.Bucket, q.From, q.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	volumes := []MuscleGroupVolume{}
+	for rows.Next() {
+		var v MuscleGroupVolume
+		if err := rows.Scan(&v.Start, &v.Muscle, &v.Sets, &v.Volume); err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, v)
+	}
+	return volumes, rows.Err()
+}
+
+func (pg *PostgresStore) progression(q StatsQuery) ([]ExerciseProgression, error) {
+	query := `
+	SELECT e.exercise_id, x.name, ` + bucketSQL + ` AS bucket,
+		COALESCE(MAX(s.weight), 0),
+		COALESCE(MAX(` + estimated1RMSQL + `), 0),
+		COALESCE(SUM(` + volumeSQL + `), 0)
+	FROM workout_sets s
+	INNER JOIN workout_entries e ON e.id = s.entry_id
+	INNER JOIN workouts w ON w.id = e.workout_id
+	INNER JOIN exercises x ON x.id = e.exercise_id
+	WHERE w.user_id = $1 AND w.performed_at >= $3 AND w.performed_at < $4 AND s.set_type <> 'warmup'
+		AND ($5::bigint IS NULL OR e.exercise_id = $5)
+	GROUP BY e.exercise_id, x.name, bucket
+	ORDER BY x.name, e.exercise_id, bucket
+	`
+	rows, err := pg.db.Query(query, q.UserID, q.Bucket, q.From, q.To, q.ExerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := []ExerciseProgression{}
+	for rows.Next() {
+		var exerciseID int
+		var name string
+		var point ProgressionPoint
+		if err := rows.Scan(&exerciseID, &name, &point.Start, &point.MaxWeight, &point.Estimated1RM, &point.Volume); err != nil {
+			return nil, err
+		}
+		if len(series) == 0 || series[len(series)-1].ExerciseID != exerciseID {
+			series = append(series, ExerciseProgression{ExerciseID: exerciseID, ExerciseName: name})
+		}
+		last := &series[len(series)-1]
+		last.Points = append(last.Points, point)
+	}
+	return series, rows.Err()
+}
@@ -0,0 +1,179 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupTestDB connects to the database the store tests use. Tables are not
+// truncated, so the store tests running alongside keep their rows; each
+// test removes the users it creates instead.
+func setupTestDB(t *testing.T) *sql.DB {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		dsn = "host=localhost user=postgres password=postgres dbname=postgres port=5433 sslmode=disable"
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("opening test db: %v", err)
+	}
+
+	// migrated the way the server does, under the migration lock
+	migrator, err := store.NewMigrator(db, migrations.FS)
+	if err != nil {
+		t.Fatalf("migrating test db error: %v", err)
+	}
+	_, err = migrator.Up(context.Background())
+	if err != nil {
+		t.Fatalf("migrating test db error: %v", err)
+	}
+	return db
+}
+
+// createTestUser replaces any user left over by an earlier run under the
+// same name, along with their workouts and exercises.
+func createTestUser(t *testing.T, db *sql.DB, username string) *store.User {
+	_, err := db.Exec(`DELETE FROM users WHERE username = $1`, username)
+	require.NoError(t, err)
+	user := &store.User{Username: username, Email: username + "@example.com"}
+	require.NoError(t, user.PasswordHash.Set("secret-password"))
+	require.NoError(t, store.NewPostgresUserStore(db).CreateUser(user))
+	return user
+}
+
+func TestStatsQueryValidate(t *testing.T) {
+	to := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, -3, 0)
+
+	assert.NoError(t, StatsQuery{From: from, To: to, Bucket: BucketWeek}.Validate())
+	assert.ErrorIs(t, StatsQuery{From: from, To: to, Bucket: "year"}.Validate(), ErrInvalidBucket)
+	assert.Error(t, StatsQuery{From: to, To: from, Bucket: BucketMonth}.Validate())
+}
+
+func TestGetStats(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	workoutStore := store.NewPostgresWorkoutStore(db)
+	user := createTestUser(t, db, "analytics_lifter")
+	other := createTestUser(t, db, "analytics_bystander")
+	bench := &store.Exercise{UserID: &user.ID, Name: "Floor Press", PrimaryMuscles: []string{"chest", "triceps"}}
+	require.NoError(t, store.NewPostgresExerciseStore(db).CreateCustomExercise(bench))
+
+	set := func(setType string, reps int, weight float64) store.WorkoutSet {
+		return store.WorkoutSet{SetType: setType, Reps: intPtr(reps), Weight: floatPtr(weight)}
+	}
+	createWorkout := func(userID int, performedAt time.Time, duration, calories int, sets ...store.WorkoutSet) {
+		workout := &store.Workout{UserID: userID, Title: "push", DurationMinutes: duration, CaloriesBurned: calories,
+			PerformedAt: performedAt, Timezone: "America/New_York"}
+		if len(sets) > 0 {
+			workout.Entries = []store.WorkoutEntry{{ExerciseID: &bench.ID, OrderIndex: 1, SetDetails: sets}}
+		}
+		_, err := workoutStore.CreateWorkout(workout)
+		require.NoError(t, err)
+	}
+	// Sunday evening in New York but Monday in UTC: it belongs to the week
+	// of June 2nd
+	createWorkout(user.ID, time.Date(2025, 6, 9, 2, 0, 0, 0, time.UTC), 60, 300,
+		set(store.SetTypeWarmup, 10, 40),
+		set(store.SetTypeWorking, 5, 100), // Brzycki: 112.5
+		set(store.SetTypeWorking, 12, 60), // Epley: 84
+	)
+	createWorkout(user.ID, time.Date(2025, 6, 10, 12, 0, 0, 0, time.UTC), 30, 200,
+		set(store.SetTypeWorking, 1, 120), // a single is its own e1RM
+	)
+	createWorkout(user.ID, time.Date(2025, 6, 12, 12, 0, 0, 0, time.UTC), 40, 0)
+	createWorkout(other.ID, time.Date(2025, 6, 10, 12, 0, 0, 0, time.UTC), 90, 900, set(store.SetTypeWorking, 5, 200))
+	// outside the range
+	createWorkout(user.ID, time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC), 90, 900, set(store.SetTypeWorking, 5, 200))
+
+	stats, err := NewPostgresStore(db).GetStats(StatsQuery{
+		UserID: user.ID,
+		From:   time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+		To:     time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC),
+		Bucket: BucketWeek,
+	})
+	require.NoError(t, err)
+
+	june2 := time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC)
+	june9 := time.Date(2025, 6, 9, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, Summary{Sessions: 3, TotalVolume: 1340, TotalDurationMinutes: 130, AvgDurationMinutes: 130.0 / 3, CaloriesBurned: 500}, stats.Summary)
+	require.Len(t, stats.Buckets, 2)
+	assert.True(t, stats.Buckets[0].Start.Equal(june2), "the Sunday workout stays in its local week, got %s", stats.Buckets[0].Start)
+	assert.Equal(t, Summary{Sessions: 1, TotalVolume: 500 + 720, TotalDurationMinutes: 60, AvgDurationMinutes: 60, CaloriesBurned: 300}, stats.Buckets[0].Summary, "warm-ups carry no volume")
+	assert.True(t, stats.Buckets[1].Start.Equal(june9))
+	assert.Equal(t, Summary{Sessions: 2, TotalVolume: 120, TotalDurationMinutes: 70, AvgDurationMinutes: 35, CaloriesBurned: 200}, stats.Buckets[1].Summary)
+
+	require.Len(t, stats.MuscleGroups, 4)
+	for i, want := range []MuscleGroupVolume{
+		{Start: june2, Muscle: "chest", Sets: 2, Volume: 1220},
+		{Start: june2, Muscle: "triceps", Sets: 2, Volume: 1220},
+		{Start: june9, Muscle: "chest", Sets: 1, Volume: 120},
+		{Start: june9, Muscle: "triceps", Sets: 1, Volume: 120},
+	} {
+		got := stats.MuscleGroups[i]
+		assert.True(t, got.Start.Equal(want.Start))
+		assert.Equal(t, want.Muscle, got.Muscle)
+		assert.Equal(t, want.Sets, got.Sets)
+		assert.Equal(t, want.Volume, got.Volume)
+	}
+
+	require.Len(t, stats.Exercises, 1)
+	progression := stats.Exercises[0]
+	assert.Equal(t, bench.ID, progression.ExerciseID)
+	assert.Equal(t, "Floor Press", progression.ExerciseName)
+	require.Len(t, progression.Points, 2)
+	assert.Equal(t, 100.0, progression.Points[0].MaxWeight)
+	assert.InDelta(t, 112.5, progression.Points[0].Estimated1RM, 0.001, "the best of Brzycki at 5 reps and Epley at 12")
+	assert.Equal(t, 1220.0, progression.Points[0].Volume)
+	assert.Equal(t, 120.0, progression.Points[1].MaxWeight)
+	assert.InDelta(t, 120, progression.Points[1].Estimated1RM, 0.001)
+	assert.Equal(t, 120.0, progression.Points[1].Volume)
+}
+
+func TestEstimated1RMSQL(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	tests := []struct {
+		reps   *int
+		weight *float64
+		want   *float64
+	}{
+		{reps: intPtr(1), weight: floatPtr(100), want: floatPtr(100)},
+		{reps: intPtr(5), weight: floatPtr(100), want: floatPtr(store.EstimateOneRepMax(100, 5))},
+		{reps: intPtr(10), weight: floatPtr(100), want: floatPtr(store.EstimateOneRepMax(100, 10))},
+		{reps: intPtr(12), weight: floatPtr(100), want: floatPtr(store.EstimateOneRepMax(100, 12))},
+		{reps: intPtr(5), weight: nil},
+		{reps: intPtr(5), weight: floatPtr(0)},
+		{reps: nil, weight: floatPtr(100)},
+		{reps: intPtr(0), weight: floatPtr(100)},
+	}
+	for _, tt := range tests {
+		var got sql.NullFloat64
+		err := db.QueryRow(`SELECT `+estimated1RMSQL+` FROM (SELECT $1::int AS reps, $2::float8 AS weight) s`, tt.reps, tt.weight).Scan(&got)
+		require.NoError(t, err)
+		if tt.want == nil {
+			assert.False(t, got.Valid, "reps %v, weight %v", tt.reps, tt.weight)
+			continue
+		}
+		assert.InDelta(t, *tt.want, got.Float64, 0.001, "reps %v, weight %v", *tt.reps, *tt.weight)
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/utils"
+)
+
+// AdminHandler serves the endpoints reserved to admins, see
+// middleware.RequireAdmin.
+type AdminHandler struct {
+	loginAttemptStore store.LoginAttemptStore
+	logger            *slog.Logger
+}
+
+func NewAdminHandler(loginAttemptStore store.LoginAttemptStore, logger *slog.Logger) *AdminHandler {
+	return &AdminHandler{
+		loginAttemptStore: loginAttemptStore,
+		logger:            logger,
+	}
+}
+
+// HandleListLoginAttempts returns the sign in audit trail, newest first, eg.
+// /admin/login-attempts?username=alice&failed=true&limit=20. Older attempts
+// are paged through with before, the attempted_at of the last one returned.
+func (h *AdminHandler) HandleListLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	filter, err := readLoginAttemptFilter(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	attempts, err := h.loginAttemptStore.ListLoginAttempts(filter)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "ListLoginAttempts", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"login_attempts": attempts})
+}
+
+func readLoginAttemptFilter(r *http.Request) (store.LoginAttemptFilter, error) {
+	query := r.URL.Query()
+	filter := store.LoginAttemptFilter{
+		Username: query.Get("username"),
+		IP:       query.Get("ip"),
+	}
+
+	switch query.Get("failed") {
+	case "", "false":
+	case "true":
+		filter.FailedOnly = true
+	default:
+		return filter, fmt.Errorf("failed must be true or false")
+	}
+
+	var err error
+	if filter.Before, err = readQueryTime(r, "before"); err != nil {
+		return filter, err
+	}
+
+	limit, err := readQueryInt(r, "limit")
+	if err != nil {
+		return filter, err
+	}
+	if limit != nil {
+		if *limit < 1 || *limit > store.MaxLoginAttemptPageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d", store.MaxLoginAttemptPageSize)
+		}
+		filter.Limit = *limit
+	}
+	return filter, nil
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/events"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/utils"
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+// streamHeartbeatInterval keeps idle streams alive through proxies and
+// notices clients that went away without closing.
+const streamHeartbeatInterval = 25 * time.Second
+
+type EventHandler struct {
+	broker       *events.Broker
+	workoutStore store.WorkoutStore
+	sessionStore store.SessionStore
+	logger       *slog.Logger
+}
+
+func NewEventHandler(broker *events.Broker, workoutStore store.WorkoutStore, sessionStore store.SessionStore, logger *slog.Logger) *EventHandler {
+	return &EventHandler{broker: broker, workoutStore: workoutStore, sessionStore: sessionStore, logger: logger}
+}
+
+// subscribe reads the optional workout_id and session_id filters, checks the
+// current user owns what they name and subscribes to the user's events,
+// writing the error response otherwise.
+func (eh *EventHandler) subscribe(w http.ResponseWriter, r *http.Request) *events.Subscription {
+	currentUser := middleware.GetUser(r)
+
+	var filter events.Filter
+	var err error
+	filter.WorkoutID, err = readQueryInt(r, "workout_id")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return nil
+	}
+	filter.SessionID, err = readQueryInt(r, "session_id")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return nil
+	}
+
+	if filter.WorkoutID != nil {
+		ownerID, err := eh.workoutStore.GetWorkoutOwner(int64(*filter.WorkoutID))
+		if err == sql.ErrNoRows {
+			utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Workout not found"})
+			return nil
+		}
+		if err != nil {
+			eh.logger.ErrorContext(r.Context(), "GetWorkoutOwner", "error", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+			return nil
+		}
+		if ownerID != currentUser.ID {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"Error": "Forbidden - You are not the owner of this workout"})
+			return nil
+		}
+	}
+
+	if filter.SessionID != nil {
+		session, err := eh.sessionStore.GetSession(int64(*filter.SessionID))
+		if err != nil {
+			eh.logger.ErrorContext(r.Context(), "GetSession", "error", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+			return nil
+		}
+		if session == nil || session.UserID != currentUser.ID {
+			utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Session not found"})
+			return nil
+		}
+	}
+
+	return eh.broker.Subscribe(currentUser.ID, filter)
+}
+
+// HandleStreamEvents streams the current user's workout and session events
+// as Server-Sent Events, eg. /events?session_id=12
+func (eh *EventHandler) HandleStreamEvents(w http.ResponseWriter, r *http.Request) {
+	sub := eh.subscribe(w, r)
+	if sub == nil {
+		return
+	}
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	// the server's write timeout is meant for regular requests, not streams
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		eh.logger.ErrorContext(r.Context(), "SetWriteDeadline", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Streaming not supported"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case event, ok := <-sub.C:
+			if !ok {
+				// dropped for falling behind, the client will reconnect
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				eh.logger.ErrorContext(r.Context(), "encoding event", "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// HandleStreamEventsWebSocket streams the same events as HandleStreamEvents
+// over a WebSocket, one JSON message per event.
+func (eh *EventHandler) HandleStreamEventsWebSocket(w http.ResponseWriter, r *http.Request) {
+	sub := eh.subscribe(w, r)
+	if sub == nil {
+		return
+	}
+	defer sub.Close()
+
+	// deadlines set by the server carry over to the hijacked connection
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		eh.logger.ErrorContext(r.Context(), "websocket.Accept", "error", err)
+		return
+	}
+	defer conn.CloseNow()
+
+	// the stream is one way; CloseRead handles pings and the close handshake
+	ctx := conn.CloseRead(r.Context())
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			pingCtx, cancel := context.WithTimeout(ctx, streamHeartbeatInterval)
+			err = conn.Ping(pingCtx)
+			cancel()
+		case event, ok := <-sub.C:
+			if !ok {
+				if sub.Dropped() {
+					conn.Close(websocket.StatusTryAgainLater, "too slow")
+				} else {
+					conn.Close(websocket.StatusGoingAway, "server shutting down")
+				}
+				return
+			}
+			err = wsjson.Write(ctx, conn, event)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
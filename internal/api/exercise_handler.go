@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/utils"
+)
+
+type ExerciseHandler struct {
+	exerciseStore store.ExerciseStore
+	logger        *slog.Logger
+}
+
+type createExerciseRequest struct {
+	Name             string   `json:"name"`
+	Aliases          []string `json:"aliases"`
+	PrimaryMuscles   []string `json:"primary_muscles"`
+	SecondaryMuscles []string `json:"secondary_muscles"`
+	Equipment        string   `json:"equipment"`
+	MovementPattern  string   `json:"movement_pattern"`
+	MetricType       string   `json:"metric_type"`
+}
+
+// maxExerciseAliases caps the aliases of a custom exercise, which are all
+// matched by every search.
+const maxExerciseAliases = 20
+
+func NewExerciseHandler(exerciseStore store.ExerciseStore, logger *slog.Logger) *ExerciseHandler {
+	return &ExerciseHandler{exerciseStore: exerciseStore, logger: logger}
+}
+
+// HandleSearchExercises lists catalog and custom exercises, eg.
+// /exercises?q=bench&muscle=chest&equipment=barbell
+func (eh *ExerciseHandler) HandleSearchExercises(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+	query := r.URL.Query()
+
+	limit, err := readQueryInt(r, "limit")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	filter := store.ExerciseFilter{
+		UserID:    currentUser.ID,
+		Query:     query.Get("q"),
+		Muscle:    query.Get("muscle"),
+		Equipment: query.Get("equipment"),
+	}
+	if limit != nil {
+		if *limit < 1 || *limit > store.MaxExercisePageSize {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": fmt.Sprintf("limit must be between 1 and %d", store.MaxExercisePageSize)})
+			return
+		}
+		filter.Limit = *limit
+	}
+
+	exercises, err := eh.exerciseStore.SearchExercises(filter)
+	if err != nil {
+		eh.logger.ErrorContext(r.Context(), "SearchExercises", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Exercises": exercises})
+}
+
+func (eh *ExerciseHandler) HandleGetExerciseByID(w http.ResponseWriter, r *http.Request) {
+	exerciseID, err := utils.ReadIDParam(r)
+	if err != nil {
+		eh.logger.ErrorContext(r.Context(), "ReadIDParam", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Exercise Id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	exercise, err := eh.exerciseStore.GetExerciseByID(exerciseID, currentUser.ID)
+	if err != nil {
+		eh.logger.ErrorContext(r.Context(), "GetExerciseByID", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	if exercise == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Exercise not found"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Exercise": exercise})
+}
+
+func (eh *ExerciseHandler) validateCreateExerciseRequest(req *createExerciseRequest) error {
+	if store.NormalizeExerciseName(req.Name) == "" {
+		return errors.New("name is required")
+	}
+
+	if len(req.Name) > 255 {
+		return errors.New("name cannot be greater than 255")
+	}
+
+	if len(req.Equipment) > 64 {
+		return errors.New("equipment cannot be greater than 64")
+	}
+
+	if len(req.MovementPattern) > 64 {
+		return errors.New("movement_pattern cannot be greater than 64")
+	}
+
+	if len(req.Aliases) > maxExerciseAliases {
+		return fmt.Errorf("an exercise cannot have more than %d aliases", maxExerciseAliases)
+	}
+	for _, alias := range req.Aliases {
+		if len(alias) > 255 {
+			return errors.New("aliases cannot be greater than 255")
+		}
+	}
+
+	if req.MetricType != "" && req.MetricType != store.MetricTypeReps && req.MetricType != store.MetricTypeDuration {
+		return errors.New("metric_type must be reps or duration")
+	}
+	return nil
+}
+
+func (eh *ExerciseHandler) HandleCreateExercise(w http.ResponseWriter, r *http.Request) {
+	var req createExerciseRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		eh.logger.ErrorContext(r.Context(), "decoding create exercise request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	err = eh.validateCreateExerciseRequest(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	exercise := &store.Exercise{
+		UserID:           &currentUser.ID,
+		Name:             strings.TrimSpace(req.Name),
+		Aliases:          req.Aliases,
+		PrimaryMuscles:   lowerAll(req.PrimaryMuscles),
+		SecondaryMuscles: lowerAll(req.SecondaryMuscles),
+		Equipment:        strings.ToLower(req.Equipment),
+		MovementPattern:  strings.ToLower(req.MovementPattern),
+		MetricType:       req.MetricType,
+	}
+
+	err = eh.exerciseStore.CreateCustomExercise(exercise)
+	if errors.Is(err, store.ErrDuplicateExercise) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		eh.logger.ErrorContext(r.Context(), "CreateCustomExercise", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to create exercise"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"Exercise": exercise})
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, 0, len(values))
+	for _, v := range values {
+		lowered = append(lowered, strings.ToLower(strings.TrimSpace(v)))
+	}
+	return lowered
+}
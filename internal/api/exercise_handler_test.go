@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeExerciseStore records the filter of the last search.
+type fakeExerciseStore struct {
+	store.ExerciseStore
+	filter store.ExerciseFilter
+}
+
+func (s *fakeExerciseStore) SearchExercises(filter store.ExerciseFilter) ([]*store.Exercise, error) {
+	s.filter = filter
+	return []*store.Exercise{}, nil
+}
+
+func TestSearchExercisesLimit(t *testing.T) {
+	exercises := &fakeExerciseStore{}
+	handler := NewExerciseHandler(exercises, discardLogger)
+	user := &store.User{ID: 1}
+
+	tests := []struct {
+		target    string
+		wantCode  int
+		wantLimit int
+	}{
+		{target: "/exercises?q=bench", wantCode: http.StatusOK},
+		{target: "/exercises?limit=10", wantCode: http.StatusOK, wantLimit: 10},
+		{target: "/exercises?limit=100", wantCode: http.StatusOK, wantLimit: 100},
+		{target: "/exercises?limit=0", wantCode: http.StatusBadRequest},
+		{target: "/exercises?limit=101", wantCode: http.StatusBadRequest},
+		{target: "/exercises?limit=ten", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			exercises.filter = store.ExerciseFilter{}
+			rec := serve(handler.HandleSearchExercises, http.MethodGet, tt.target, nil, user)
+			assert.Equal(t, tt.wantCode, rec.Code, rec.Body.String())
+			assert.Equal(t, tt.wantLimit, exercises.filter.Limit)
+		})
+	}
+}
+
+func TestCreateExerciseValidation(t *testing.T) {
+	handler := NewExerciseHandler(&fakeExerciseStore{}, discardLogger)
+	user := &store.User{ID: 1}
+	long := strings.Repeat("a", 65)
+
+	tests := []struct {
+		name string
+		req  createExerciseRequest
+	}{
+		{name: "equipment", req: createExerciseRequest{Name: "Curl", Equipment: long}},
+		{name: "movement_pattern", req: createExerciseRequest{Name: "Curl", MovementPattern: long}},
+		{name: "long alias", req: createExerciseRequest{Name: "Curl", Aliases: []string{strings.Repeat("a", 256)}}},
+		{name: "too many aliases", req: createExerciseRequest{Name: "Curl", Aliases: make([]string, maxExerciseAliases+1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(handler.HandleCreateExercise, http.MethodPost, "/exercises", tt.req, user)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,488 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/mailer"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/tokens"
+	"github.com/stretchr/testify/require"
+)
+
+// The fakes below keep users and tokens in memory, so handlers can be tested
+// without a database. They follow the postgres stores closely enough for the
+// flows under test; the stores have their own tests against postgres.
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+var (
+	_ store.TokenStore        = (*fakeTokenStore)(nil)
+	_ store.UserStore         = (*fakeUserStore)(nil)
+	_ store.MFAStore          = (*fakeMFAStore)(nil)
+	_ store.LoginAttemptStore = (*fakeLoginAttemptStore)(nil)
+	_ mailer.Mailer           = (*fakeMailer)(nil)
+)
+
+type fakeTokenStore struct {
+	mu         sync.Mutex
+	tokens     []*tokens.Token
+	nextID     int
+	nextFamily int64
+}
+
+func (s *fakeTokenStore) Insert(token *tokens.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	token.ID = s.nextID
+	s.tokens = append(s.tokens, token)
+	return nil
+}
+
+func (s *fakeTokenStore) CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error) {
+	token, err := tokens.GenerateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	return token, s.Insert(token)
+}
+
+func (s *fakeTokenStore) DeleteAllTokensForUser(userID int, scope string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = slices.DeleteFunc(s.tokens, func(t *tokens.Token) bool {
+		return t.UserID == userID && t.Scope == scope
+	})
+	return nil
+}
+
+// lookup returns the live token of scope with the given plain text.
+func (s *fakeTokenStore) lookup(scope, plainText string) *tokens.Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := sha256.Sum256([]byte(plainText))
+	for _, t := range s.tokens {
+		if t.Scope == scope && bytes.Equal(t.Hash, hash[:]) && (t.Expiry.IsZero() || t.Expiry.After(time.Now())) {
+			return t
+		}
+	}
+	return nil
+}
+
+// count returns how many tokens of scope userID has.
+func (s *fakeTokenStore) count(userID int, scope string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, t := range s.tokens {
+		if t.UserID == userID && t.Scope == scope {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *fakeTokenStore) RecordTokenUsage(tokenPlainText, userAgent, ip string) (*store.TokenInfo, error) {
+	for _, scope := range []string{tokens.ScopeAuth, tokens.ScopePersonalAccess} {
+		if t := s.lookup(scope, tokenPlainText); t != nil {
+			return &store.TokenInfo{ID: t.ID, Scopes: t.AccessScopes, UserAgent: userAgent, IP: ip}, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeTokenStore) ListTokens(userID int, scope string) ([]*store.TokenInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := []*store.TokenInfo{}
+	for _, t := range s.tokens {
+		if t.UserID == userID && t.Scope == scope {
+			expiry := t.Expiry
+			infos = append(infos, &store.TokenInfo{ID: t.ID, Name: t.Name, Scopes: t.AccessScopes, Expiry: &expiry})
+		}
+	}
+	return infos, nil
+}
+
+func (s *fakeTokenStore) DeleteToken(userID int, scope string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.tokens)
+	s.tokens = slices.DeleteFunc(s.tokens, func(t *tokens.Token) bool {
+		return t.UserID == userID && t.Scope == scope && int64(t.ID) == id
+	})
+	if len(s.tokens) == n {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *fakeTokenStore) CreateTokenPair(userID int, accessTTL, refreshTTL time.Duration) (*tokens.Token, *tokens.Token, error) {
+	s.mu.Lock()
+	s.nextFamily++
+	family := s.nextFamily
+	s.mu.Unlock()
+	return s.insertTokenPair(userID, family, accessTTL, refreshTTL)
+}
+
+func (s *fakeTokenStore) insertTokenPair(userID int, family int64, accessTTL, refreshTTL time.Duration) (*tokens.Token, *tokens.Token, error) {
+	var pair []*tokens.Token
+	for _, scope := range []string{tokens.ScopeAuth, tokens.ScopeRefresh} {
+		ttl := accessTTL
+		if scope == tokens.ScopeRefresh {
+			ttl = refreshTTL
+		}
+		token, err := tokens.GenerateToken(userID, ttl, scope)
+		if err != nil {
+			return nil, nil, err
+		}
+		token.FamilyID = family
+		if err := s.Insert(token); err != nil {
+			return nil, nil, err
+		}
+		pair = append(pair, token)
+	}
+	return pair[0], pair[1], nil
+}
+
+func (s *fakeTokenStore) ListSessions(userID int, currentTokenID int) ([]*store.TokenInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := []*store.TokenInfo{}
+	sessions := map[int64]*store.TokenInfo{}
+	for _, t := range s.tokens {
+		if t.UserID != userID || t.FamilyID == 0 || (t.Scope != tokens.ScopeAuth && t.Scope != tokens.ScopeRefresh) {
+			continue
+		}
+		info := sessions[t.FamilyID]
+		if info == nil {
+			info = &store.TokenInfo{ID: int(t.FamilyID)}
+			sessions[t.FamilyID] = info
+			infos = append(infos, info)
+		}
+		info.Current = info.Current || t.ID == currentTokenID
+	}
+	return infos, nil
+}
+
+func (s *fakeTokenStore) DeleteSession(userID int, sessionID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.tokens)
+	s.tokens = slices.DeleteFunc(s.tokens, func(t *tokens.Token) bool {
+		return t.UserID == userID && t.FamilyID == sessionID
+	})
+	if len(s.tokens) == n {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *fakeTokenStore) RotateRefreshToken(refreshPlainText string, accessTTL, refreshTTL time.Duration) (*tokens.Token, *tokens.Token, error) {
+	refresh := s.lookup(tokens.ScopeRefresh, refreshPlainText)
+	if refresh == nil {
+		return nil, nil, store.ErrInvalidRefreshToken
+	}
+	s.mu.Lock()
+	s.tokens = slices.DeleteFunc(s.tokens, func(t *tokens.Token) bool {
+		return t == refresh || (t.FamilyID == refresh.FamilyID && t.Scope == tokens.ScopeAuth)
+	})
+	s.mu.Unlock()
+	return s.insertTokenPair(refresh.UserID, refresh.FamilyID, accessTTL, refreshTTL)
+}
+
+func (s *fakeTokenStore) DeleteExpiredTokens() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.tokens)
+	s.tokens = slices.DeleteFunc(s.tokens, func(t *tokens.Token) bool {
+		return !t.Expiry.IsZero() && !t.Expiry.After(time.Now())
+	})
+	return int64(n - len(s.tokens)), nil
+}
+
+func (s *fakeTokenStore) DeleteOtherSessions(userID int, keepTokenID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var family int64
+	for _, t := range s.tokens {
+		if t.ID == keepTokenID && t.UserID == userID {
+			family = t.FamilyID
+		}
+	}
+	s.tokens = slices.DeleteFunc(s.tokens, func(t *tokens.Token) bool {
+		session := t.Scope == tokens.ScopeAuth || t.Scope == tokens.ScopeRefresh || t.Scope == tokens.ScopeMFAChallenge
+		return t.UserID == userID && session && t.ID != keepTokenID && (t.FamilyID == 0 || t.FamilyID != family)
+	})
+	return nil
+}
+
+type fakeUserStore struct {
+	mu     sync.Mutex
+	users  []*store.User
+	tokens *fakeTokenStore
+}
+
+// find returns a copy of the first user matching, or nil.
+func (s *fakeUserStore) find(match func(u *store.User) bool) *store.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if match(u) {
+			user := *u
+			return &user
+		}
+	}
+	return nil
+}
+
+func (s *fakeUserStore) CreateUser(user *store.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Username == user.Username {
+			return store.ErrDuplicateUsername
+		}
+		if u.Email == user.Email {
+			return store.ErrDuplicateEmail
+		}
+	}
+	user.ID = len(s.users) + 1
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	stored := *user
+	s.users = append(s.users, &stored)
+	return nil
+}
+
+func (s *fakeUserStore) GetUserByUsername(username string) (*store.User, error) {
+	return s.find(func(u *store.User) bool { return u.Username == username }), nil
+}
+
+func (s *fakeUserStore) GetUserByEmail(email string) (*store.User, error) {
+	return s.find(func(u *store.User) bool { return u.Email == email }), nil
+}
+
+// update applies change to the stored user with the ID of user and copies
+// the result back into user.
+func (s *fakeUserStore) update(user *store.User, change func(stored *store.User)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.ID == user.ID {
+			change(u)
+			u.UpdatedAt = time.Now()
+			*user = *u
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (s *fakeUserStore) UpdateUser(user *store.User) error {
+	updated := *user
+	return s.update(user, func(stored *store.User) {
+		if stored.Email != updated.Email {
+			stored.Activated = false
+			stored.EmailVerifiedAt = nil
+		}
+		stored.Username = updated.Username
+		stored.Email = updated.Email
+		stored.Bio = updated.Bio
+	})
+}
+
+func (s *fakeUserStore) UpdatePassword(user *store.User) error {
+	hash := user.PasswordHash
+	return s.update(user, func(stored *store.User) { stored.PasswordHash = hash })
+}
+
+func (s *fakeUserStore) ActivateUser(user *store.User) error {
+	return s.update(user, func(stored *store.User) {
+		now := time.Now()
+		stored.Activated = true
+		stored.EmailVerifiedAt = &now
+	})
+}
+
+func (s *fakeUserStore) GetUserToken(scope, tokenPlainText string) (*store.User, error) {
+	token := s.tokens.lookup(scope, tokenPlainText)
+	if token == nil {
+		return nil, nil
+	}
+	return s.find(func(u *store.User) bool { return u.ID == token.UserID }), nil
+}
+
+// addUser creates an activated user with the given password.
+func (s *fakeUserStore) addUser(t *testing.T, username, password string) *store.User {
+	user := &store.User{Username: username, Email: username + "@example.com", Activated: true}
+	require.NoError(t, user.PasswordHash.Set(password))
+	require.NoError(t, s.CreateUser(user))
+	return user
+}
+
+// fakeMFAStore keeps one enrollment per user. Recovery codes are not
+// checked.
+type fakeMFAStore struct {
+	mu          sync.Mutex
+	enrollments map[int]store.TOTPEnrollment
+}
+
+func (s *fakeMFAStore) GetTOTP(userID int) (*store.TOTPEnrollment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.enrollments[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &e, nil
+}
+
+func (s *fakeMFAStore) SaveTOTPSecret(userID int, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.enrollments[userID].EnabledAt != nil {
+		return store.ErrTOTPAlreadyEnabled
+	}
+	if s.enrollments == nil {
+		s.enrollments = map[int]store.TOTPEnrollment{}
+	}
+	s.enrollments[userID] = store.TOTPEnrollment{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (s *fakeMFAStore) EnableTOTP(userID int, step int64, recoveryCodes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.enrollments[userID]
+	if !ok || e.EnabledAt != nil {
+		return store.ErrTOTPAlreadyEnabled
+	}
+	now := time.Now()
+	e.EnabledAt, e.LastUsedStep = &now, step
+	s.enrollments[userID] = e
+	return nil
+}
+
+func (s *fakeMFAStore) UseTOTPStep(userID int, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.enrollments[userID]
+	if e.LastUsedStep >= step {
+		return false, nil
+	}
+	e.LastUsedStep = step
+	s.enrollments[userID] = e
+	return true, nil
+}
+
+func (s *fakeMFAStore) UseRecoveryCode(userID int, code string) (bool, error) {
+	return false, nil
+}
+
+func (s *fakeMFAStore) ReplaceRecoveryCodes(userID int, recoveryCodes []string) error {
+	return nil
+}
+
+func (s *fakeMFAStore) DisableTOTP(userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.enrollments, userID)
+	return nil
+}
+
+// fakeLoginAttemptStore never throttles; it only keeps the attempts.
+type fakeLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts []store.LoginAttempt
+}
+
+func (s *fakeLoginAttemptStore) StartLoginAttempt(attempt *store.LoginAttempt, since time.Time) (*store.LoginFailures, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempt.ID = int64(len(s.attempts) + 1)
+	attempt.Success = false
+	attempt.Reason = store.LoginPending
+	attempt.AttemptedAt = time.Now()
+	s.attempts = append(s.attempts, *attempt)
+	return &store.LoginFailures{}, nil
+}
+
+func (s *fakeLoginAttemptStore) FinishLoginAttempt(attempt *store.LoginAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts[attempt.ID-1] = *attempt
+	return nil
+}
+
+func (s *fakeLoginAttemptStore) ListLoginAttempts(filter store.LoginAttemptFilter) ([]*store.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts := []*store.LoginAttempt{}
+	for i := len(s.attempts) - 1; i >= 0; i-- {
+		attempt := s.attempts[i]
+		attempts = append(attempts, &attempt)
+	}
+	return attempts, nil
+}
+
+func (s *fakeLoginAttemptStore) DeleteLoginAttemptsBefore(before time.Time) (int64, error) {
+	return 0, nil
+}
+
+// reasons returns the reason of every attempt, oldest first.
+func (s *fakeLoginAttemptStore) reasons() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reasons := make([]string, len(s.attempts))
+	for i, attempt := range s.attempts {
+		reasons[i] = attempt.Reason
+	}
+	return reasons
+}
+
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *fakeMailer) Send(msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *fakeMailer) messages() []mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.sent)
+}
+
+// serve calls handler with body encoded as JSON, as user when it is not
+// nil, and returns the recorded response.
+func serve(handler http.HandlerFunc, method, target string, body any, user *store.User) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	r := httptest.NewRequest(method, target, &buf)
+	if user != nil {
+		r = middleware.SetUser(r, user)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	return rec
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+)
+
+// LoginThrottle slows down password guessing. After a few free failures
+// every further failed attempt doubles the wait before the next one may be
+// made, up to a lockout of MaxDelay. Usernames and IP addresses are
+// throttled separately, IPs more leniently since many users can share one.
+type LoginThrottle struct {
+	UsernameFreeAttempts int
+	IPFreeAttempts       int
+	BaseDelay            time.Duration
+	MaxDelay             time.Duration
+	// Window is how far back failures are counted.
+	Window time.Duration
+}
+
+var DefaultLoginThrottle = LoginThrottle{
+	UsernameFreeAttempts: 5,
+	IPFreeAttempts:       50,
+	BaseDelay:            time.Second,
+	MaxDelay:             15 * time.Minute,
+	Window:               time.Hour,
+}
+
+// backoff is the wait imposed after failures failed attempts.
+func (t LoginThrottle) backoff(failures, free int) time.Duration {
+	if failures < free {
+		return 0
+	}
+	delay := t.BaseDelay
+	for i := free; i < failures && delay < t.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, t.MaxDelay)
+}
+
+// wait returns how long until another attempt is allowed, zero if it is
+// allowed now.
+func (t LoginThrottle) wait(f *store.LoginFailures, now time.Time) time.Duration {
+	byUsername := f.LastUsername.Add(t.backoff(f.Username, t.UsernameFreeAttempts)).Sub(now)
+	byIP := f.LastIP.Add(t.backoff(f.IP, t.IPFreeAttempts)).Sub(now)
+	return max(byUsername, byIP, 0)
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginThrottleBackoff(t *testing.T) {
+	throttle := DefaultLoginThrottle
+
+	assert.Zero(t, throttle.backoff(0, 5))
+	assert.Zero(t, throttle.backoff(4, 5))
+	assert.Equal(t, time.Second, throttle.backoff(5, 5))
+	assert.Equal(t, 2*time.Second, throttle.backoff(6, 5))
+	assert.Equal(t, 8*time.Second, throttle.backoff(8, 5))
+	assert.Equal(t, 15*time.Minute, throttle.backoff(30, 5))
+	assert.Equal(t, 15*time.Minute, throttle.backoff(1000, 5))
+}
+
+func TestLoginThrottleWait(t *testing.T) {
+	throttle := DefaultLoginThrottle
+	now := time.Now()
+
+	assert.Zero(t, throttle.wait(&store.LoginFailures{}, now))
+	assert.Zero(t, throttle.wait(&store.LoginFailures{Username: 4, LastUsername: now}, now))
+
+	wait := throttle.wait(&store.LoginFailures{Username: 7, LastUsername: now.Add(-time.Second)}, now)
+	assert.Equal(t, 3*time.Second, wait)
+
+	wait = throttle.wait(&store.LoginFailures{Username: 7, LastUsername: now.Add(-time.Minute)}, now)
+	assert.Zero(t, wait, "the backoff has passed")
+
+	wait = throttle.wait(&store.LoginFailures{Username: 1, LastUsername: now, IP: 60, LastIP: now}, now)
+	assert.Equal(t, 15*time.Minute, wait, "a busy IP is locked out regardless of the username")
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/totp"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/utils"
+)
+
+// totpIssuer names the account in authenticator apps.
+const totpIssuer = "Workouts"
+
+type MFAHandler struct {
+	mfaStore   store.MFAStore
+	tokenStore store.TokenStore
+	logger     *slog.Logger
+}
+
+type confirmTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+// reauthenticateRequest proves it is really the account owner making a
+// sensitive change: the current password plus a code from the
+// authenticator app or, when the device is lost, a recovery code.
+type reauthenticateRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Code            string `json:"code"`
+	RecoveryCode    string `json:"recovery_code"`
+}
+
+func NewMFAHandler(mfaStore store.MFAStore, tokenStore store.TokenStore, logger *slog.Logger) *MFAHandler {
+	return &MFAHandler{
+		mfaStore:   mfaStore,
+		tokenStore: tokenStore,
+		logger:     logger,
+	}
+}
+
+// verifySecondFactor checks a TOTP code or, when none is given, a recovery
+// code, and spends it so it cannot be used twice.
+func verifySecondFactor(mfaStore store.MFAStore, enrollment *store.TOTPEnrollment, code, recoveryCode string) (bool, error) {
+	if code != "" {
+		step, ok := totp.Validate(enrollment.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return mfaStore.UseTOTPStep(enrollment.UserID, step)
+	}
+	if recoveryCode != "" {
+		return mfaStore.UseRecoveryCode(enrollment.UserID, recoveryCode)
+	}
+	return false, nil
+}
+
+// HandleEnrollTOTP starts two-factor enrollment, handing out the secret to
+// add to an authenticator app. It only takes effect once confirmed with a
+// code through HandleConfirmTOTP.
+func (h *MFAHandler) HandleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GenerateSecret", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	err = h.mfaStore.SaveTOTPSecret(user.ID, secret)
+	if err == store.ErrTOTPAlreadyEnabled {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "SaveTOTPSecret", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, user.Username, secret),
+	})
+}
+
+// HandleConfirmTOTP enables two-factor authentication once the user proves
+// their app produces valid codes, and hands out the recovery codes. They
+// are shown only this once. Every other session is signed out, since it
+// may have been opened with the password alone.
+func (h *MFAHandler) HandleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req confirmTOTPRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "decoding confirm totp request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	enrollment, err := h.mfaStore.GetTOTP(user.ID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GetTOTP", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+	if enrollment == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "start two-factor enrollment first"})
+		return
+	}
+	if enrollment.Enabled() {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": store.ErrTOTPAlreadyEnabled.Error()})
+		return
+	}
+
+	step, ok := totp.Validate(enrollment.Secret, req.Code, time.Now())
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, utils.Envelope{"Error": "invalid code"})
+		return
+	}
+
+	recoveryCodes, err := store.GenerateRecoveryCodes()
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GenerateRecoveryCodes", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	err = h.mfaStore.EnableTOTP(user.ID, step, recoveryCodes)
+	if err == store.ErrTOTPAlreadyEnabled {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "EnableTOTP", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	err = h.tokenStore.DeleteOtherSessions(user.ID, middleware.GetTokenID(r))
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "DeleteOtherSessions", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"recovery_codes": recoveryCodes})
+}
+
+// reauthenticate checks a reauthenticateRequest against the current user's
+// password and second factor. It writes the error response and returns nil
+// when the check fails.
+func (h *MFAHandler) reauthenticate(w http.ResponseWriter, r *http.Request) *store.TOTPEnrollment {
+	var req reauthenticateRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "decoding reauthenticate request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return nil
+	}
+
+	user := middleware.GetUser(r)
+	enrollment, err := h.mfaStore.GetTOTP(user.ID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GetTOTP", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return nil
+	}
+	if !enrollment.Enabled() {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "two-factor authentication is not enabled"})
+		return nil
+	}
+
+	passwordDoMatch, err := user.PasswordHash.Matches(req.CurrentPassword)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "PasswordHash.Matches", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return nil
+	}
+	if !passwordDoMatch {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "current password is incorrect"})
+		return nil
+	}
+
+	ok, err := verifySecondFactor(h.mfaStore, enrollment, req.Code, req.RecoveryCode)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "verifySecondFactor", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return nil
+	}
+	if !ok {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "invalid code"})
+		return nil
+	}
+	return enrollment
+}
+
+func (h *MFAHandler) HandleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	enrollment := h.reauthenticate(w, r)
+	if enrollment == nil {
+		return
+	}
+
+	err := h.mfaStore.DisableTOTP(enrollment.UserID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "DisableTOTP", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleRegenerateRecoveryCodes replaces all recovery codes, used or not,
+// with a fresh set.
+func (h *MFAHandler) HandleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	enrollment := h.reauthenticate(w, r)
+	if enrollment == nil {
+		return
+	}
+
+	recoveryCodes, err := store.GenerateRecoveryCodes()
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GenerateRecoveryCodes", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	err = h.mfaStore.ReplaceRecoveryCodes(enrollment.UserID, recoveryCodes)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "ReplaceRecoveryCodes", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"recovery_codes": recoveryCodes})
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/tokens"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfirmTOTPSignsOutOtherSessions(t *testing.T) {
+	tokenStore := &fakeTokenStore{}
+	users := &fakeUserStore{tokens: tokenStore}
+	mfaStore := &fakeMFAStore{}
+	handler := NewMFAHandler(mfaStore, tokenStore, discardLogger)
+	user := users.addUser(t, "alice", "correct horse battery")
+	other := users.addUser(t, "bob", "correct horse battery")
+
+	current, currentRefresh, err := tokenStore.CreateTokenPair(user.ID, time.Hour, time.Hour)
+	require.NoError(t, err)
+	elsewhere, elsewhereRefresh, err := tokenStore.CreateTokenPair(user.ID, time.Hour, time.Hour)
+	require.NoError(t, err)
+	personal, err := tokenStore.CreateNewToken(user.ID, time.Hour, tokens.ScopePersonalAccess)
+	require.NoError(t, err)
+	_, _, err = tokenStore.CreateTokenPair(other.ID, time.Hour, time.Hour)
+	require.NoError(t, err)
+
+	rec := serve(handler.HandleEnrollTOTP, http.MethodPost, "/me/totp", nil, user)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	enrollment, err := mfaStore.GetTOTP(user.ID)
+	require.NoError(t, err)
+	code, err := totp.Code(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+
+	confirm := func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), middleware.TokenIDContextKey, current.ID)
+		handler.HandleConfirmTOTP(w, r.WithContext(ctx))
+	}
+	rec = serve(confirm, http.MethodPost, "/me/totp/confirm", map[string]string{"code": code}, user)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	assert.NotNil(t, tokenStore.lookup(tokens.ScopeAuth, current.PlainText), "the current session stays signed in")
+	assert.NotNil(t, tokenStore.lookup(tokens.ScopeRefresh, currentRefresh.PlainText))
+	assert.Nil(t, tokenStore.lookup(tokens.ScopeAuth, elsewhere.PlainText))
+	assert.Nil(t, tokenStore.lookup(tokens.ScopeRefresh, elsewhereRefresh.PlainText))
+	assert.NotNil(t, tokenStore.lookup(tokens.ScopePersonalAccess, personal.PlainText), "personal access tokens are kept")
+	assert.Equal(t, 1, tokenStore.count(other.ID, tokens.ScopeAuth), "other users are left alone")
+}
@@ -0,0 +1,365 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/utils"
+)
+
+// defaultScheduleRange is how far ahead /users/me/schedule looks without a to.
+const defaultScheduleRange = 14 * 24 * time.Hour
+
+type ProgramHandler struct {
+	programStore  store.ProgramStore
+	templateStore store.TemplateStore
+	workoutStore  store.WorkoutStore
+	logger        *slog.Logger
+}
+
+type enrollRequest struct {
+	StartDate string `json:"start_date"`
+}
+
+type startProgramDayRequest struct {
+	PerformedAt *time.Time         `json:"performed_at"`
+	Timezone    *string            `json:"timezone"`
+	Progression *store.Progression `json:"progression"`
+}
+
+func NewProgramHandler(programStore store.ProgramStore, templateStore store.TemplateStore, workoutStore store.WorkoutStore, logger *slog.Logger) *ProgramHandler {
+	return &ProgramHandler{
+		programStore:  programStore,
+		templateStore: templateStore,
+		workoutStore:  workoutStore,
+		logger:        logger,
+	}
+}
+
+// getOwnProgram loads the program named by the {id} URL parameter and makes
+// sure it belongs to the current user, writing the error response otherwise.
+func (ph *ProgramHandler) getOwnProgram(w http.ResponseWriter, r *http.Request) *store.Program {
+	programID, err := utils.ReadIDParam(r)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "ReadIDParam", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Program Id"})
+		return nil
+	}
+
+	program, err := ph.programStore.GetProgramByID(programID)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "GetProgramByID", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to fetch program"})
+		return nil
+	}
+
+	if program == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Program not found"})
+		return nil
+	}
+
+	currentUser := middleware.GetUser(r)
+	if program.UserID != currentUser.ID {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"Error": "Forbidden - You are not the owner of this program"})
+		return nil
+	}
+	return program
+}
+
+// getOwnEnrollment is getOwnProgram for enrollments.
+func (ph *ProgramHandler) getOwnEnrollment(w http.ResponseWriter, r *http.Request) *store.Enrollment {
+	enrollmentID, err := utils.ReadIDParam(r)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "ReadIDParam", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Enrollment Id"})
+		return nil
+	}
+
+	enrollment, err := ph.programStore.GetEnrollment(enrollmentID)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "GetEnrollment", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to fetch enrollment"})
+		return nil
+	}
+
+	currentUser := middleware.GetUser(r)
+	if enrollment == nil || enrollment.UserID != currentUser.ID {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Enrollment not found"})
+		return nil
+	}
+	return enrollment
+}
+
+func (ph *ProgramHandler) HandleCreateProgram(w http.ResponseWriter, r *http.Request) {
+	var program store.Program
+	err := json.NewDecoder(r.Body).Decode(&program)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "decoding create program request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	err = program.Validate()
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	program.UserID = currentUser.ID
+
+	err = ph.programStore.CreateProgram(&program)
+	if errors.Is(err, store.ErrInvalidProgram) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "CreateProgram", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to create program"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"Program": program})
+}
+
+func (ph *ProgramHandler) HandleListPrograms(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+
+	programs, err := ph.programStore.ListPrograms(currentUser.ID)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "ListPrograms", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Programs": programs})
+}
+
+func (ph *ProgramHandler) HandleGetProgramByID(w http.ResponseWriter, r *http.Request) {
+	program := ph.getOwnProgram(w, r)
+	if program == nil {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Program": program})
+}
+
+func (ph *ProgramHandler) HandleDeleteProgramByID(w http.ResponseWriter, r *http.Request) {
+	program := ph.getOwnProgram(w, r)
+	if program == nil {
+		return
+	}
+
+	err := ph.programStore.DeleteProgram(int64(program.ID))
+	if err == sql.ErrNoRows {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Program not found"})
+		return
+	}
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "DeleteProgram", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to delete program"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleEnroll starts the program on the given date, eg. {"start_date": "2025-06-02"}.
+// Without a start date the program starts today.
+func (ph *ProgramHandler) HandleEnroll(w http.ResponseWriter, r *http.Request) {
+	program := ph.getOwnProgram(w, r)
+	if program == nil {
+		return
+	}
+
+	var req enrollRequest
+	err := decodeOptionalJSON(r, &req)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "decoding enroll request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	startDate := time.Now()
+	if req.StartDate != "" {
+		startDate, err = time.Parse(time.DateOnly, req.StartDate)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "start_date must be a date (YYYY-MM-DD)"})
+			return
+		}
+	}
+
+	enrollment := &store.Enrollment{
+		UserID:    program.UserID,
+		ProgramID: program.ID,
+		StartDate: startDate,
+	}
+	err = ph.programStore.Enroll(enrollment)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "Enroll", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to enroll"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"Enrollment": enrollment})
+}
+
+func (ph *ProgramHandler) HandleListMyEnrollments(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+
+	enrollments, err := ph.programStore.ListEnrollments(currentUser.ID)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "ListEnrollments", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Enrollments": enrollments})
+}
+
+func (ph *ProgramHandler) HandleCancelEnrollment(w http.ResponseWriter, r *http.Request) {
+	enrollment := ph.getOwnEnrollment(w, r)
+	if enrollment == nil {
+		return
+	}
+
+	err := ph.programStore.CancelEnrollment(int64(enrollment.ID))
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "CancelEnrollment", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to cancel enrollment"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleGetMySchedule lists the sessions of the current user's active
+// programs, eg. /users/me/schedule?from=2025-06-01&to=2025-06-14
+func (ph *ProgramHandler) HandleGetMySchedule(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+
+	from, err := readQueryTime(r, "from")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	to, err := readQueryTime(r, "to")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	start := time.Now()
+	if from != nil {
+		start = *from
+	}
+	end := start.Add(defaultScheduleRange)
+	if to != nil {
+		end = *to
+	}
+	if end.Before(start) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "to must not be before from"})
+		return
+	}
+
+	sessions, err := ph.programStore.GetSchedule(currentUser.ID, start, end)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "GetSchedule", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Schedule": sessions})
+}
+
+// HandleStartProgramDay creates the workout for a scheduled program day from
+// its template, which marks the day complete.
+func (ph *ProgramHandler) HandleStartProgramDay(w http.ResponseWriter, r *http.Request) {
+	enrollment := ph.getOwnEnrollment(w, r)
+	if enrollment == nil {
+		return
+	}
+
+	if enrollment.Status != store.EnrollmentActive {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": "Enrollment is not active"})
+		return
+	}
+
+	dayID, err := utils.ReadNamedIDParam(r, "day_id")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Program Day Id"})
+		return
+	}
+
+	day, err := ph.programStore.GetProgramDay(enrollment.ProgramID, dayID)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "GetProgramDay", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+	if day == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Program day not found"})
+		return
+	}
+
+	var req startProgramDayRequest
+	err = decodeOptionalJSON(r, &req)
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "decoding start program day request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	template, err := ph.templateStore.GetTemplateByID(int64(day.TemplateID))
+	if err != nil || template == nil {
+		ph.logger.ErrorContext(r.Context(), "GetTemplateByID", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to fetch template"})
+		return
+	}
+
+	var progression store.Progression
+	if req.Progression != nil {
+		progression = *req.Progression
+	}
+
+	workout, err := store.NewWorkoutFromTemplate(template, progression)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	workout.UserID = enrollment.UserID
+	workout.ProgramEnrollmentID = &enrollment.ID
+	workout.ProgramDayID = &day.ID
+	if req.PerformedAt != nil {
+		workout.PerformedAt = *req.PerformedAt
+	}
+	if req.Timezone != nil {
+		workout.Timezone = *req.Timezone
+	}
+
+	err = workout.NormalizeTimes()
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	createdWorkout, err := ph.workoutStore.CreateWorkout(workout)
+	if errors.Is(err, store.ErrProgramDayCompleted) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		ph.logger.ErrorContext(r.Context(), "CreateWorkout for program day", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to create workout"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"Workout": createdWorkout, "personal_records": createdWorkout.NewRecords})
+}
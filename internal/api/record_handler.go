@@ -0,0 +1,64 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/utils"
+)
+
+type RecordHandler struct {
+	recordStore   store.RecordStore
+	exerciseStore store.ExerciseStore
+	logger        *slog.Logger
+}
+
+func NewRecordHandler(recordStore store.RecordStore, exerciseStore store.ExerciseStore, logger *slog.Logger) *RecordHandler {
+	return &RecordHandler{recordStore: recordStore, exerciseStore: exerciseStore, logger: logger}
+}
+
+func (rh *RecordHandler) HandleGetMyRecords(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+
+	records, err := rh.recordStore.GetCurrentRecords(currentUser.ID)
+	if err != nil {
+		rh.logger.ErrorContext(r.Context(), "GetCurrentRecords", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Records": records})
+}
+
+func (rh *RecordHandler) HandleGetExerciseRecords(w http.ResponseWriter, r *http.Request) {
+	exerciseID, err := utils.ReadIDParam(r)
+	if err != nil {
+		rh.logger.ErrorContext(r.Context(), "ReadIDParam", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Exercise Id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	exercise, err := rh.exerciseStore.GetExerciseByID(exerciseID, currentUser.ID)
+	if err != nil {
+		rh.logger.ErrorContext(r.Context(), "GetExerciseByID", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	if exercise == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Exercise not found"})
+		return
+	}
+
+	records, err := rh.recordStore.GetExerciseRecordHistory(currentUser.ID, exerciseID)
+	if err != nil {
+		rh.logger.ErrorContext(r.Context(), "GetExerciseRecordHistory", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Exercise": exercise, "Records": records})
+}
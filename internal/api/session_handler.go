@@ -0,0 +1,376 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/utils"
+)
+
+const (
+	// StaleSessionTimeout is how long a session may go without a logged set
+	// before it is abandoned.
+	StaleSessionTimeout = 6 * time.Hour
+	// staleSessionSweepInterval is how often stale sessions are looked for.
+	staleSessionSweepInterval = 10 * time.Minute
+	defaultSessionTitle       = "Workout"
+)
+
+type SessionHandler struct {
+	sessionStore  store.SessionStore
+	templateStore store.TemplateStore
+	logger        *slog.Logger
+}
+
+type startSessionRequest struct {
+	Title              string `json:"title"`
+	Timezone           string `json:"timezone"`
+	TemplateID         *int   `json:"template_id"`
+	DefaultRestSeconds *int   `json:"default_rest_seconds"`
+}
+
+type restTimerRequest struct {
+	Seconds *int `json:"seconds"`
+}
+
+func NewSessionHandler(sessionStore store.SessionStore, templateStore store.TemplateStore, logger *slog.Logger) *SessionHandler {
+	return &SessionHandler{sessionStore: sessionStore, templateStore: templateStore, logger: logger}
+}
+
+// getOwnSession loads the session named by the {id} URL parameter and makes
+// sure it belongs to the current user, writing the error response otherwise.
+func (sh *SessionHandler) getOwnSession(w http.ResponseWriter, r *http.Request) *store.WorkoutSession {
+	sessionID, err := utils.ReadIDParam(r)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "ReadIDParam", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Session Id"})
+		return nil
+	}
+
+	session, err := sh.sessionStore.GetSession(sessionID)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "GetSession", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to fetch session"})
+		return nil
+	}
+
+	currentUser := middleware.GetUser(r)
+	if session == nil || session.UserID != currentUser.ID {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Session not found"})
+		return nil
+	}
+	return session
+}
+
+// writeSession responds with the current state of the session, re-read so
+// the client sees the rest timer and sets exactly as stored.
+func (sh *SessionHandler) writeSession(w http.ResponseWriter, r *http.Request, status int, sessionID int) {
+	session, err := sh.sessionStore.GetSession(int64(sessionID))
+	if err != nil || session == nil {
+		sh.logger.ErrorContext(r.Context(), "GetSession", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to fetch session"})
+		return
+	}
+	utils.WriteJSON(w, status, utils.Envelope{"Session": session})
+}
+
+func (sh *SessionHandler) HandleStartSession(w http.ResponseWriter, r *http.Request) {
+	var req startSessionRequest
+	err := decodeOptionalJSON(r, &req)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "decoding start session request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	session := &store.WorkoutSession{
+		UserID:             currentUser.ID,
+		Title:              req.Title,
+		Timezone:           req.Timezone,
+		TemplateID:         req.TemplateID,
+		DefaultRestSeconds: req.DefaultRestSeconds,
+	}
+
+	if req.TemplateID != nil {
+		template, err := sh.templateStore.GetTemplateByID(int64(*req.TemplateID))
+		if err != nil {
+			sh.logger.ErrorContext(r.Context(), "GetTemplateByID", "error", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to fetch template"})
+			return
+		}
+		if template == nil || template.UserID != currentUser.ID {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Template not found"})
+			return
+		}
+		if session.Title == "" {
+			session.Title = template.Name
+		}
+	}
+
+	if session.Title == "" {
+		session.Title = defaultSessionTitle
+	}
+	if len(session.Title) > 255 {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "title cannot be greater than 255"})
+		return
+	}
+	if session.Timezone == "" {
+		session.Timezone = "UTC"
+	}
+	if !store.ValidTimezone(session.Timezone) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "invalid timezone"})
+		return
+	}
+	if session.DefaultRestSeconds != nil && *session.DefaultRestSeconds < 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "default_rest_seconds cannot be negative"})
+		return
+	}
+
+	err = sh.sessionStore.StartSession(session)
+	if errors.Is(err, store.ErrSessionInProgress) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "StartSession", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to start session"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"Session": session})
+}
+
+// HandleGetActiveSession returns the session in progress, so a client that
+// crashed or was restarted can pick up where it left off.
+func (sh *SessionHandler) HandleGetActiveSession(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+
+	session, err := sh.sessionStore.GetActiveSession(currentUser.ID)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "GetActiveSession", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to fetch session"})
+		return
+	}
+	if session == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "No session in progress"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Session": session})
+}
+
+func (sh *SessionHandler) HandleGetSessionByID(w http.ResponseWriter, r *http.Request) {
+	session := sh.getOwnSession(w, r)
+	if session == nil {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Session": session})
+}
+
+func (sh *SessionHandler) HandleLogSet(w http.ResponseWriter, r *http.Request) {
+	session := sh.getOwnSession(w, r)
+	if session == nil {
+		return
+	}
+
+	var set store.SessionSet
+	err := json.NewDecoder(r.Body).Decode(&set)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "decoding log set request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	err = set.Validate()
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	err = sh.sessionStore.LogSet(int64(session.ID), &set)
+	if errors.Is(err, store.ErrSessionNotActive) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if errors.Is(err, store.ErrUnknownExercise) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "LogSet", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to log set"})
+		return
+	}
+
+	sh.writeSession(w, r, http.StatusCreated, session.ID)
+}
+
+func (sh *SessionHandler) HandleDeleteSet(w http.ResponseWriter, r *http.Request) {
+	session := sh.getOwnSession(w, r)
+	if session == nil {
+		return
+	}
+
+	setID, err := utils.ReadNamedIDParam(r, "set_id")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Set Id"})
+		return
+	}
+
+	err = sh.sessionStore.DeleteSet(int64(session.ID), setID)
+	if errors.Is(err, store.ErrSessionNotActive) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err == sql.ErrNoRows {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Set not found"})
+		return
+	}
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "DeleteSet", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to delete set"})
+		return
+	}
+
+	sh.writeSession(w, r, http.StatusOK, session.ID)
+}
+
+// HandleStartRest starts a rest timer, eg. {"seconds": 90}. Leaving out
+// seconds starts an open-ended rest.
+func (sh *SessionHandler) HandleStartRest(w http.ResponseWriter, r *http.Request) {
+	session := sh.getOwnSession(w, r)
+	if session == nil {
+		return
+	}
+
+	var req restTimerRequest
+	err := decodeOptionalJSON(r, &req)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "decoding rest timer request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+	if req.Seconds != nil && *req.Seconds < 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "seconds cannot be negative"})
+		return
+	}
+
+	sh.setRestTimer(w, r, session, req.Seconds, true)
+}
+
+func (sh *SessionHandler) HandleStopRest(w http.ResponseWriter, r *http.Request) {
+	session := sh.getOwnSession(w, r)
+	if session == nil {
+		return
+	}
+
+	sh.setRestTimer(w, r, session, nil, false)
+}
+
+func (sh *SessionHandler) setRestTimer(w http.ResponseWriter, r *http.Request, session *store.WorkoutSession, seconds *int, running bool) {
+	err := sh.sessionStore.SetRestTimer(int64(session.ID), seconds, running)
+	if errors.Is(err, store.ErrSessionNotActive) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "SetRestTimer", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to update rest timer"})
+		return
+	}
+
+	sh.writeSession(w, r, http.StatusOK, session.ID)
+}
+
+// HandleFinishSession turns the session into a workout, eg.
+// {"description": "felt strong", "calories_burned": 320}
+func (sh *SessionHandler) HandleFinishSession(w http.ResponseWriter, r *http.Request) {
+	session := sh.getOwnSession(w, r)
+	if session == nil {
+		return
+	}
+
+	var finish store.SessionFinish
+	err := decodeOptionalJSON(r, &finish)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "decoding finish session request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+	if finish.Title != nil && (*finish.Title == "" || len(*finish.Title) > 255) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "title must be between 1 and 255 characters"})
+		return
+	}
+	if finish.CaloriesBurned < 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "calories_burned cannot be negative"})
+		return
+	}
+
+	workout, err := sh.sessionStore.FinishSession(int64(session.ID), finish)
+	if errors.Is(err, store.ErrSessionNotActive) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if errors.Is(err, store.ErrEmptySession) || errors.Is(err, store.ErrInvalidWorkoutSet) || errors.Is(err, store.ErrUnknownExercise) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "FinishSession", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to finish session"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"Workout": workout, "personal_records": workout.NewRecords})
+}
+
+func (sh *SessionHandler) HandleAbandonSession(w http.ResponseWriter, r *http.Request) {
+	session := sh.getOwnSession(w, r)
+	if session == nil {
+		return
+	}
+
+	err := sh.sessionStore.AbandonSession(int64(session.ID))
+	if errors.Is(err, store.ErrSessionNotActive) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "AbandonSession", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to abandon session"})
+		return
+	}
+
+	sh.writeSession(w, r, http.StatusOK, session.ID)
+}
+
+// AbandonStaleSessions periodically abandons sessions that have seen no
+// activity for StaleSessionTimeout, until ctx is done.
+func (sh *SessionHandler) AbandonStaleSessions(ctx context.Context) {
+	ticker := time.NewTicker(staleSessionSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		abandoned, err := sh.sessionStore.AbandonStaleSessions(StaleSessionTimeout)
+		if err != nil {
+			sh.logger.ErrorContext(ctx, "AbandonStaleSessions", "error", err)
+		} else if abandoned > 0 {
+			sh.logger.InfoContext(ctx, "abandoned stale workout sessions", "count", abandoned)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
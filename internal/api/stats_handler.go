@@ -0,0 +1,77 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/analytics"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/utils"
+)
+
+// defaultStatsRange is how far back /users/me/stats looks without a from.
+const defaultStatsRange = 12 * 7 * 24 * time.Hour
+
+type StatsHandler struct {
+	statsStore analytics.Store
+	logger     *slog.Logger
+}
+
+func NewStatsHandler(statsStore analytics.Store, logger *slog.Logger) *StatsHandler {
+	return &StatsHandler{statsStore: statsStore, logger: logger}
+}
+
+// HandleGetMyStats serves aggregates over the current user's workouts, eg.
+// /users/me/stats?from=2025-01-01&to=2025-04-01&bucket=week&exercise_id=3
+func (sh *StatsHandler) HandleGetMyStats(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+
+	query := analytics.StatsQuery{
+		UserID: currentUser.ID,
+		Bucket: r.URL.Query().Get("bucket"),
+	}
+	if query.Bucket == "" {
+		query.Bucket = analytics.BucketWeek
+	}
+
+	to, err := readQueryTime(r, "to")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	from, err := readQueryTime(r, "from")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	query.To = time.Now()
+	if to != nil {
+		query.To = *to
+	}
+	query.From = query.To.Add(-defaultStatsRange)
+	if from != nil {
+		query.From = *from
+	}
+
+	query.ExerciseID, err = readQueryInt(r, "exercise_id")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	err = query.Validate()
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	stats, err := sh.statsStore.GetStats(query)
+	if err != nil {
+		sh.logger.ErrorContext(r.Context(), "GetStats", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Stats": stats})
+}
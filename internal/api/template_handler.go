@@ -0,0 +1,330 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/utils"
+)
+
+type TemplateHandler struct {
+	templateStore store.TemplateStore
+	workoutStore  store.WorkoutStore
+	logger        *slog.Logger
+}
+
+type instantiateTemplateRequest struct {
+	Title       *string            `json:"title"`
+	PerformedAt *time.Time         `json:"performed_at"`
+	Timezone    *string            `json:"timezone"`
+	Progression *store.Progression `json:"progression"`
+}
+
+type saveAsTemplateRequest struct {
+	Name string `json:"name"`
+}
+
+func NewTemplateHandler(templateStore store.TemplateStore, workoutStore store.WorkoutStore, logger *slog.Logger) *TemplateHandler {
+	return &TemplateHandler{templateStore: templateStore, workoutStore: workoutStore, logger: logger}
+}
+
+// decodeOptionalJSON decodes the request body into dst, treating an empty
+// body as an empty object.
+func decodeOptionalJSON(r *http.Request, dst any) error {
+	err := json.NewDecoder(r.Body).Decode(dst)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// getOwnTemplate loads the template named by the {id} URL parameter and makes
+// sure it belongs to the current user, writing the error response otherwise.
+func (th *TemplateHandler) getOwnTemplate(w http.ResponseWriter, r *http.Request) *store.WorkoutTemplate {
+	templateID, err := utils.ReadIDParam(r)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "ReadIDParam", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Template Id"})
+		return nil
+	}
+
+	template, err := th.templateStore.GetTemplateByID(templateID)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "GetTemplateByID", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to fetch template"})
+		return nil
+	}
+
+	if template == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Template not found"})
+		return nil
+	}
+
+	currentUser := middleware.GetUser(r)
+	if template.UserID != currentUser.ID {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"Error": "Forbidden - You are not the owner of this template"})
+		return nil
+	}
+	return template
+}
+
+func (th *TemplateHandler) validateTemplate(template *store.WorkoutTemplate) error {
+	if template.Name == "" {
+		return errors.New("name is required")
+	}
+
+	if len(template.Name) > 255 {
+		return errors.New("name cannot be greater than 255")
+	}
+
+	if template.DurationMinutes < 0 {
+		return errors.New("duration_minutes cannot be negative")
+	}
+	return nil
+}
+
+func (th *TemplateHandler) HandleCreateTemplate(w http.ResponseWriter, r *http.Request) {
+	var template store.WorkoutTemplate
+	err := json.NewDecoder(r.Body).Decode(&template)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "decoding create template request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	err = th.validateTemplate(&template)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	template.UserID = currentUser.ID
+
+	err = th.templateStore.CreateTemplate(&template)
+	if errors.Is(err, store.ErrInvalidWorkoutSet) || errors.Is(err, store.ErrUnknownExercise) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "CreateTemplate", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to create template"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"Template": template})
+}
+
+func (th *TemplateHandler) HandleListTemplates(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+
+	templates, err := th.templateStore.ListTemplates(currentUser.ID)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "ListTemplates", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Templates": templates})
+}
+
+func (th *TemplateHandler) HandleGetTemplateByID(w http.ResponseWriter, r *http.Request) {
+	template := th.getOwnTemplate(w, r)
+	if template == nil {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Template": template})
+}
+
+func (th *TemplateHandler) HandleUpdateTemplateByID(w http.ResponseWriter, r *http.Request) {
+	template := th.getOwnTemplate(w, r)
+	if template == nil {
+		return
+	}
+
+	var updateTemplateRequest struct {
+		Name            *string              `json:"name"`
+		Description     *string              `json:"description"`
+		DurationMinutes *int                 `json:"duration_minutes"`
+		Entries         []store.WorkoutEntry `json:"entries"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&updateTemplateRequest)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "updatingTemplate", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	if updateTemplateRequest.Name != nil {
+		template.Name = *updateTemplateRequest.Name
+	}
+
+	if updateTemplateRequest.Description != nil {
+		template.Description = *updateTemplateRequest.Description
+	}
+
+	if updateTemplateRequest.DurationMinutes != nil {
+		template.DurationMinutes = *updateTemplateRequest.DurationMinutes
+	}
+
+	if updateTemplateRequest.Entries != nil {
+		template.Entries = updateTemplateRequest.Entries
+	}
+
+	err = th.validateTemplate(template)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	err = th.templateStore.UpdateTemplate(template)
+	if errors.Is(err, store.ErrInvalidWorkoutSet) || errors.Is(err, store.ErrUnknownExercise) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "UpdateTemplate", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to update the template"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Template": template})
+}
+
+func (th *TemplateHandler) HandleDeleteTemplateByID(w http.ResponseWriter, r *http.Request) {
+	template := th.getOwnTemplate(w, r)
+	if template == nil {
+		return
+	}
+
+	err := th.templateStore.DeleteTemplate(int64(template.ID))
+	if errors.Is(err, store.ErrTemplateInUse) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err == sql.ErrNoRows {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Template not found"})
+		return
+	}
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "DeleteTemplate", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to delete template"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleInstantiateTemplate starts a new workout from a template, optionally
+// applying progressive overload, eg.
+// {"progression": {"weight_increment": 2.5}}
+func (th *TemplateHandler) HandleInstantiateTemplate(w http.ResponseWriter, r *http.Request) {
+	template := th.getOwnTemplate(w, r)
+	if template == nil {
+		return
+	}
+
+	var req instantiateTemplateRequest
+	err := decodeOptionalJSON(r, &req)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "decoding instantiate template request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	var progression store.Progression
+	if req.Progression != nil {
+		progression = *req.Progression
+	}
+
+	workout, err := store.NewWorkoutFromTemplate(template, progression)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	if req.Title != nil {
+		workout.Title = *req.Title
+	}
+	if req.PerformedAt != nil {
+		workout.PerformedAt = *req.PerformedAt
+	}
+	if req.Timezone != nil {
+		workout.Timezone = *req.Timezone
+	}
+
+	err = workout.NormalizeTimes()
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	createdWorkout, err := th.workoutStore.CreateWorkout(workout)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "CreateWorkout from template", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to create workout"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"Workout": createdWorkout, "personal_records": createdWorkout.NewRecords})
+}
+
+func (th *TemplateHandler) HandleSaveWorkoutAsTemplate(w http.ResponseWriter, r *http.Request) {
+	workoutID, err := utils.ReadIDParam(r)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "ReadIDParam", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Workout Id"})
+		return
+	}
+
+	workout, err := th.workoutStore.GetWorkoutByID(workoutID)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "GetWorkoutByID", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to fetch workout"})
+		return
+	}
+
+	if workout == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Workout not found"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	if workout.UserID != currentUser.ID {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"Error": "Forbidden - You are not the owner of this workout"})
+		return
+	}
+
+	var req saveAsTemplateRequest
+	err = decodeOptionalJSON(r, &req)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "decoding save as template request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	template := store.NewTemplateFromWorkout(workout, req.Name)
+	err = th.validateTemplate(template)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	err = th.templateStore.CreateTemplate(template)
+	if err != nil {
+		th.logger.ErrorContext(r.Context(), "CreateTemplate from workout", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to create template"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"Template": template})
+}
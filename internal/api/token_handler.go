@@ -1,20 +1,59 @@
 package api
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/mailer"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/tokens"
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/utils"
 )
 
+// passwordResetTTL is kept short since a reset token is as good as the
+// password it replaces.
+const passwordResetTTL = 45 * time.Minute
+
+const activationTTL = 3 * 24 * time.Hour
+
+// mfaChallengeTTL is how long a user has to enter their second factor after
+// the password was accepted.
+const mfaChallengeTTL = 5 * time.Minute
+
+// tokenPurgeInterval is how often expired tokens are deleted.
+const tokenPurgeInterval = time.Hour
+
+// loginAttemptRetention is how long sign in attempts are kept for admins to
+// look into, well beyond the throttling window.
+const loginAttemptRetention = 90 * 24 * time.Hour
+
+// TokenTTLs sets how long the tokens handed out on sign-in stay valid. Access
+// tokens are kept short since they are sent with every request; clients
+// renew them with the long-lived refresh token.
+type TokenTTLs struct {
+	Access  time.Duration
+	Refresh time.Duration
+}
+
 type TokenHandler struct {
-	tokenStore store.TokenStore
-	userStore  store.UserStore
-	logger     *log.Logger
+	tokenStore        store.TokenStore
+	userStore         store.UserStore
+	mfaStore          store.MFAStore
+	loginAttemptStore store.LoginAttemptStore
+	mailer            mailer.Mailer
+	ttls              TokenTTLs
+	throttle          LoginThrottle
+	logger            *slog.Logger
 }
 
 type createTokenRequest struct {
@@ -22,11 +61,37 @@ type createTokenRequest struct {
 	Password string `json:"password"`
 }
 
-func NewTokenHandler(tokenStore store.TokenStore, userStore store.UserStore, logger *log.Logger) *TokenHandler {
+// tokenEmailData fills in the templates of emails carrying a token.
+type tokenEmailData struct {
+	Username  string
+	Token     string
+	ExpiresIn string
+}
+
+type createMFATokenRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type createPasswordResetTokenRequest struct {
+	Email string `json:"email"`
+}
+
+func NewTokenHandler(tokenStore store.TokenStore, userStore store.UserStore, mfaStore store.MFAStore, loginAttemptStore store.LoginAttemptStore, mailer mailer.Mailer, ttls TokenTTLs, logger *slog.Logger) *TokenHandler {
 	return &TokenHandler{
-		tokenStore: tokenStore,
-		userStore:  userStore,
-		logger:     logger,
+		tokenStore:        tokenStore,
+		userStore:         userStore,
+		mfaStore:          mfaStore,
+		loginAttemptStore: loginAttemptStore,
+		mailer:            mailer,
+		ttls:              ttls,
+		throttle:          DefaultLoginThrottle,
+		logger:            logger,
 	}
 }
 
@@ -36,39 +101,571 @@ func (h *TokenHandler) HandleCreateToken(w http.ResponseWriter, r *http.Request)
 	err := json.NewDecoder(r.Body).Decode(&req)
 
 	if err != nil {
-		h.logger.Printf("Error: createTokenRequest - %v", err)
+		h.logger.ErrorContext(r.Context(), "createTokenRequest", "error", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
 		return
 	}
 
+	attempt, ok := h.allowLogin(w, r, req.Username, nil)
+	if !ok {
+		return
+	}
+	defer h.finishAbortedLogin(r.Context(), attempt)
+
 	user, err := h.userStore.GetUserByUsername(req.Username)
 
-	if err != nil || user == nil {
-		h.logger.Printf("ERROR: GetUserByUsername - %v", err)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GetUserByUsername", "error", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
 		return
 	}
 
+	if user == nil {
+		// same work and same answer as a wrong password
+		store.CompareDummyPassword(req.Password)
+		if h.recordLogin(w, r, attempt, nil, store.LoginUnknownUser) {
+			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Invalid Credentials"})
+		}
+		return
+	}
+
 	passwordDoMatch, err := user.PasswordHash.Matches(req.Password)
 
 	if err != nil {
-		h.logger.Printf("Error: PasswordHash.Matches - %v", err)
+		h.logger.ErrorContext(r.Context(), "PasswordHash.Matches", "error", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": " internal server error"})
 		return
 	}
 
 	if !passwordDoMatch {
-		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Invalid Credentials"})
+		if h.recordLogin(w, r, attempt, user, store.LoginBadPassword) {
+			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Invalid Credentials"})
+		}
 		return
 	}
 
-	token, err := h.tokenStore.CreateNewToken(user.ID, 24*time.Hour, tokens.ScopeAuth)
+	if user.PasswordHash.NeedsRehash() {
+		h.rehashPassword(r.Context(), user, req.Password)
+	}
 
+	enrollment, err := h.mfaStore.GetTOTP(user.ID)
 	if err != nil {
-		h.logger.Printf("Error: Creating Token %v", err)
+		h.logger.ErrorContext(r.Context(), "GetTOTP", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+	if enrollment.Enabled() {
+		if !h.recordLogin(w, r, attempt, user, store.LoginMFARequired) {
+			return
+		}
+		// the password is right; the tokens come from HandleCreateMFAToken
+		// once the second factor checks out too
+		challenge, err := h.tokenStore.CreateNewToken(user.ID, mfaChallengeTTL, tokens.ScopeMFAChallenge)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "Creating Token", "error", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"mfa_required": true, "mfa_token": challenge})
+		return
+	}
+
+	if !h.recordLogin(w, r, attempt, user, store.LoginSucceeded) {
+		return
+	}
+
+	accessToken, refreshToken, err := h.tokenStore.CreateTokenPair(user.ID, h.ttls.Access, h.ttls.Refresh)
+
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "Creating Token", "error", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": " internal server error"})
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"auth_token": token})
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"auth_token": accessToken, "refresh_token": refreshToken})
+}
+
+// rehashPassword upgrades the stored hash of a password that was just
+// verified to the current algorithm and parameters. Failing to do so is
+// logged but does not fail the sign in; it is tried again next time.
+func (h *TokenHandler) rehashPassword(ctx context.Context, user *store.User, plainTextPassword string) {
+	err := user.PasswordHash.Set(plainTextPassword)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "rehashing password", "user_id", user.ID, "error", err)
+		return
+	}
+	err = h.userStore.UpdatePassword(user)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "UpdatePassword", "user_id", user.ID, "error", err)
+	}
+}
+
+// allowLogin starts a sign in attempt and turns it away with 429 while the
+// username or the client's IP address are throttled after failed attempts.
+// The attempt counts as a failure until recordLogin records how it ended, so
+// parallel attempts cannot slip past the throttle together.
+func (h *TokenHandler) allowLogin(w http.ResponseWriter, r *http.Request, username string, user *store.User) (*store.LoginAttempt, bool) {
+	attempt := &store.LoginAttempt{
+		Username:  username,
+		IP:        middleware.ClientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if user != nil {
+		attempt.UserID = &user.ID
+	}
+
+	now := time.Now()
+	failures, err := h.loginAttemptStore.StartLoginAttempt(attempt, now.Add(-h.throttle.Window))
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "StartLoginAttempt", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return nil, false
+	}
+
+	wait := h.throttle.wait(failures, now)
+	if wait <= 0 {
+		return attempt, true
+	}
+	if !h.recordLogin(w, r, attempt, user, store.LoginThrottled) {
+		return nil, false
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	utils.WriteJSON(w, http.StatusTooManyRequests, utils.Envelope{"Error": fmt.Sprintf("too many failed sign in attempts, try again in %d seconds", seconds)})
+	return nil, false
+}
+
+// recordLogin records how a sign in attempt started by allowLogin ended in
+// the audit trail the throttling is based on. It writes an error response
+// and returns false when that fails.
+func (h *TokenHandler) recordLogin(w http.ResponseWriter, r *http.Request, attempt *store.LoginAttempt, user *store.User, reason string) bool {
+	attempt.Success = reason == store.LoginSucceeded
+	attempt.Reason = reason
+	if user != nil {
+		attempt.UserID = &user.ID
+	}
+
+	err := h.loginAttemptStore.FinishLoginAttempt(attempt)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "FinishLoginAttempt", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return false
+	}
+	return true
+}
+
+// finishAbortedLogin records an attempt that returned without recordLogin,
+// on an error or a stale challenge, as aborted so it does not stay pending
+// and count as a failure for good. Deferred right after allowLogin.
+func (h *TokenHandler) finishAbortedLogin(ctx context.Context, attempt *store.LoginAttempt) {
+	if attempt.Reason != store.LoginPending {
+		return
+	}
+	attempt.Success = false
+	attempt.Reason = store.LoginAborted
+	err := h.loginAttemptStore.FinishLoginAttempt(attempt)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "FinishLoginAttempt", "error", err)
+	}
+}
+
+// HandleCreateMFAToken completes a sign in with two-factor authentication:
+// it takes the challenge token from HandleCreateToken along with a code from
+// the authenticator app or a recovery code.
+func (h *TokenHandler) HandleCreateMFAToken(w http.ResponseWriter, r *http.Request) {
+	var req createMFATokenRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "createMFATokenRequest", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	user, err := h.userStore.GetUserToken(tokens.ScopeMFAChallenge, req.MFAToken)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GetUserToken", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+	if user == nil {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "invalid or expired mfa token, please sign in again"})
+		return
+	}
+	attempt, ok := h.allowLogin(w, r, user.Username, user)
+	if !ok {
+		return
+	}
+	defer h.finishAbortedLogin(r.Context(), attempt)
+
+	enrollment, err := h.mfaStore.GetTOTP(user.ID)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GetTOTP", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+	if !enrollment.Enabled() {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "invalid or expired mfa token, please sign in again"})
+		return
+	}
+
+	ok, err = verifySecondFactor(h.mfaStore, enrollment, req.Code, req.RecoveryCode)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "verifySecondFactor", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+	if !ok {
+		if h.recordLogin(w, r, attempt, user, store.LoginBadMFACode) {
+			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "invalid code"})
+		}
+		return
+	}
+	if !h.recordLogin(w, r, attempt, user, store.LoginSucceeded) {
+		return
+	}
+
+	err = h.tokenStore.DeleteAllTokensForUser(user.ID, tokens.ScopeMFAChallenge)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "DeleteAllTokensForUser", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	accessToken, refreshToken, err := h.tokenStore.CreateTokenPair(user.ID, h.ttls.Access, h.ttls.Refresh)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "Creating Token", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"auth_token": accessToken, "refresh_token": refreshToken})
+}
+
+// HandleRefreshToken trades a refresh token for a new access token and a new
+// refresh token; the one presented cannot be used again.
+func (h *TokenHandler) HandleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req refreshTokenRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "refreshTokenRequest", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+	if req.RefreshToken == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "refresh_token is required"})
+		return
+	}
+
+	accessToken, refreshToken, err := h.tokenStore.RotateRefreshToken(req.RefreshToken, h.ttls.Access, h.ttls.Refresh)
+	if err == store.ErrRefreshTokenReused {
+		h.logger.WarnContext(r.Context(), "refresh token reuse detected, token family revoked")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "refresh token has already been used; please sign in again"})
+		return
+	}
+	if err == store.ErrInvalidRefreshToken {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "RotateRefreshToken", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"auth_token": accessToken, "refresh_token": refreshToken})
+}
+
+// HandleCreatePasswordResetToken emails a password reset token to the user
+// with the given email. The response is the same whether or not the email
+// belongs to an account, so it cannot be used to probe for users.
+func (h *TokenHandler) HandleCreatePasswordResetToken(w http.ResponseWriter, r *http.Request) {
+	var req createPasswordResetTokenRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "createPasswordResetTokenRequest", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	err = validateEmail(req.Email)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	user, err := h.userStore.GetUserByEmail(req.Email)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GetUserByEmail", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	if user != nil {
+		// only the latest reset token is valid
+		err = h.tokenStore.DeleteAllTokensForUser(user.ID, tokens.ScopePasswordReset)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "DeleteAllTokensForUser", "error", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+			return
+		}
+
+		token, err := h.tokenStore.CreateNewToken(user.ID, passwordResetTTL, tokens.ScopePasswordReset)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "Creating Token", "error", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+			return
+		}
+
+		msg, err := mailer.Render(user.Email, "password_reset.tmpl", tokenEmailData{
+			Username:  user.Username,
+			Token:     token.PlainText,
+			ExpiresIn: fmt.Sprintf("%d minutes", int(passwordResetTTL.Minutes())),
+		})
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "rendering password reset email", "error", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+			return
+		}
+
+		err = h.mailer.Send(msg)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "sending password reset email", "error", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+			return
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"message": "if an account with that email exists, a password reset token has been sent to it"})
+}
+
+// sendActivationToken replaces any pending activation token of user with a
+// new one and emails it to the user's current address.
+func sendActivationToken(tokenStore store.TokenStore, m mailer.Mailer, user *store.User) error {
+	err := tokenStore.DeleteAllTokensForUser(user.ID, tokens.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	token, err := tokenStore.CreateNewToken(user.ID, activationTTL, tokens.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	msg, err := mailer.Render(user.Email, "activation.tmpl", tokenEmailData{
+		Username:  user.Username,
+		Token:     token.PlainText,
+		ExpiresIn: fmt.Sprintf("%d days", int(activationTTL.Hours()/24)),
+	})
+	if err != nil {
+		return err
+	}
+	return m.Send(msg)
+}
+
+// HandleCreateActivationToken sends a fresh activation token to the current
+// user, eg. when the first one expired or never arrived.
+func (h *TokenHandler) HandleCreateActivationToken(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	if user.Activated {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": "your account is already activated"})
+		return
+	}
+
+	err := sendActivationToken(h.tokenStore, h.mailer, user)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "sending activation token", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, utils.Envelope{"message": "an activation token has been sent to " + user.Email})
+}
+
+// HandleDeleteCurrentToken signs the caller out by revoking the token the
+// request was made with.
+func (h *TokenHandler) HandleDeleteCurrentToken(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+
+	err := h.tokenStore.DeleteToken(user.ID, tokens.ScopeAuth, int64(middleware.GetTokenID(r)))
+	if err != nil && err != sql.ErrNoRows {
+		h.logger.ErrorContext(r.Context(), "DeleteToken", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListTokens lists the devices the caller is signed in on. A device
+// stays listed, under the same id, for as long as its refresh token can
+// renew its access.
+func (h *TokenHandler) HandleListTokens(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+
+	infos, err := h.tokenStore.ListSessions(user.ID, middleware.GetTokenID(r))
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "ListSessions", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"tokens": infos})
+}
+
+// HandleDeleteToken signs out one of the devices listed by
+// HandleListTokens, eg. a lost phone.
+func (h *TokenHandler) HandleDeleteToken(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Token Id"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	err = h.tokenStore.DeleteSession(user.ID, tokenID)
+	if err == sql.ErrNoRows {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Token not found"})
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "DeleteSession", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const maxPersonalAccessTokenName = 100
+
+type createPersonalAccessTokenRequest struct {
+	Name   string     `json:"name"`
+	Scopes []string   `json:"scopes"`
+	Expiry *time.Time `json:"expiry"`
+}
+
+// HandleCreatePersonalAccessToken issues a token for scripts and
+// integrations limited to the requested scopes. The plaintext is only ever
+// shown in this response.
+func (h *TokenHandler) HandleCreatePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	var req createPersonalAccessTokenRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "createPersonalAccessTokenRequest", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxPersonalAccessTokenName {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": fmt.Sprintf("name is required and must be at most %d characters", maxPersonalAccessTokenName)})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "at least one scope is required, one of " + strings.Join(tokens.AccessScopes, ", ")})
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !tokens.ValidAccessScope(scope) {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": fmt.Sprintf("unknown scope %q, must be one of %s", scope, strings.Join(tokens.AccessScopes, ", "))})
+			return
+		}
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+
+	var ttl time.Duration
+	if req.Expiry != nil {
+		ttl = time.Until(*req.Expiry)
+		if ttl <= 0 {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "expiry must be in the future"})
+			return
+		}
+	}
+
+	user := middleware.GetUser(r)
+	token, err := tokens.GeneratePersonalAccessToken(user.ID, req.Name, req.Scopes, ttl)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GeneratePersonalAccessToken", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	err = h.tokenStore.Insert(token)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "Creating Token", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"token": token})
+}
+
+func (h *TokenHandler) HandleListPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+
+	infos, err := h.tokenStore.ListTokens(user.ID, tokens.ScopePersonalAccess)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "ListTokens", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"tokens": infos})
+}
+
+func (h *TokenHandler) HandleDeletePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := utils.ReadIDParam(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Token Id"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	err = h.tokenStore.DeleteToken(user.ID, tokens.ScopePersonalAccess, tokenID)
+	if err == sql.ErrNoRows {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Token not found"})
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "DeleteToken", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PurgeExpiredTokens periodically deletes expired tokens, rotated refresh
+// tokens included, and the sign in attempts older than
+// loginAttemptRetention, until ctx is done.
+func (h *TokenHandler) PurgeExpiredTokens(ctx context.Context) {
+	ticker := time.NewTicker(tokenPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := h.tokenStore.DeleteExpiredTokens()
+		if err != nil {
+			h.logger.ErrorContext(ctx, "DeleteExpiredTokens", "error", err)
+		} else if purged > 0 {
+			h.logger.InfoContext(ctx, "purged expired tokens", "count", purged)
+		}
+
+		purged, err = h.loginAttemptStore.DeleteLoginAttemptsBefore(time.Now().Add(-loginAttemptRetention))
+		if err != nil {
+			h.logger.ErrorContext(ctx, "DeleteLoginAttemptsBefore", "error", err)
+		} else if purged > 0 {
+			h.logger.InfoContext(ctx, "purged old login attempts", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/tokens"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateActivationToken(t *testing.T) {
+	f := newAccountFixture()
+	user := f.users.addUser(t, "erin", "password")
+
+	rec := serve(f.token.HandleCreateActivationToken, http.MethodPost, "/tokens/activation", nil, user)
+	assert.Equal(t, http.StatusConflict, rec.Code, "already activated")
+	assert.Empty(t, f.mailer.messages())
+
+	user.Activated = false
+	first, err := f.tokens.CreateNewToken(user.ID, time.Hour, tokens.ScopeActivation)
+	require.NoError(t, err)
+
+	rec = serve(f.token.HandleCreateActivationToken, http.MethodPost, "/tokens/activation", nil, user)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	require.Len(t, f.mailer.messages(), 1)
+	assert.Equal(t, user.Email, f.mailer.messages()[0].To)
+	assert.Equal(t, 1, f.tokens.count(user.ID, tokens.ScopeActivation), "the new token replaces the old one")
+	assert.Nil(t, f.tokens.lookup(tokens.ScopeActivation, first.PlainText))
+}
+
+func TestTokenEndpoints(t *testing.T) {
+	f := newAccountFixture()
+	alice := f.users.addUser(t, "alice", "password")
+	bob := f.users.addUser(t, "bob", "password")
+	phone, _, err := f.tokens.CreateTokenPair(alice.ID, time.Hour, time.Hour)
+	require.NoError(t, err)
+	laptop, laptopRefresh, err := f.tokens.CreateTokenPair(alice.ID, time.Hour, time.Hour)
+	require.NoError(t, err)
+	bobs, _, err := f.tokens.CreateTokenPair(bob.ID, time.Hour, time.Hour)
+	require.NoError(t, err)
+
+	um := middleware.UserMiddleware{UserStore: f.users, TokenStore: f.tokens}
+	r := chi.NewRouter()
+	r.Use(um.Authenticate)
+	r.Get("/tokens", um.RequireUser(f.token.HandleListTokens))
+	r.Delete("/tokens/current", um.RequireUser(f.token.HandleDeleteCurrentToken))
+	r.Delete("/tokens/{id}", um.RequireUser(f.token.HandleDeleteToken))
+
+	do := func(method, target string, token *tokens.Token) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer "+token.PlainText)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	list := func() []store.TokenInfo {
+		rec := do(http.MethodGet, "/tokens", phone)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var body struct {
+			Tokens []store.TokenInfo `json:"tokens"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+		return body.Tokens
+	}
+
+	t.Run("list", func(t *testing.T) {
+		infos := list()
+		require.Len(t, infos, 2, "only the caller's sessions")
+		for _, info := range infos {
+			assert.Equal(t, info.ID == int(phone.FamilyID), info.Current)
+		}
+	})
+
+	t.Run("sessions keep their id when refreshed", func(t *testing.T) {
+		laptop, _, err = f.tokens.RotateRefreshToken(laptopRefresh.PlainText, time.Hour, time.Hour)
+		require.NoError(t, err)
+		var ids []int
+		for _, info := range list() {
+			ids = append(ids, info.ID)
+		}
+		assert.ElementsMatch(t, []int{int(phone.FamilyID), int(laptop.FamilyID)}, ids)
+	})
+
+	t.Run("someone else's token", func(t *testing.T) {
+		rec := do(http.MethodDelete, "/tokens/"+strconv.Itoa(int(bobs.FamilyID)), phone)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/tokens", bobs).Code)
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/tokens/laptop", phone).Code)
+	})
+
+	t.Run("sign out another device", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/tokens/"+strconv.Itoa(int(laptop.FamilyID)), phone).Code)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/tokens", laptop).Code)
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/tokens", phone).Code)
+	})
+
+	t.Run("sign out", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/tokens/current", phone).Code)
+		assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/tokens", phone).Code)
+	})
+}
+
+func TestSignInFinishesAttempts(t *testing.T) {
+	f := newAccountFixture()
+	mfaStore := &fakeMFAStore{}
+	attempts := &fakeLoginAttemptStore{}
+	handler := NewTokenHandler(f.tokens, f.users, mfaStore, attempts, f.mailer, TokenTTLs{Access: time.Minute, Refresh: time.Hour}, discardLogger)
+	alice := f.users.addUser(t, "alice", "password")
+	require.NoError(t, mfaStore.SaveTOTPSecret(alice.ID, "secret"))
+	require.NoError(t, mfaStore.EnableTOTP(alice.ID, 1, nil))
+
+	rec := serve(handler.HandleCreateToken, http.MethodPost, "/tokens/authentication", createTokenRequest{Username: "alice", Password: "wrong"}, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = serve(handler.HandleCreateToken, http.MethodPost, "/tokens/authentication", createTokenRequest{Username: "alice", Password: "password"}, nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var challenge struct {
+		MFAToken tokens.Token `json:"mfa_token"`
+	}
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&challenge))
+
+	// two-factor authentication is turned off before the challenge is answered
+	require.NoError(t, mfaStore.DisableTOTP(alice.ID))
+	rec = serve(handler.HandleCreateMFAToken, http.MethodPost, "/tokens/mfa", createMFATokenRequest{MFAToken: challenge.MFAToken.PlainText, Code: "123456"}, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	assert.Equal(t, []string{store.LoginBadPassword, store.LoginMFARequired, store.LoginAborted}, attempts.reasons(), "no attempt is left pending")
+}
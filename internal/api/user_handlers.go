@@ -3,12 +3,18 @@ package api
 import (
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"regexp"
+	"time"
 
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/mailer"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/tokens"
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/utils"
+	"github.com/go-chi/chi/v5"
 )
 
 type registerUserRequest struct {
@@ -18,40 +24,96 @@ type registerUserRequest struct {
 	Bio      string `json:"bio"`
 }
 
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type activateUserRequest struct {
+	Token string `json:"token"`
+}
+
 type UserHandler struct {
-	userStore store.UserStore
-	logger    *log.Logger
+	userStore  store.UserStore
+	tokenStore store.TokenStore
+	mailer     mailer.Mailer
+	logger     *slog.Logger
 }
 
-func NewUserHandler(userStore store.UserStore, logger *log.Logger) *UserHandler {
+func NewUserHandler(userStore store.UserStore, tokenStore store.TokenStore, mailer mailer.Mailer, logger *slog.Logger) *UserHandler {
 	return &UserHandler{
-		userStore: userStore,
-		logger:    logger,
+		userStore:  userStore,
+		tokenStore: tokenStore,
+		mailer:     mailer,
+		logger:     logger,
 	}
 }
 
-func (h *UserHandler) validateRegisterRequest(req *registerUserRequest) error {
-	if req.Username == "" {
+var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+func validateUsername(username string) error {
+	if username == "" {
 		return errors.New("username is required")
 	}
 
-	if len(req.Username) > 50 {
+	if len(username) > 50 {
 		return errors.New("Username cannot be greater than 50")
 	}
+	return nil
+}
 
-	if req.Email == "" {
+func validateEmail(email string) error {
+	if email == "" {
 		return errors.New("Email is required")
 	}
 
-	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	if !emailRegex.MatchString(req.Email) {
+	if !emailRegex.MatchString(email) {
 		return errors.New("invalid email format")
 	}
+	return nil
+}
+
+// validateNewPassword applies to every password a user chooses, on sign up,
+// change or reset. Longer ones than store.MaxPasswordLength could never be
+// used to sign in.
+func validateNewPassword(password string) error {
+	if len(password) < 8 {
+		return errors.New("password must be at least 8 characters")
+	}
+	if len(password) > store.MaxPasswordLength {
+		return fmt.Errorf("password cannot be greater than %d bytes", store.MaxPasswordLength)
+	}
+	return nil
+}
+
+func (h *UserHandler) validateRegisterRequest(req *registerUserRequest) error {
+	if err := validateUsername(req.Username); err != nil {
+		return err
+	}
+
+	if err := validateEmail(req.Email); err != nil {
+		return err
+	}
 
 	if req.Password == "" {
 		return errors.New("password is required")
 	}
-	return nil
+	return validateNewPassword(req.Password)
+}
+
+// writeUserConflict answers a duplicate username or email with 409 and
+// reports whether err was one.
+func writeUserConflict(w http.ResponseWriter, err error) bool {
+	if errors.Is(err, store.ErrDuplicateUsername) || errors.Is(err, store.ErrDuplicateEmail) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"Error": err.Error()})
+		return true
+	}
+	return false
 }
 
 func (h *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request) {
@@ -59,13 +121,15 @@ func (h *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request)
 
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		h.logger.Printf("ERROR: decoding register request %v", err)
+		h.logger.ErrorContext(r.Context(), "decoding register request", "error", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
 	}
 
 	err = h.validateRegisterRequest(&req)
 	if err != nil {
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
 	}
 	user := &store.User{
 		Username: req.Username,
@@ -79,15 +143,251 @@ func (h *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request)
 	// passwords - hash the password
 	err = user.PasswordHash.Set(req.Password)
 	if err != nil {
-		h.logger.Printf("Error: hashing password %v", err)
+		h.logger.ErrorContext(r.Context(), "hashing password", "error", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
 		return
 	}
 	err = h.userStore.CreateUser(user)
+	if writeUserConflict(w, err) {
+		return
+	}
 	if err != nil {
-		h.logger.Printf("Error:Creating User %v", err)
+		h.logger.ErrorContext(r.Context(), "Creating User", "error", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
 		return
 	}
+
+	// the account exists either way; a lost email can be resent through POST /tokens/activation
+	err = sendActivationToken(h.tokenStore, h.mailer, user)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "sending activation token", "error", err)
+	}
 	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"user": user})
 }
+
+// publicProfile is what other users get to see of a user.
+type publicProfile struct {
+	Username  string    `json:"username"`
+	Bio       string    `json:"bio"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (h *UserHandler) HandleGetMe(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": currentUser})
+}
+
+func (h *UserHandler) HandleUpdateMe(w http.ResponseWriter, r *http.Request) {
+	var updateUserRequest struct {
+		Username *string `json:"username"`
+		Email    *string `json:"email"`
+		Bio      *string `json:"bio"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&updateUserRequest)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "decoding update user request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	// work on a copy so a failed update leaves the request's user untouched
+	user := *middleware.GetUser(r)
+
+	if updateUserRequest.Username != nil {
+		if err := validateUsername(*updateUserRequest.Username); err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+			return
+		}
+		user.Username = *updateUserRequest.Username
+	}
+
+	if updateUserRequest.Email != nil {
+		if err := validateEmail(*updateUserRequest.Email); err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+			return
+		}
+		user.Email = *updateUserRequest.Email
+	}
+
+	if updateUserRequest.Bio != nil {
+		user.Bio = *updateUserRequest.Bio
+	}
+
+	emailChanged := user.Email != middleware.GetUser(r).Email
+
+	err = h.userStore.UpdateUser(&user)
+	if writeUserConflict(w, err) {
+		return
+	}
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "UpdateUser", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to update the user"})
+		return
+	}
+
+	if emailChanged {
+		err = sendActivationToken(h.tokenStore, h.mailer, &user)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "sending activation token", "error", err)
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+func (h *UserHandler) HandleGetUserByUsername(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+
+	user, err := h.userStore.GetUserByUsername(username)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GetUserByUsername", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+	if user == nil {
+		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "User not found"})
+		return
+	}
+
+	profile := publicProfile{Username: user.Username, Bio: user.Bio, CreatedAt: user.CreatedAt}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": profile})
+}
+
+// setPassword stores a new password for user and signs them out everywhere
+// by revoking all of their authentication tokens.
+func (h *UserHandler) setPassword(w http.ResponseWriter, r *http.Request, user *store.User, newPassword string) bool {
+	err := user.PasswordHash.Set(newPassword)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "hashing password", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return false
+	}
+
+	err = h.userStore.UpdatePassword(user)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "UpdatePassword", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return false
+	}
+
+	for _, scope := range []string{tokens.ScopeAuth, tokens.ScopeRefresh, tokens.ScopePasswordReset, tokens.ScopeMFAChallenge} {
+		err = h.tokenStore.DeleteAllTokensForUser(user.ID, scope)
+		if err != nil {
+			h.logger.ErrorContext(r.Context(), "DeleteAllTokensForUser", "error", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+			return false
+		}
+	}
+	return true
+}
+
+// HandleResetPassword consumes a token from POST /tokens/password-reset.
+func (h *UserHandler) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "decoding reset password request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	err = validateNewPassword(req.Password)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	user, err := h.userStore.GetUserToken(tokens.ScopePasswordReset, req.Token)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GetUserToken", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+	if user == nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "invalid or expired password reset token"})
+		return
+	}
+
+	if !h.setPassword(w, r, user, req.Password) {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "your password was reset, please sign in again"})
+}
+
+func (h *UserHandler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "decoding change password request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	err = validateNewPassword(req.NewPassword)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	passwordDoMatch, err := user.PasswordHash.Matches(req.CurrentPassword)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "PasswordHash.Matches", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+	if !passwordDoMatch {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "current password is incorrect"})
+		return
+	}
+
+	if !h.setPassword(w, r, user, req.NewPassword) {
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "your password was changed, please sign in again"})
+}
+
+// HandleActivateUser consumes an activation token, confirming the email
+// address it was sent to.
+func (h *UserHandler) HandleActivateUser(w http.ResponseWriter, r *http.Request) {
+	var req activateUserRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "decoding activate user request", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
+		return
+	}
+
+	user, err := h.userStore.GetUserToken(tokens.ScopeActivation, req.Token)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "GetUserToken", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+	if user == nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "invalid or expired activation token"})
+		return
+	}
+
+	err = h.userStore.ActivateUser(user)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "ActivateUser", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	err = h.tokenStore.DeleteAllTokensForUser(user.ID, tokens.ScopeActivation)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "DeleteAllTokensForUser", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type accountFixture struct {
+	tokens *fakeTokenStore
+	users  *fakeUserStore
+	mailer *fakeMailer
+	user   *UserHandler
+	token  *TokenHandler
+}
+
+func newAccountFixture() *accountFixture {
+	f := &accountFixture{tokens: &fakeTokenStore{}, mailer: &fakeMailer{}}
+	f.users = &fakeUserStore{tokens: f.tokens}
+	f.user = NewUserHandler(f.users, f.tokens, f.mailer, discardLogger)
+	f.token = NewTokenHandler(f.tokens, f.users, nil, nil, f.mailer, TokenTTLs{Access: time.Minute, Refresh: time.Hour}, discardLogger)
+	return f
+}
+
+// passwordIs reports whether the stored password of user is password.
+func (f *accountFixture) passwordIs(t *testing.T, user *store.User, password string) bool {
+	stored, err := f.users.GetUserByUsername(user.Username)
+	require.NoError(t, err)
+	ok, err := stored.PasswordHash.Matches(password)
+	require.NoError(t, err)
+	return ok
+}
+
+func TestRegisterUserPassword(t *testing.T) {
+	f := newAccountFixture()
+
+	tests := []struct {
+		password string
+		wantCode int
+	}{
+		{password: "short", wantCode: http.StatusBadRequest},
+		{password: strings.Repeat("a", store.MaxPasswordLength+1), wantCode: http.StatusBadRequest},
+		{password: strings.Repeat("a", store.MaxPasswordLength), wantCode: http.StatusCreated},
+	}
+	for i, tt := range tests {
+		username := "user" + strconv.Itoa(i)
+		body := map[string]string{"username": username, "email": username + "@example.com", "password": tt.password}
+		rec := serve(f.user.HandleRegisterUser, http.MethodPost, "/users", body, nil)
+		assert.Equal(t, tt.wantCode, rec.Code, rec.Body.String())
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	f := newAccountFixture()
+	user := f.users.addUser(t, "alice", "old-password")
+	_, _, err := f.tokens.CreateTokenPair(user.ID, time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	unknown := serve(f.token.HandleCreatePasswordResetToken, http.MethodPost, "/tokens/password-reset", map[string]string{"email": "nobody@example.com"}, nil)
+	assert.Equal(t, http.StatusAccepted, unknown.Code)
+	assert.Empty(t, f.mailer.messages(), "nothing is sent to unknown addresses")
+
+	known := serve(f.token.HandleCreatePasswordResetToken, http.MethodPost, "/tokens/password-reset", map[string]string{"email": user.Email}, nil)
+	assert.Equal(t, http.StatusAccepted, known.Code)
+	assert.Equal(t, unknown.Body.String(), known.Body.String(), "unknown addresses get the same answer")
+	require.Len(t, f.mailer.messages(), 1)
+	assert.Equal(t, user.Email, f.mailer.messages()[0].To)
+
+	// the plain text only exists in the email and in the fake store
+	require.Equal(t, 1, f.tokens.count(user.ID, tokens.ScopePasswordReset))
+	resetToken := f.tokens.tokens[len(f.tokens.tokens)-1]
+	require.Equal(t, tokens.ScopePasswordReset, resetToken.Scope)
+	assert.Contains(t, f.mailer.messages()[0].Text, resetToken.PlainText)
+
+	reset := func(token, password string) int {
+		return serve(f.user.HandleResetPassword, http.MethodPut, "/users/password", map[string]string{"token": token, "password": password}, nil).Code
+	}
+
+	t.Run("short password", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, reset(resetToken.PlainText, "short"))
+		assert.True(t, f.passwordIs(t, user, "old-password"))
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, reset(resetToken.PlainText, "new-password"))
+		assert.True(t, f.passwordIs(t, user, "new-password"))
+		assert.Zero(t, f.tokens.count(user.ID, tokens.ScopeAuth), "signed out everywhere")
+		assert.Zero(t, f.tokens.count(user.ID, tokens.ScopeRefresh))
+		assert.Zero(t, f.tokens.count(user.ID, tokens.ScopePasswordReset))
+	})
+
+	t.Run("token works once", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, reset(resetToken.PlainText, "another-password"))
+		assert.True(t, f.passwordIs(t, user, "new-password"))
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		expired, err := f.tokens.CreateNewToken(user.ID, -time.Second, tokens.ScopePasswordReset)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, reset(expired.PlainText, "another-password"))
+		assert.True(t, f.passwordIs(t, user, "new-password"))
+	})
+
+	t.Run("a new request replaces the token", func(t *testing.T) {
+		first, err := f.tokens.CreateNewToken(user.ID, time.Hour, tokens.ScopePasswordReset)
+		require.NoError(t, err)
+		rec := serve(f.token.HandleCreatePasswordResetToken, http.MethodPost, "/tokens/password-reset", map[string]string{"email": user.Email}, nil)
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		assert.Equal(t, 1, f.tokens.count(user.ID, tokens.ScopePasswordReset))
+		assert.Equal(t, http.StatusBadRequest, reset(first.PlainText, "another-password"))
+	})
+
+	t.Run("invalid email", func(t *testing.T) {
+		rec := serve(f.token.HandleCreatePasswordResetToken, http.MethodPost, "/tokens/password-reset", map[string]string{"email": "not-an-email"}, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	f := newAccountFixture()
+	user := f.users.addUser(t, "bob", "old-password")
+	_, _, err := f.tokens.CreateTokenPair(user.ID, time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	change := func(current, password string) int {
+		// the request's user is a copy, as loaded by the middleware
+		signedIn, err := f.users.GetUserByUsername(user.Username)
+		require.NoError(t, err)
+		body := map[string]string{"current_password": current, "new_password": password}
+		return serve(f.user.HandleChangePassword, http.MethodPut, "/users/me/password", body, signedIn).Code
+	}
+
+	t.Run("wrong current password", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, change("wrong-password", "new-password"))
+		assert.True(t, f.passwordIs(t, user, "old-password"))
+		assert.Equal(t, 1, f.tokens.count(user.ID, tokens.ScopeAuth), "still signed in")
+	})
+
+	t.Run("missing current password", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, change("", "new-password"))
+		assert.True(t, f.passwordIs(t, user, "old-password"))
+	})
+
+	t.Run("short password", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, change("old-password", "short"))
+	})
+
+	t.Run("change", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, change("old-password", "new-password"))
+		assert.True(t, f.passwordIs(t, user, "new-password"))
+		assert.Zero(t, f.tokens.count(user.ID, tokens.ScopeAuth), "signed out everywhere")
+		assert.Zero(t, f.tokens.count(user.ID, tokens.ScopeRefresh))
+	})
+}
+
+func TestActivateUser(t *testing.T) {
+	f := newAccountFixture()
+	user := f.users.addUser(t, "carol", "password")
+	require.NoError(t, f.users.UpdateUser(&store.User{ID: user.ID, Username: user.Username, Email: "carol@example.org"}))
+
+	activate := func(token string) int {
+		return serve(f.user.HandleActivateUser, http.MethodPut, "/users/activated", map[string]string{"token": token}, nil).Code
+	}
+
+	t.Run("wrong scope", func(t *testing.T) {
+		other, err := f.tokens.CreateNewToken(user.ID, time.Hour, tokens.ScopePasswordReset)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, activate(other.PlainText))
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		expired, err := f.tokens.CreateNewToken(user.ID, -time.Second, tokens.ScopeActivation)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, activate(expired.PlainText))
+	})
+
+	token, err := f.tokens.CreateNewToken(user.ID, time.Hour, tokens.ScopeActivation)
+	require.NoError(t, err)
+
+	t.Run("activate", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, activate(token.PlainText))
+		stored, err := f.users.GetUserByUsername(user.Username)
+		require.NoError(t, err)
+		assert.True(t, stored.Activated)
+		assert.NotNil(t, stored.EmailVerifiedAt)
+		assert.Zero(t, f.tokens.count(user.ID, tokens.ScopeActivation), "every activation token is used up")
+	})
+
+	t.Run("token works once", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, activate(token.PlainText))
+	})
+}
+
+func TestUpdateMeEmailChange(t *testing.T) {
+	f := newAccountFixture()
+	user := f.users.addUser(t, "dave", "password")
+
+	update := func(body map[string]string) int {
+		signedIn, err := f.users.GetUserByUsername(user.Username)
+		require.NoError(t, err)
+		return serve(f.user.HandleUpdateMe, http.MethodPatch, "/users/me", body, signedIn).Code
+	}
+
+	assert.Equal(t, http.StatusOK, update(map[string]string{"bio": "lifting"}))
+	stored, err := f.users.GetUserByUsername(user.Username)
+	require.NoError(t, err)
+	assert.True(t, stored.Activated)
+	assert.Empty(t, f.mailer.messages())
+
+	assert.Equal(t, http.StatusOK, update(map[string]string{"email": "dave@example.org"}))
+	stored, err = f.users.GetUserByUsername(user.Username)
+	require.NoError(t, err)
+	assert.False(t, stored.Activated, "a new address must be verified again")
+	require.Len(t, f.mailer.messages(), 1)
+	assert.Equal(t, "dave@example.org", f.mailer.messages()[0].To)
+	assert.Equal(t, 1, f.tokens.count(user.ID, tokens.ScopeActivation))
+}
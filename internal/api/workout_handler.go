@@ -4,8 +4,11 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
@@ -14,36 +17,137 @@ import (
 
 type WorkoutHandler struct {
 	workoutStore store.WorkoutStore
-	logger       *log.Logger
+	logger       *slog.Logger
 }
 
-func NewWorkoutHandler(workoutStore store.WorkoutStore, logger *log.Logger) *WorkoutHandler {
+func NewWorkoutHandler(workoutStore store.WorkoutStore, logger *slog.Logger) *WorkoutHandler {
 	return &WorkoutHandler{workoutStore: workoutStore, logger: logger}
 }
 
 func (wh *WorkoutHandler) HandleGetWorkoutByID(w http.ResponseWriter, r *http.Request) {
 	workoutID, err := utils.ReadIDParam(r)
 	if err != nil {
-		wh.logger.Printf("Error: ReadIDParam: %v", err)
+		wh.logger.ErrorContext(r.Context(), "ReadIDParam", "error", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Workout Id"})
 		return
 	}
 
 	workout, err := wh.workoutStore.GetWorkoutByID(workoutID)
 	if err != nil {
-		wh.logger.Printf("Error: GetWorkoutByID: %v", err)
+		wh.logger.ErrorContext(r.Context(), "GetWorkoutByID", "error", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
 	}
 
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Workout": workout})
 }
 
+func (wh *WorkoutHandler) HandleListWorkouts(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+	if currentUser == nil || currentUser == store.AnonymousUser {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Unauthorized"})
+		return
+	}
+
+	filter, err := readWorkoutFilter(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+	filter.UserID = currentUser.ID
+
+	page, err := wh.workoutStore.ListWorkouts(filter)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid cursor"})
+		return
+	}
+	if err != nil {
+		wh.logger.ErrorContext(r.Context(), "ListWorkouts", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Internal Server Error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"Workouts": page.Workouts, "next_cursor": page.NextCursor})
+}
+
+// readWorkoutFilter builds a store.WorkoutFilter out of the query string, eg.
+// /workouts?from=2025-01-01&title=leg&min_duration=30&sort=-duration_minutes&limit=10
+func readWorkoutFilter(r *http.Request) (store.WorkoutFilter, error) {
+	query := r.URL.Query()
+	filter := store.WorkoutFilter{
+		Title:  query.Get("title"),
+		Sort:   query.Get("sort"),
+		Cursor: query.Get("cursor"),
+	}
+
+	if _, _, err := store.ParseWorkoutSort(filter.Sort); err != nil {
+		return filter, err
+	}
+
+	var err error
+	if filter.From, err = readQueryTime(r, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = readQueryTime(r, "to"); err != nil {
+		return filter, err
+	}
+	if filter.MinDuration, err = readQueryInt(r, "min_duration"); err != nil {
+		return filter, err
+	}
+	if filter.MaxDuration, err = readQueryInt(r, "max_duration"); err != nil {
+		return filter, err
+	}
+	if filter.MinCalories, err = readQueryInt(r, "min_calories"); err != nil {
+		return filter, err
+	}
+	if filter.MaxCalories, err = readQueryInt(r, "max_calories"); err != nil {
+		return filter, err
+	}
+
+	limit, err := readQueryInt(r, "limit")
+	if err != nil {
+		return filter, err
+	}
+	if limit != nil {
+		if *limit < 1 || *limit > store.MaxWorkoutPageSize {
+			return filter, fmt.Errorf("limit must be between 1 and %d", store.MaxWorkoutPageSize)
+		}
+		filter.Limit = *limit
+	}
+	return filter, nil
+}
+
+func readQueryInt(r *http.Request, key string) (*int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return nil, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an integer", key)
+	}
+	return &i, nil
+}
+
+// readQueryTime accepts either a full RFC 3339 timestamp or a plain date.
+func readQueryTime(r *http.Request, key string) (*time.Time, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("%s must be a date (YYYY-MM-DD) or RFC 3339 timestamp", key)
+}
+
 func (wh *WorkoutHandler) HandleCreateWorkout(w http.ResponseWriter, r *http.Request) {
 	var workout store.Workout
 	err := json.NewDecoder(r.Body).Decode(&workout)
 
 	if err != nil {
-		wh.logger.Printf("Error: DecodingCreateWorkout: %v", err)
+		wh.logger.ErrorContext(r.Context(), "DecodingCreateWorkout", "error", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Sent"})
 	}
 
@@ -53,26 +157,47 @@ func (wh *WorkoutHandler) HandleCreateWorkout(w http.ResponseWriter, r *http.Req
 		return
 	}
 	workout.UserID = currentUser.ID
+	// templates and program days are linked only through their own endpoints
+	workout.TemplateID = nil
+	workout.ProgramEnrollmentID = nil
+	workout.ProgramDayID = nil
+
+	err = workout.NormalizeTimes()
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
+	err = workout.NormalizeEntries()
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
+
 	createdWorkout, err := wh.workoutStore.CreateWorkout(&workout)
+	if errors.Is(err, store.ErrUnknownExercise) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
+		return
+	}
 	if err != nil {
-		wh.logger.Printf("Error: CreateWorkout: %v", err)
+		wh.logger.ErrorContext(r.Context(), "CreateWorkout", "error", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to create workout"})
 		return
 	}
-	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"Workout": createdWorkout})
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"Workout": createdWorkout, "personal_records": createdWorkout.NewRecords})
 }
 
 func (wh *WorkoutHandler) HandleUpdateWorkoutByID(w http.ResponseWriter, r *http.Request) {
 	workoutID, err := utils.ReadIDParam(r)
 	if err != nil {
-		wh.logger.Printf("Error: ReadIDParam: %v", err)
+		wh.logger.ErrorContext(r.Context(), "ReadIDParam", "error", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Workout Update Id"})
 		return
 	}
 
 	existingWorkout, err := wh.workoutStore.GetWorkoutByID(workoutID)
 	if err != nil {
-		wh.logger.Printf("Error: getWorkoutById: %v", err)
+		wh.logger.ErrorContext(r.Context(), "getWorkoutById", "error", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to fetch workout"})
 		return
 	}
@@ -82,17 +207,42 @@ func (wh *WorkoutHandler) HandleUpdateWorkoutByID(w http.ResponseWriter, r *http
 		return
 	}
 
+	currentUser := middleware.GetUser(r)
+	if currentUser == nil || currentUser == store.AnonymousUser {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Unauthorized"})
+		return
+	}
+
+	workoutOwner, err := wh.workoutStore.GetWorkoutOwner(workoutID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Workout not found"})
+			return
+		}
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to get workout owner"})
+		return
+	}
+
+	if workoutOwner != currentUser.ID {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"Error": "Forbidden - You are not the owner of this workout"})
+		return
+	}
+
 	var updateWorkoutRequest struct {
 		Title           *string              `json:"title"`
 		Description     *string              `json:"description"`
 		DurationMinutes *int                 `json:"duration_minutes"`
 		CaloriesBurned  *int                 `json:"calories_burned"`
+		PerformedAt     *time.Time           `json:"performed_at"`
+		StartedAt       *time.Time           `json:"started_at"`
+		EndedAt         *time.Time           `json:"ended_at"`
+		Timezone        *string              `json:"timezone"`
 		Entries         []store.WorkoutEntry `json:"entries"`
 	}
 
 	err = json.NewDecoder(r.Body).Decode(&updateWorkoutRequest)
 	if err != nil {
-		wh.logger.Printf("Error: updatingWorkout: %v", err)
+		wh.logger.ErrorContext(r.Context(), "updatingWorkout", "error", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Request Payload"})
 		return
 	}
@@ -113,53 +263,64 @@ func (wh *WorkoutHandler) HandleUpdateWorkoutByID(w http.ResponseWriter, r *http
 		existingWorkout.CaloriesBurned = *updateWorkoutRequest.CaloriesBurned
 	}
 
+	if updateWorkoutRequest.PerformedAt != nil {
+		existingWorkout.PerformedAt = *updateWorkoutRequest.PerformedAt
+	}
+
+	if updateWorkoutRequest.StartedAt != nil {
+		existingWorkout.StartedAt = updateWorkoutRequest.StartedAt
+	}
+
+	if updateWorkoutRequest.EndedAt != nil {
+		existingWorkout.EndedAt = updateWorkoutRequest.EndedAt
+	}
+
+	if updateWorkoutRequest.Timezone != nil {
+		existingWorkout.Timezone = *updateWorkoutRequest.Timezone
+	}
+
 	if updateWorkoutRequest.Entries != nil {
 		existingWorkout.Entries = updateWorkoutRequest.Entries
 	}
 
-	currentUser := middleware.GetUser(r)
-	if currentUser == nil || currentUser == store.AnonymousUser {
-		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Unauthorized"})
+	err = existingWorkout.NormalizeTimes()
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
 		return
 	}
 
-	workoutOwner, err := wh.workoutStore.GetWorkoutOwner(workoutID)
+	err = existingWorkout.NormalizeEntries()
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Workout not found"})
-			return
-		}
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to get workout owner"})
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
 		return
 	}
 
-	if workoutOwner != currentUser.ID {
-		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"Error": "Forbidden - You are not the owner of this workout"})
+	err = wh.workoutStore.UpdateWorkout(existingWorkout)
+	if errors.Is(err, store.ErrUnknownExercise) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": err.Error()})
 		return
 	}
-
-	err = wh.workoutStore.UpdateWorkout(existingWorkout)
 	if err != nil {
-		wh.logger.Printf("Error: UpdateWorkout %v", err)
+		wh.logger.ErrorContext(r.Context(), "UpdateWorkout", "error", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to update the workout"})
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"updatedWorkut": existingWorkout})
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"updatedWorkut": existingWorkout, "personal_records": existingWorkout.NewRecords})
 }
 
 func (wh *WorkoutHandler) HandleDeleteWorkoutByID(w http.ResponseWriter, r *http.Request) {
 	// getting the id from the url parameter and parse the ID
 	workoutID, err := utils.ReadIDParam(r)
 	if err != nil {
-		wh.logger.Printf("Error: ReadIDParam: %v", err)
+		wh.logger.ErrorContext(r.Context(), "ReadIDParam", "error", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"Error": "Invalid Workout Id"})
 		return
 	}
 
 	existingWorkout, err := wh.workoutStore.GetWorkoutByID(workoutID)
 	if err != nil {
-		wh.logger.Printf("Error: GetWorkoutByID: %v", err)
+		wh.logger.ErrorContext(r.Context(), "GetWorkoutByID", "error", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to Fetch Workout"})
 		return
 	}
@@ -191,13 +352,13 @@ func (wh *WorkoutHandler) HandleDeleteWorkoutByID(w http.ResponseWriter, r *http
 
 	err = wh.workoutStore.DeleteWorkout(workoutID)
 	if err == sql.ErrNoRows {
-		wh.logger.Printf("Error: deleteWorkout %v", err)
+		wh.logger.ErrorContext(r.Context(), "deleteWorkout", "error", err)
 		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"Error": "Workout Not Found"})
 		return
 	}
 
 	if err != nil {
-		wh.logger.Printf("Error: deleteWorkout: %v", err)
+		wh.logger.ErrorContext(r.Context(), "deleteWorkout", "error", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "Failed to delete workout"})
 		return
 	}
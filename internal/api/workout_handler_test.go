@@ -0,0 +1,89 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeWorkoutStore holds one workout per id.
+type fakeWorkoutStore struct {
+	workouts map[int64]*store.Workout
+	updated  []*store.Workout
+}
+
+func (s *fakeWorkoutStore) CreateWorkout(workout *store.Workout) (*store.Workout, error) {
+	workout.ID = len(s.workouts) + 1
+	s.workouts[int64(workout.ID)] = workout
+	return workout, nil
+}
+
+func (s *fakeWorkoutStore) GetWorkoutByID(id int64) (*store.Workout, error) {
+	workout, ok := s.workouts[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *workout
+	return &copied, nil
+}
+
+func (s *fakeWorkoutStore) UpdateWorkout(workout *store.Workout) error {
+	s.updated = append(s.updated, workout)
+	return nil
+}
+
+func (s *fakeWorkoutStore) DeleteWorkout(id int64) error {
+	delete(s.workouts, id)
+	return nil
+}
+
+func (s *fakeWorkoutStore) GetWorkoutOwner(id int64) (int, error) {
+	workout, ok := s.workouts[id]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return workout.UserID, nil
+}
+
+func (s *fakeWorkoutStore) ListWorkouts(filter store.WorkoutFilter) (*store.WorkoutPage, error) {
+	return &store.WorkoutPage{}, nil
+}
+
+func TestUpdateWorkoutOwnerCheck(t *testing.T) {
+	workoutStore := &fakeWorkoutStore{workouts: map[int64]*store.Workout{
+		1: {ID: 1, UserID: 1, Title: "legs", PerformedAt: time.Now(), Timezone: "UTC"},
+	}}
+	h := NewWorkoutHandler(workoutStore, discardLogger)
+	r := chi.NewRouter()
+	r.Put("/workouts/{id}", h.HandleUpdateWorkoutByID)
+
+	update := func(user *store.User, body string) int {
+		req := httptest.NewRequest(http.MethodPut, "/workouts/1", strings.NewReader(body))
+		req = middleware.SetUser(req, user)
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	owner := &store.User{ID: 1, Activated: true}
+	stranger := &store.User{ID: 2, Activated: true}
+
+	assert.Equal(t, http.StatusForbidden, update(stranger, `not json`), "non-owners learn nothing from validation")
+	assert.Equal(t, http.StatusForbidden, update(stranger, `{"duration_minutes": -5}`))
+	assert.Equal(t, http.StatusForbidden, update(stranger, `{"title": "mine now"}`))
+	assert.Empty(t, workoutStore.updated)
+
+	assert.Equal(t, http.StatusBadRequest, update(owner, `not json`))
+	assert.Equal(t, http.StatusOK, update(owner, `{"title": "heavy legs"}`))
+	if assert.Len(t, workoutStore.updated, 1) {
+		assert.Equal(t, "heavy legs", workoutStore.updated[0].Title)
+	}
+}
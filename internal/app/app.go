@@ -1,69 +1,288 @@
 package app
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
+	"net/netip"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/analytics"
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/api"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/config"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/events"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/logging"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/mailer"
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/migrations"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/seeds"
 )
 
+// migrateTimeout bounds how long the server waits at startup for the
+// migration lock and the migrations themselves.
+const migrateTimeout = 10 * time.Minute
+
+// mailDrainTimeout bounds how long Close waits for queued mail to go out.
+const mailDrainTimeout = 30 * time.Second
+
 type Application struct {
-	Logger         *log.Logger
-	WorkoutHandler *api.WorkoutHandler
-	UserHandler    *api.UserHandler
-	TokenHander    *api.TokenHandler
-	Middleware     middleware.UserMiddleware
-	DB             *sql.DB
+	Logger          *slog.Logger
+	WorkoutHandler  *api.WorkoutHandler
+	UserHandler     *api.UserHandler
+	TokenHander     *api.TokenHandler
+	ExerciseHandler *api.ExerciseHandler
+	RecordHandler   *api.RecordHandler
+	StatsHandler    *api.StatsHandler
+	TemplateHandler *api.TemplateHandler
+	ProgramHandler  *api.ProgramHandler
+	SessionHandler  *api.SessionHandler
+	EventHandler    *api.EventHandler
+	MFAHandler      *api.MFAHandler
+	AdminHandler    *api.AdminHandler
+	Mailer          *mailer.AsyncMailer
+	Middleware      middleware.UserMiddleware
+	DB              *sql.DB
+	// TrustedProxies are the proxies whose X-Forwarded-For is believed.
+	TrustedProxies []netip.Prefix
+
+	eventBroker *events.Broker
+	// ready is false while the application drains before shutting down.
+	ready       atomic.Bool
+	stopWorkers context.CancelFunc
+	workers     sync.WaitGroup
+	closeOnce   sync.Once
+	closeErr    error
 }
 
-func NewApplication() (*Application, error) {
-	pgDB, err := store.Open()
+func NewApplication(cfg *config.Config) (*Application, error) {
+	err := store.SetPasswordHashing(store.PasswordHashing{
+		Algorithm: cfg.Password.Algorithm,
+		Argon2: store.Argon2Params{
+			Memory:      cfg.Password.Argon2MemoryKiB,
+			Time:        cfg.Password.Argon2Time,
+			Parallelism: cfg.Password.Argon2Parallelism,
+		},
+		BcryptCost:    cfg.Password.BcryptCost,
+		MaxConcurrent: cfg.Password.MaxConcurrentHashes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("password hashing: %w", err)
+	}
+
+	// Handlers log with the request's context so every line carries the
+	// request ID and user; see middleware.RequestLogger.
+	logger, err := logging.New(os.Stdout, cfg.Log.Level, cfg.Log.Format)
 	if err != nil {
 		return nil, err
 	}
 
-	// Apply database migrations using the embedded filesystem.
-	// This ensures the database schema is up-to-date.
-	err = store.MigrateFS(pgDB, migrations.FS, ".")
+	// The development key is public: anyone could decrypt the two-factor
+	// secrets with it.
+	if cfg.MFA.TOTPKey == config.DevelopmentTOTPKey {
+		if !cfg.Development {
+			return nil, errors.New("two-factor secrets would be encrypted with the public development key: set TOTP_ENCRYPTION_KEY, or DEVELOPMENT=true on a development machine")
+		}
+		logger.Warn("two-factor secrets are encrypted with the development key; set TOTP_ENCRYPTION_KEY in production")
+	}
+
+	trustedProxies, err := cfg.Server.TrustedProxyPrefixes()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	// Create a new logger instance that writes to standard output
-	// and includes the date and time in log messages.
-	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
+	pgDB, err := store.Open(cfg.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply database migrations using the embedded filesystem, unless they
+	// are run separately with `migrate up`. Replicas starting together wait
+	// on the migration lock instead of racing.
+	if cfg.DB.AutoMigrate {
+		err = migrate(pgDB)
+		if err != nil {
+			pgDB.Close()
+			return nil, err
+		}
+	}
+
+	// Load the shared exercise catalog so entries can be linked to it.
+	err = store.SeedExercises(pgDB, seeds.FS)
+	if err != nil {
+		pgDB.Close()
+		return nil, err
+	}
+
+	// Secrets stored before they were encrypted are encrypted once the key
+	// is known, and from then on only read through the store.
+	totpKey, err := cfg.MFA.TOTPKeyBytes()
+	if err != nil {
+		pgDB.Close()
+		return nil, err
+	}
+	mfaStore, err := store.NewPostgresMFAStore(pgDB, totpKey)
+	if err != nil {
+		pgDB.Close()
+		return nil, err
+	}
+	encrypted, err := mfaStore.EncryptPlaintextSecrets()
+	if err != nil {
+		pgDB.Close()
+		return nil, err
+	}
+	if encrypted > 0 {
+		logger.Info("encrypted stored two-factor secrets", "count", encrypted)
+	}
 
 	// our stores will go here
 	workoutStore := store.NewPostgresWorkoutStore(pgDB)
 	userStore := store.NewPostgresUserStore(pgDB)
 	tokenStore := store.NewPostgresTokenStore(pgDB)
+	exerciseStore := store.NewPostgresExerciseStore(pgDB)
+	recordStore := store.NewPostgresRecordStore(pgDB)
+	statsStore := analytics.NewPostgresStore(pgDB)
+	templateStore := store.NewPostgresTemplateStore(pgDB)
+	programStore := store.NewPostgresProgramStore(pgDB)
+	sessionStore := store.NewPostgresSessionStore(pgDB)
+	loginAttemptStore := store.NewPostgresLoginAttemptStore(pgDB)
+	eventBroker := events.NewBroker(pgDB, logger)
+	mailBackend, err := newMailer(cfg.Mail, logger)
+	if err != nil {
+		pgDB.Close()
+		return nil, err
+	}
+	// Handlers only queue mail; delivery and retries happen in the background.
+	accountMailer := mailer.NewAsyncMailer(mailBackend, logger)
 
 	// Initialize the API handlers. These components handle incoming HTTP requests
 	// and use the stores to interact with data.
 	workoutHandler := api.NewWorkoutHandler(workoutStore, logger)
-	userHandler := api.NewUserHandler(userStore, logger)
-	tokenHandler := api.NewTokenHandler(tokenStore, userStore, logger)
-	middlewareHandler := middleware.UserMiddleware{UserStore: userStore}
+	userHandler := api.NewUserHandler(userStore, tokenStore, accountMailer, logger)
+	tokenTTLs := api.TokenTTLs{Access: cfg.Tokens.AccessTTL, Refresh: cfg.Tokens.RefreshTTL}
+	tokenHandler := api.NewTokenHandler(tokenStore, userStore, mfaStore, loginAttemptStore, accountMailer, tokenTTLs, logger)
+	exerciseHandler := api.NewExerciseHandler(exerciseStore, logger)
+	recordHandler := api.NewRecordHandler(recordStore, exerciseStore, logger)
+	statsHandler := api.NewStatsHandler(statsStore, logger)
+	templateHandler := api.NewTemplateHandler(templateStore, workoutStore, logger)
+	programHandler := api.NewProgramHandler(programStore, templateStore, workoutStore, logger)
+	sessionHandler := api.NewSessionHandler(sessionStore, templateStore, logger)
+	eventHandler := api.NewEventHandler(eventBroker, workoutStore, sessionStore, logger)
+	mfaHandler := api.NewMFAHandler(mfaStore, tokenStore, logger)
+	adminHandler := api.NewAdminHandler(loginAttemptStore, logger)
+	middlewareHandler := middleware.UserMiddleware{UserStore: userStore, TokenStore: tokenStore}
 
 	// Create and return the Application instance with all dependencies wired up.
 	app := &Application{
-		Logger:         logger,
-		WorkoutHandler: workoutHandler,
-		UserHandler:    userHandler,
-		TokenHander:    tokenHandler,
-		Middleware:     middlewareHandler,
-		DB:             pgDB,
+		Logger:          logger,
+		WorkoutHandler:  workoutHandler,
+		UserHandler:     userHandler,
+		TokenHander:     tokenHandler,
+		ExerciseHandler: exerciseHandler,
+		RecordHandler:   recordHandler,
+		StatsHandler:    statsHandler,
+		TemplateHandler: templateHandler,
+		ProgramHandler:  programHandler,
+		SessionHandler:  sessionHandler,
+		EventHandler:    eventHandler,
+		MFAHandler:      mfaHandler,
+		AdminHandler:    adminHandler,
+		Mailer:          accountMailer,
+		Middleware:      middlewareHandler,
+		DB:              pgDB,
+		TrustedProxies:  trustedProxies,
+		eventBroker:     eventBroker,
 	}
 
+	var workersCtx context.Context
+	workersCtx, app.stopWorkers = context.WithCancel(context.Background())
+	// Sessions left open by clients that never came back are abandoned in the background.
+	app.goWorker(func() { sessionHandler.AbandonStaleSessions(workersCtx) })
+	// Expired and rotated tokens are only kept to recognise their reuse.
+	app.goWorker(func() { tokenHandler.PurgeExpiredTokens(workersCtx) })
+	// Relay workout and session changes from postgres to streaming clients.
+	app.goWorker(func() { eventBroker.Run(workersCtx) })
+
+	app.ready.Store(true)
 	return app, nil
 }
 
-func (a *Application) HealthCheck(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprint(w, "Status is Available\n")
+func migrate(db *sql.DB) error {
+	migrator, err := store.NewMigrator(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+	_, err = migrator.Up(ctx)
+	return err
+}
+
+// goWorker runs a background worker that Close waits for.
+func (a *Application) goWorker(work func()) {
+	a.workers.Add(1)
+	go func() {
+		defer a.workers.Done()
+		work()
+	}()
+}
+
+// Ready reports whether the application takes new traffic; it turns false
+// once Drain is called.
+func (a *Application) Ready() bool {
+	return a.ready.Load()
+}
+
+// Drain prepares for shutdown: the application reports itself not ready so
+// load balancers stop sending traffic, and event streams are ended so their
+// clients reconnect to another instance instead of holding up
+// http.Server.Shutdown. Regular requests keep being served.
+func (a *Application) Drain() {
+	a.ready.Store(false)
+	a.eventBroker.Close()
+}
+
+// Close stops the background workers, delivers the mail still queued, for up
+// to mailDrainTimeout, and closes the database pool. Call it after the HTTP server has shut down,
+// since requests in flight still need the database.
+func (a *Application) Close() error {
+	a.closeOnce.Do(func() {
+		a.Drain()
+		a.stopWorkers()
+		a.workers.Wait()
+
+		ctx, cancel := context.WithTimeout(context.Background(), mailDrainTimeout)
+		defer cancel()
+		err := a.Mailer.Close(ctx)
+		if err != nil {
+			a.Logger.Error("delivering queued mail", "error", err)
+		}
+		a.closeErr = a.DB.Close()
+	})
+	return a.closeErr
+}
+
+// newMailer picks the mail backend: SMTP when a host is configured, an
+// outbox directory of .eml files when one is configured, the log otherwise.
+func newMailer(cfg config.MailConfig, logger *slog.Logger) (mailer.Mailer, error) {
+	if cfg.SMTPHost != "" {
+		return mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			Sender:   cfg.Sender,
+		})
+	}
+
+	if cfg.OutboxDir != "" {
+		return mailer.NewOutboxMailer(cfg.OutboxDir, cfg.Sender)
+	}
+	logger.Warn("no mail backend configured, emails are only logged; set LOG_LEVEL=debug to see their bodies")
+	return mailer.NewLogMailer(logger), nil
 }
@@ -0,0 +1,16 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewApplicationRefusesDevelopmentKey(t *testing.T) {
+	cfg := config.Default()
+	cfg.DB.DSN = "host=unreachable.invalid"
+
+	_, err := NewApplication(cfg)
+	assert.ErrorContains(t, err, "TOTP_ENCRYPTION_KEY", "outside development mode")
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/utils"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/migrations"
+)
+
+// readinessTimeout bounds all dependency checks of one readiness probe.
+const readinessTimeout = 2 * time.Second
+
+type dependencyStatus struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	// Version and Expected are the applied and embedded migration versions.
+	Version  *int64 `json:"version,omitempty"`
+	Expected *int64 `json:"expected,omitempty"`
+}
+
+// HandleHealthz is the liveness probe: answering at all means the process
+// is alive, whatever state its dependencies are in. It is also served at
+// /health, where it used to be.
+func (a *Application) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"status": "ok"})
+}
+
+// HandleReadyz is the readiness probe. It fails while the application
+// drains before shutting down, when postgres does not answer and when the
+// database schema is not at the version of the embedded migrations.
+func (a *Application) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]*dependencyStatus{
+		"database":   a.checkDatabase(ctx),
+		"migrations": a.checkMigrations(ctx),
+	}
+
+	status, code := "ready", http.StatusOK
+	if !a.Ready() {
+		status, code = "draining", http.StatusServiceUnavailable
+	}
+	for _, check := range checks {
+		if check.Status != "ok" {
+			status, code = "not ready", http.StatusServiceUnavailable
+		}
+	}
+
+	utils.WriteJSON(w, code, utils.Envelope{"status": status, "checks": checks})
+}
+
+func (a *Application) checkDatabase(ctx context.Context) *dependencyStatus {
+	started := time.Now()
+	err := a.DB.PingContext(ctx)
+	return a.newDependencyStatus(ctx, "database", started, err)
+}
+
+func (a *Application) checkMigrations(ctx context.Context) *dependencyStatus {
+	started := time.Now()
+	expected, err := store.LatestMigrationVersion(migrations.FS, ".")
+	if err != nil {
+		return a.newDependencyStatus(ctx, "migrations", started, err)
+	}
+	version, err := store.MigrationVersion(ctx, a.DB)
+	if err != nil {
+		return a.newDependencyStatus(ctx, "migrations", started, err)
+	}
+
+	status := a.newDependencyStatus(ctx, "migrations", started, nil)
+	status.Version, status.Expected = &version, &expected
+	switch {
+	case version < expected:
+		status.Status, status.Error = "failing", "database schema is behind, migrations are pending"
+	case version > expected:
+		status.Status, status.Error = "failing", "database schema is ahead of this build"
+	}
+	return status
+}
+
+// newDependencyStatus logs the error of a failed check. The probe is not
+// authenticated, so it only answers that the dependency is unavailable
+// rather than giving away hosts or driver messages.
+func (a *Application) newDependencyStatus(ctx context.Context, name string, started time.Time, err error) *dependencyStatus {
+	status := &dependencyStatus{Status: "ok", LatencyMS: time.Since(started).Milliseconds()}
+	if err != nil {
+		a.Logger.ErrorContext(ctx, "readiness check failed", "dependency", name, "error", err)
+		status.Status, status.Error = "failing", "unavailable"
+	}
+	return status
+}
+
+type versionInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified"`
+}
+
+// HandleVersion reports what build is running, from the module version and
+// the version control details the go tool stamps into binaries.
+func (a *Application) HandleVersion(w http.ResponseWriter, r *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		utils.WriteJSON(w, http.StatusOK, utils.Envelope{"version": "unknown"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"version": newVersionInfo(info)})
+}
+
+func newVersionInfo(info *debug.BuildInfo) versionInfo {
+	v := versionInfo{Version: info.Main.Version, GoVersion: info.GoVersion}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			v.Revision = setting.Value
+		case "vcs.time":
+			v.Time = setting.Value
+		case "vcs.modified":
+			v.Modified = setting.Value == "true"
+		}
+	}
+	if v.Version == "" {
+		v.Version = "(devel)"
+	}
+	return v
+}
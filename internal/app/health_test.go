@@ -0,0 +1,51 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewVersionInfo(t *testing.T) {
+	info := &debug.BuildInfo{
+		GoVersion: "go1.24.2",
+		Main:      debug.Module{Path: "github.com/ShubhamkumarAnand/melkey-go/mel_project", Version: "v1.2.0"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs", Value: "git"},
+			{Key: "vcs.revision", Value: "3e3e6f1"},
+			{Key: "vcs.time", Value: "2025-06-01T10:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+
+	assert.Equal(t, versionInfo{
+		Version:   "v1.2.0",
+		GoVersion: "go1.24.2",
+		Revision:  "3e3e6f1",
+		Time:      "2025-06-01T10:00:00Z",
+		Modified:  true,
+	}, newVersionInfo(info))
+
+	assert.Equal(t, "(devel)", newVersionInfo(&debug.BuildInfo{}).Version)
+}
+
+func TestDependencyStatusHidesErrors(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "info", "json")
+	require.NoError(t, err)
+	a := &Application{Logger: logger}
+
+	status := a.newDependencyStatus(context.Background(), "database", time.Now(), errors.New("dial tcp 10.0.0.5:5432: connection refused"))
+	assert.Equal(t, "failing", status.Status)
+	assert.Equal(t, "unavailable", status.Error)
+	assert.Contains(t, buf.String(), "10.0.0.5:5432", "the cause is logged")
+
+	assert.Equal(t, "ok", a.newDependencyStatus(context.Background(), "database", time.Now(), nil).Status)
+}
@@ -0,0 +1,505 @@
+// Package config loads the settings of the server. Every setting has a
+// default that works on a development laptop; they can be overridden, in
+// increasing order of precedence, by a YAML or TOML file, environment
+// variables and command line flags.
+//
+// Secrets need not be put in the environment or the file directly: every
+// environment variable can instead be given as a path in NAME_FILE, eg.
+// DATABASE_DSN_FILE=/run/secrets/dsn, and the file has dsn_file,
+// totp_key_file and smtp_password_file for the same purpose.
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	// Development is set on a development machine, where the public
+	// defaults such as DevelopmentTOTPKey are good enough.
+	Development bool           `yaml:"development" toml:"development"`
+	Port        int            `yaml:"port" toml:"port"`
+	DB          DBConfig       `yaml:"db" toml:"db"`
+	Server      ServerConfig   `yaml:"server" toml:"server"`
+	Tokens      TokenConfig    `yaml:"tokens" toml:"tokens"`
+	Password    PasswordConfig `yaml:"password" toml:"password"`
+	MFA         MFAConfig      `yaml:"mfa" toml:"mfa"`
+	Mail        MailConfig     `yaml:"mail" toml:"mail"`
+	Log         LogConfig      `yaml:"log" toml:"log"`
+}
+
+type DBConfig struct {
+	DSN             string        `yaml:"dsn" toml:"dsn"`
+	DSNFile         string        `yaml:"dsn_file" toml:"dsn_file"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time"`
+	// AutoMigrate applies pending migrations when the server starts.
+	AutoMigrate bool `yaml:"auto_migrate" toml:"auto_migrate"`
+}
+
+type ServerConfig struct {
+	ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	// ShutdownDelay is how long the server keeps serving after a stop
+	// signal while reporting not ready, giving load balancers time to stop
+	// sending traffic.
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" toml:"shutdown_delay"`
+	// ShutdownTimeout bounds how long requests in flight may take to finish
+	// once the server stops accepting new ones.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// TrustedProxies are the addresses or CIDR ranges of the load balancers
+	// in front of the server. Only requests coming from them have their
+	// X-Forwarded-For header believed.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// TrustedProxyPrefixes parses TrustedProxies, taking single addresses as
+// ranges of one.
+func (c ServerConfig) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, proxy := range c.TrustedProxies {
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+type TokenConfig struct {
+	AccessTTL  time.Duration `yaml:"access_ttl" toml:"access_ttl"`
+	RefreshTTL time.Duration `yaml:"refresh_ttl" toml:"refresh_ttl"`
+}
+
+type PasswordConfig struct {
+	// Algorithm is "argon2id" or "bcrypt".
+	Algorithm         string `yaml:"algorithm" toml:"algorithm"`
+	Argon2MemoryKiB   uint32 `yaml:"argon2_memory_kib" toml:"argon2_memory_kib"`
+	Argon2Time        uint32 `yaml:"argon2_time" toml:"argon2_time"`
+	Argon2Parallelism uint8  `yaml:"argon2_parallelism" toml:"argon2_parallelism"`
+	BcryptCost        int    `yaml:"bcrypt_cost" toml:"bcrypt_cost"`
+	// MaxConcurrentHashes bounds how many argon2id hashes are computed at
+	// once, each taking Argon2MemoryKiB; 0 means one per CPU.
+	MaxConcurrentHashes int `yaml:"max_concurrent_hashes" toml:"max_concurrent_hashes"`
+}
+
+// DevelopmentTOTPKey is the default MFAConfig.TOTPKey. It is public, so it
+// only protects secrets on a development laptop, and the server refuses it
+// unless Development is set.
+const DevelopmentTOTPKey = "ZGV2ZWxvcG1lbnQtb25seS10b3RwLWtleS0wMDAwMDA="
+
+type MFAConfig struct {
+	// TOTPKey encrypts the authenticator app secrets in the database: 32
+	// bytes in standard base64, eg. from `openssl rand -base64 32`.
+	TOTPKey     string `yaml:"totp_key" toml:"totp_key"`
+	TOTPKeyFile string `yaml:"totp_key_file" toml:"totp_key_file"`
+}
+
+// TOTPKeyBytes decodes TOTPKey.
+func (c MFAConfig) TOTPKeyBytes() ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(c.TOTPKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("need 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// MailConfig picks the mail backend: SMTP when SMTPHost is set, an outbox
+// directory of .eml files when OutboxDir is set, the log otherwise.
+type MailConfig struct {
+	Sender           string `yaml:"sender" toml:"sender"`
+	SMTPHost         string `yaml:"smtp_host" toml:"smtp_host"`
+	SMTPPort         int    `yaml:"smtp_port" toml:"smtp_port"`
+	SMTPUsername     string `yaml:"smtp_username" toml:"smtp_username"`
+	SMTPPassword     string `yaml:"smtp_password" toml:"smtp_password"`
+	SMTPPasswordFile string `yaml:"smtp_password_file" toml:"smtp_password_file"`
+	OutboxDir        string `yaml:"outbox_dir" toml:"outbox_dir"`
+}
+
+type LogConfig struct {
+	// Level is one of "debug", "info", "warn" or "error".
+	Level string `yaml:"level" toml:"level"`
+	// Format is "text" or "json".
+	Format string `yaml:"format" toml:"format"`
+}
+
+func Default() *Config {
+	return &Config{
+		Port: 8080,
+		DB: DBConfig{
+			DSN:             "host=localhost user=postgres password=postgres dbname=postgres port=5432 sslmode=disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: time.Hour,
+			ConnMaxIdleTime: 15 * time.Minute,
+			AutoMigrate:     true,
+		},
+		Server: ServerConfig{
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     time.Minute,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Tokens: TokenConfig{
+			AccessTTL:  15 * time.Minute,
+			RefreshTTL: 30 * 24 * time.Hour,
+		},
+		Password: PasswordConfig{
+			Algorithm:         "argon2id",
+			Argon2MemoryKiB:   64 * 1024,
+			Argon2Time:        3,
+			Argon2Parallelism: 2,
+			BcryptCost:        12,
+		},
+		MFA: MFAConfig{
+			TOTPKey: DevelopmentTOTPKey,
+		},
+		Mail: MailConfig{
+			Sender:   "Workouts <no-reply@localhost>",
+			SMTPPort: 587,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the YAML or TOML file
+// named by -config or CONFIG_FILE, the environment and the command line args,
+// and validates the result. The settings' flags are added to fs, which may
+// already hold flags of its own; the args left after the flags are in
+// fs.Args().
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := Default()
+
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	cfg.bindFlags(fs)
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		err = cfg.loadFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = cfg.loadEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	// parse again so flags win over the file and the environment
+	err = fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Development, "dev", c.Development, "development mode, accepting the public development keys")
+	fs.IntVar(&c.Port, "port", c.Port, "go backend server port")
+	fs.StringVar(&c.DB.DSN, "db-dsn", c.DB.DSN, "postgres connection string")
+	fs.IntVar(&c.DB.MaxOpenConns, "db-max-open-conns", c.DB.MaxOpenConns, "maximum open database connections, 0 for no limit")
+	fs.IntVar(&c.DB.MaxIdleConns, "db-max-idle-conns", c.DB.MaxIdleConns, "maximum idle database connections")
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "log level: debug, info, warn or error")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "log format: text or json")
+}
+
+func (c *Config) loadFile(path string) error {
+	var decode func(f *os.File) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decode = func(f *os.File) error {
+			dec := yaml.NewDecoder(f)
+			dec.KnownFields(true)
+			return dec.Decode(c)
+		}
+	case ".toml":
+		decode = func(f *os.File) error {
+			md, err := toml.NewDecoder(f).Decode(c)
+			if err != nil {
+				return err
+			}
+			if undecoded := md.Undecoded(); len(undecoded) > 0 {
+				return fmt.Errorf("unknown setting %s", undecoded[0])
+			}
+			return nil
+		}
+	default:
+		return fmt.Errorf("config: %s: only YAML and TOML files are supported", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+
+	err = decode(f)
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	// the secrets are read straight away so the environment and flags
+	// still override them
+	return c.resolveSecrets()
+}
+
+func (c *Config) loadEnv() error {
+	var errs []error
+	setString := func(name string, dst *string) {
+		v, ok, err := lookupEnv(name)
+		if err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			*dst = v
+		}
+	}
+	setInt := func(name string, dst *int) {
+		v, ok, err := lookupEnv(name)
+		if err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = n
+		}
+	}
+	setBool := func(name string, dst *bool) {
+		v, ok, err := lookupEnv(name)
+		if err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = b
+		}
+	}
+	setUint := func(name string, bits int, set func(uint64)) {
+		v, ok, err := lookupEnv(name)
+		if err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			n, err := strconv.ParseUint(v, 10, bits)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			set(n)
+		}
+	}
+	// lists are comma separated
+	setList := func(name string, dst *[]string) {
+		v, ok, err := lookupEnv(name)
+		if err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			*dst = nil
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*dst = append(*dst, item)
+				}
+			}
+		}
+	}
+	setDuration := func(name string, dst *time.Duration) {
+		v, ok, err := lookupEnv(name)
+		if err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	setInt("PORT", &c.Port)
+
+	setString("DATABASE_DSN", &c.DB.DSN)
+	setInt("DB_MAX_OPEN_CONNS", &c.DB.MaxOpenConns)
+	setInt("DB_MAX_IDLE_CONNS", &c.DB.MaxIdleConns)
+	setDuration("DB_CONN_MAX_LIFETIME", &c.DB.ConnMaxLifetime)
+	setDuration("DB_CONN_MAX_IDLE_TIME", &c.DB.ConnMaxIdleTime)
+	setBool("DEVELOPMENT", &c.Development)
+	setBool("DB_AUTO_MIGRATE", &c.DB.AutoMigrate)
+
+	setDuration("SERVER_READ_TIMEOUT", &c.Server.ReadTimeout)
+	setDuration("SERVER_WRITE_TIMEOUT", &c.Server.WriteTimeout)
+	setDuration("SERVER_IDLE_TIMEOUT", &c.Server.IdleTimeout)
+	setDuration("SERVER_SHUTDOWN_DELAY", &c.Server.ShutdownDelay)
+	setDuration("SERVER_SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+	setList("SERVER_TRUSTED_PROXIES", &c.Server.TrustedProxies)
+
+	setDuration("ACCESS_TOKEN_TTL", &c.Tokens.AccessTTL)
+	setDuration("REFRESH_TOKEN_TTL", &c.Tokens.RefreshTTL)
+
+	setString("PASSWORD_HASH_ALGORITHM", &c.Password.Algorithm)
+	setUint("ARGON2_MEMORY_KIB", 32, func(n uint64) { c.Password.Argon2MemoryKiB = uint32(n) })
+	setUint("ARGON2_TIME", 32, func(n uint64) { c.Password.Argon2Time = uint32(n) })
+	setUint("ARGON2_PARALLELISM", 8, func(n uint64) { c.Password.Argon2Parallelism = uint8(n) })
+	setInt("BCRYPT_COST", &c.Password.BcryptCost)
+	setInt("PASSWORD_MAX_CONCURRENT_HASHES", &c.Password.MaxConcurrentHashes)
+
+	setString("TOTP_ENCRYPTION_KEY", &c.MFA.TOTPKey)
+
+	setString("MAIL_SENDER", &c.Mail.Sender)
+	setString("SMTP_HOST", &c.Mail.SMTPHost)
+	setInt("SMTP_PORT", &c.Mail.SMTPPort)
+	setString("SMTP_USERNAME", &c.Mail.SMTPUsername)
+	setString("SMTP_PASSWORD", &c.Mail.SMTPPassword)
+	setString("MAIL_OUTBOX_DIR", &c.Mail.OutboxDir)
+
+	setString("LOG_LEVEL", &c.Log.Level)
+	setString("LOG_FORMAT", &c.Log.Format)
+
+	return errors.Join(errs...)
+}
+
+// lookupEnv returns the value of the environment variable name or, when
+// only NAME_FILE is set, the contents of the file it names.
+func lookupEnv(name string) (string, bool, error) {
+	if v, ok := os.LookupEnv(name); ok {
+		return v, true, nil
+	}
+	path, ok := os.LookupEnv(name + "_FILE")
+	if !ok {
+		return "", false, nil
+	}
+	v, err := readSecret(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	return v, true, nil
+}
+
+func readSecret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// resolveSecrets reads the secrets the config file points to.
+func (c *Config) resolveSecrets() error {
+	if c.DB.DSNFile != "" {
+		dsn, err := readSecret(c.DB.DSNFile)
+		if err != nil {
+			return fmt.Errorf("config: db.dsn_file: %w", err)
+		}
+		c.DB.DSN = dsn
+	}
+	if c.MFA.TOTPKeyFile != "" {
+		key, err := readSecret(c.MFA.TOTPKeyFile)
+		if err != nil {
+			return fmt.Errorf("config: mfa.totp_key_file: %w", err)
+		}
+		c.MFA.TOTPKey = key
+	}
+	if c.Mail.SMTPPasswordFile != "" {
+		password, err := readSecret(c.Mail.SMTPPasswordFile)
+		if err != nil {
+			return fmt.Errorf("config: mail.smtp_password_file: %w", err)
+		}
+		c.Mail.SMTPPassword = password
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Port > 0 && c.Port <= 65535, "port must be between 1 and 65535, got %d", c.Port)
+
+	check(c.DB.DSN != "", "db.dsn is required")
+	check(c.DB.MaxOpenConns >= 0, "db.max_open_conns must not be negative")
+	check(c.DB.MaxIdleConns >= 0, "db.max_idle_conns must not be negative")
+	check(c.DB.MaxOpenConns == 0 || c.DB.MaxIdleConns <= c.DB.MaxOpenConns, "db.max_idle_conns must not exceed db.max_open_conns")
+	check(c.DB.ConnMaxLifetime >= 0, "db.conn_max_lifetime must not be negative")
+	check(c.DB.ConnMaxIdleTime >= 0, "db.conn_max_idle_time must not be negative")
+
+	check(c.Server.ReadTimeout > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout must be positive")
+	check(c.Server.IdleTimeout > 0, "server.idle_timeout must be positive")
+	check(c.Server.ShutdownDelay >= 0, "server.shutdown_delay must not be negative")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	if _, err := c.Server.TrustedProxyPrefixes(); err != nil {
+		check(false, "server.trusted_proxies must be IP addresses or CIDR ranges: %v", err)
+	}
+
+	check(c.Tokens.AccessTTL > 0, "tokens.access_ttl must be positive")
+	check(c.Tokens.RefreshTTL > c.Tokens.AccessTTL, "tokens.refresh_ttl must be longer than tokens.access_ttl")
+
+	check(c.Password.Algorithm == "argon2id" || c.Password.Algorithm == "bcrypt", "password.algorithm must be argon2id or bcrypt, got %q", c.Password.Algorithm)
+	check(c.Password.MaxConcurrentHashes >= 0, "password.max_concurrent_hashes must not be negative")
+
+	if _, err := c.MFA.TOTPKeyBytes(); err != nil {
+		check(false, "mfa.totp_key must be 32 bytes in base64: %v", err)
+	}
+
+	if c.Mail.SMTPHost != "" {
+		check(c.Mail.SMTPPort > 0 && c.Mail.SMTPPort <= 65535, "mail.smtp_port must be between 1 and 65535, got %d", c.Mail.SMTPPort)
+	}
+
+	switch c.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json, got %q", c.Log.Format)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("config: %w", errors.Join(errs...))
+	}
+	return nil
+}
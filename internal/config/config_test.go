@@ -0,0 +1,183 @@
+package config
+
+import (
+	"flag"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func newFlagSet() *flag.FlagSet {
+	return flag.NewFlagSet("test", flag.ContinueOnError)
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load(newFlagSet(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+port: 9000
+db:
+  dsn: "host=db.internal"
+  max_open_conns: 50
+tokens:
+  access_ttl: 5m
+log:
+  level: warn
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("DB_MAX_OPEN_CONNS", "40")
+	t.Setenv("LOG_LEVEL", "error")
+
+	cfg, err := Load(newFlagSet(), []string{"-log-level", "debug"})
+	require.NoError(t, err)
+
+	assert.Equal(t, 9000, cfg.Port, "from the file")
+	assert.Equal(t, "host=db.internal", cfg.DB.DSN, "from the file")
+	assert.Equal(t, 5*time.Minute, cfg.Tokens.AccessTTL, "from the file")
+	assert.Equal(t, 40, cfg.DB.MaxOpenConns, "the environment wins over the file")
+	assert.Equal(t, "debug", cfg.Log.Level, "flags win over everything")
+	assert.Equal(t, 30*24*time.Hour, cfg.Tokens.RefreshTTL, "untouched defaults stay")
+}
+
+func TestLoadCommandArgs(t *testing.T) {
+	t.Setenv("DB_AUTO_MIGRATE", "false")
+
+	fs := newFlagSet()
+	verbose := fs.Bool("v", false, "")
+	cfg, err := Load(fs, []string{"-v", "-dev", "-port", "9000", "status"})
+	require.NoError(t, err)
+
+	assert.True(t, *verbose, "the command's own flags are parsed")
+	assert.True(t, cfg.Development)
+	assert.Equal(t, 9000, cfg.Port)
+	assert.False(t, cfg.DB.AutoMigrate)
+	assert.Equal(t, []string{"status"}, fs.Args())
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+port = 9000
+
+[db]
+dsn = "host=db.internal"
+conn_max_lifetime = "30m"
+
+[password]
+argon2_memory_kib = 32768
+
+[log]
+level = "warn"
+`)
+	cfg, err := Load(newFlagSet(), []string{"-config", path})
+	require.NoError(t, err)
+
+	assert.Equal(t, 9000, cfg.Port)
+	assert.Equal(t, "host=db.internal", cfg.DB.DSN)
+	assert.Equal(t, 30*time.Minute, cfg.DB.ConnMaxLifetime)
+	assert.Equal(t, "warn", cfg.Log.Level)
+	assert.Equal(t, uint32(32768), cfg.Password.Argon2MemoryKiB)
+	assert.Equal(t, 25, cfg.DB.MaxOpenConns, "untouched defaults stay")
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	dsnFile := writeFile(t, "dsn", "host=secret.internal\n")
+	passwordFile := writeFile(t, "smtp", "hunter2\n")
+	keyFile := writeFile(t, "totp", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=\n")
+	path := writeFile(t, "config.yml", "mail:\n  smtp_host: smtp.example.com\n  smtp_password_file: "+passwordFile+"\nmfa:\n  totp_key_file: "+keyFile+"\n")
+
+	t.Setenv("DATABASE_DSN_FILE", dsnFile)
+	cfg, err := Load(newFlagSet(), []string{"-config", path})
+	require.NoError(t, err)
+
+	assert.Equal(t, "host=secret.internal", cfg.DB.DSN)
+	assert.Equal(t, "hunter2", cfg.Mail.SMTPPassword)
+	key, err := cfg.MFA.TOTPKeyBytes()
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef0123456789abcdef", string(key))
+}
+
+func TestLoadSecretFilePrecedence(t *testing.T) {
+	dsnFile := writeFile(t, "dsn", "host=from-file\n")
+	path := writeFile(t, "config.yaml", "db:\n  dsn_file: "+dsnFile+"\n")
+
+	cfg, err := Load(newFlagSet(), []string{"-config", path})
+	require.NoError(t, err)
+	assert.Equal(t, "host=from-file", cfg.DB.DSN)
+
+	t.Setenv("DATABASE_DSN", "host=from-env")
+	cfg, err = Load(newFlagSet(), []string{"-config", path})
+	require.NoError(t, err)
+	assert.Equal(t, "host=from-env", cfg.DB.DSN, "the environment wins over dsn_file")
+
+	cfg, err = Load(newFlagSet(), []string{"-config", path, "-db-dsn", "host=from-flag"})
+	require.NoError(t, err)
+	assert.Equal(t, "host=from-flag", cfg.DB.DSN, "flags win over dsn_file")
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := Load(newFlagSet(), []string{"-config", writeFile(t, "config.yaml", "prot: 80\n")})
+	assert.ErrorContains(t, err, "prot", "unknown keys are rejected")
+
+	_, err = Load(newFlagSet(), []string{"-config", writeFile(t, "config.toml", "prot = 80\n")})
+	assert.ErrorContains(t, err, "prot", "unknown keys are rejected")
+
+	_, err = Load(newFlagSet(), []string{"-config", writeFile(t, "config.json", "{}")})
+	assert.Error(t, err)
+
+	t.Setenv("ACCESS_TOKEN_TTL", "soon")
+	_, err = Load(newFlagSet(), nil)
+	assert.ErrorContains(t, err, "ACCESS_TOKEN_TTL")
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Port = 0
+	cfg.DB.MaxIdleConns = 100
+	cfg.Tokens.RefreshTTL = time.Minute
+	cfg.Log.Level = "verbose"
+	cfg.Log.Format = "xml"
+	cfg.Password.MaxConcurrentHashes = -1
+	cfg.MFA.TOTPKey = "c2hvcnQ="
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/33"}
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	for _, want := range []string{"port", "server.trusted_proxies", "db.max_idle_conns", "tokens.refresh_ttl", "password.max_concurrent_hashes", "mfa.totp_key", "log.level", "log.format"} {
+		assert.ErrorContains(t, err, want)
+	}
+	assert.NoError(t, Default().Validate())
+}
+
+func TestTrustedProxies(t *testing.T) {
+	t.Setenv("SERVER_TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.4,,::1")
+
+	cfg, err := Load(newFlagSet(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.4", "::1"}, cfg.Server.TrustedProxies)
+
+	prefixes, err := cfg.Server.TrustedProxyPrefixes()
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.4/32"),
+		netip.MustParsePrefix("::1/128"),
+	}, prefixes)
+
+	_, err = ServerConfig{TrustedProxies: []string{"proxy.internal"}}.TrustedProxyPrefixes()
+	assert.Error(t, err)
+}
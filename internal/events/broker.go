@@ -0,0 +1,182 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+)
+
+const (
+	// subscriptionBuffer is how many events a slow client may fall behind
+	// before it is disconnected.
+	subscriptionBuffer = 64
+	maxReconnectDelay  = 30 * time.Second
+)
+
+// Subscription receives the events of one user. C is closed when the
+// subscription is closed, the broker is closed or the subscription is
+// dropped for falling behind.
+type Subscription struct {
+	C      <-chan Event
+	events chan Event
+	userID int
+	filter Filter
+	broker *Broker
+	once   sync.Once
+	// dropped is guarded by broker.mu
+	dropped bool
+}
+
+func (s *Subscription) Close() {
+	s.broker.unsubscribe(s)
+}
+
+// Dropped reports whether C was closed because the subscriber fell behind,
+// rather than because the broker or the subscription was closed.
+func (s *Subscription) Dropped() bool {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	return s.dropped
+}
+
+// Broker listens on Channel and delivers every event to the subscriptions of
+// the user it belongs to.
+type Broker struct {
+	db     *sql.DB
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	subs   map[int]map[*Subscription]struct{}
+	closed bool
+}
+
+func NewBroker(db *sql.DB, logger *slog.Logger) *Broker {
+	return &Broker{db: db, logger: logger, subs: map[int]map[*Subscription]struct{}{}}
+}
+
+func (b *Broker) Subscribe(userID int, filter Filter) *Subscription {
+	events := make(chan Event, subscriptionBuffer)
+	sub := &Subscription{C: events, events: events, userID: userID, filter: filter, broker: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		sub.once.Do(func() { close(events) })
+		return sub
+	}
+	if b.subs[userID] == nil {
+		b.subs[userID] = map[*Subscription]struct{}{}
+	}
+	b.subs[userID][sub] = struct{}{}
+	return sub
+}
+
+func (b *Broker) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeLocked(sub)
+}
+
+func (b *Broker) removeLocked(sub *Subscription) {
+	if subs, ok := b.subs[sub.userID]; ok {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(b.subs, sub.userID)
+		}
+	}
+	sub.once.Do(func() { close(sub.events) })
+}
+
+// Close ends every subscription, and any made later, so that streaming
+// clients disconnect and reconnect elsewhere when the server shuts down.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, subs := range b.subs {
+		for sub := range subs {
+			b.removeLocked(sub)
+		}
+	}
+}
+
+func (b *Broker) dispatch(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs[event.UserID] {
+		if !sub.filter.Matches(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			// the client stopped reading; dropping it beats blocking everyone else
+			sub.dropped = true
+			b.removeLocked(sub)
+		}
+	}
+}
+
+// Run listens for events until ctx is done, reconnecting with backoff when
+// the listening connection is lost.
+func (b *Broker) Run(ctx context.Context) {
+	delay := time.Second
+	for {
+		started := time.Now()
+		err := b.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > maxReconnectDelay {
+			delay = time.Second
+		}
+		b.logger.ErrorContext(ctx, "listening for events", "channel", Channel, "error", err, "retry_in", delay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// listen holds a dedicated connection from the pool for as long as it is
+// healthy, since LISTEN is tied to the connection that issued it.
+func (b *Broker) listen(ctx context.Context) error {
+	conn, err := b.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		if _, err := pgConn.Exec(ctx, "LISTEN "+pgx.Identifier{Channel}.Sanitize()); err != nil {
+			return err
+		}
+		// a session only closes cleanly once it stops listening
+		defer pgConn.Exec(context.Background(), "UNLISTEN *")
+
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+
+			var event Event
+			if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+				b.logger.ErrorContext(ctx, "decoding event payload", "channel", Channel, "error", err)
+				continue
+			}
+			b.dispatch(event)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package events
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestFilterMatches(t *testing.T) {
+	event := Event{Type: SessionSetLogged, UserID: 1, SessionID: intPtr(5)}
+
+	assert.True(t, Filter{}.Matches(event))
+	assert.True(t, Filter{SessionID: intPtr(5)}.Matches(event))
+	assert.False(t, Filter{SessionID: intPtr(6)}.Matches(event))
+	assert.False(t, Filter{WorkoutID: intPtr(5)}.Matches(event))
+}
+
+func TestBrokerDispatch(t *testing.T) {
+	broker := NewBroker(nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	all := broker.Subscribe(1, Filter{})
+	session := broker.Subscribe(1, Filter{SessionID: intPtr(5)})
+	other := broker.Subscribe(2, Filter{})
+
+	broker.dispatch(Event{Type: WorkoutCreated, UserID: 1, WorkoutID: intPtr(9)})
+	broker.dispatch(Event{Type: SessionSetLogged, UserID: 1, SessionID: intPtr(5)})
+
+	assert.Equal(t, WorkoutCreated, (<-all.C).Type)
+	assert.Equal(t, SessionSetLogged, (<-all.C).Type)
+	assert.Equal(t, SessionSetLogged, (<-session.C).Type)
+	assert.Empty(t, other.C)
+
+	all.Close()
+	_, ok := <-all.C
+	assert.False(t, ok)
+	all.Close()
+
+	// a subscriber that stops reading is dropped instead of blocking the rest
+	for i := 0; i <= subscriptionBuffer; i++ {
+		broker.dispatch(Event{Type: SessionSetLogged, UserID: 1, SessionID: intPtr(5)})
+	}
+	received := 0
+	for range session.C {
+		received++
+	}
+	require.Equal(t, subscriptionBuffer, received)
+	assert.True(t, session.Dropped())
+	assert.False(t, all.Dropped())
+	other.Close()
+	assert.Empty(t, broker.subs)
+}
+
+func TestBrokerClose(t *testing.T) {
+	broker := NewBroker(nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	sub := broker.Subscribe(1, Filter{})
+
+	broker.Close()
+	_, ok := <-sub.C
+	assert.False(t, ok, "open subscriptions end")
+	assert.False(t, sub.Dropped(), "shutting down is not falling behind")
+	assert.Empty(t, broker.subs)
+
+	late := broker.Subscribe(1, Filter{})
+	_, ok = <-late.C
+	assert.False(t, ok, "subscriptions after Close end straight away")
+	late.Close()
+	sub.Close()
+}
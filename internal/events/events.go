@@ -0,0 +1,51 @@
+// Package events fans out workout and session changes to streaming clients.
+// Changes are published by database triggers on the workout_events channel
+// (see migrations/0014_workout_events.sql), so every server instance
+// listening on it sees every change no matter which instance made it.
+package events
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Channel is the postgres NOTIFY channel events are published on.
+const Channel = "workout_events"
+
+const (
+	WorkoutCreated     = "workout.created"
+	WorkoutUpdated     = "workout.updated"
+	WorkoutDeleted     = "workout.deleted"
+	SessionStarted     = "session.started"
+	SessionSetLogged   = "session.set_logged"
+	SessionSetDeleted  = "session.set_deleted"
+	SessionRestUpdated = "session.rest_updated"
+	SessionFinished    = "session.finished"
+	SessionAbandoned   = "session.abandoned"
+)
+
+type Event struct {
+	Type      string          `json:"type"`
+	UserID    int             `json:"user_id"`
+	WorkoutID *int            `json:"workout_id,omitempty"`
+	SessionID *int            `json:"session_id,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	At        time.Time       `json:"at"`
+}
+
+// Filter narrows a subscription down to a single workout or session. The
+// zero Filter matches every event of the subscribed user.
+type Filter struct {
+	WorkoutID *int
+	SessionID *int
+}
+
+func (f Filter) Matches(e Event) bool {
+	if f.WorkoutID != nil && (e.WorkoutID == nil || *e.WorkoutID != *f.WorkoutID) {
+		return false
+	}
+	if f.SessionID != nil && (e.SessionID == nil || *e.SessionID != *f.SessionID) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+// Package logging builds the structured logger of the server and carries
+// request-scoped fields through contexts: whatever is logged with a request's
+// context, by a handler, a store or a middleware, gets the request's fields
+// such as its ID and user. Values of sensitive fields (passwords, tokens,
+// Authorization headers, ...) never reach the output.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const redacted = "[REDACTED]"
+
+// New returns a logger writing to w at level ("debug", "info", "warn" or
+// "error") in format, "json" or "text".
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, fmt.Errorf("logging: %w", err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: redact}
+	var handler slog.Handler
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("logging: unknown format %q", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// IsSensitive reports whether values logged under key, or sent in the
+// header named key, must be redacted.
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range []string{"password", "secret", "authorization", "cookie", "dsn"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return strings.HasSuffix(key, "token") || key == "code" || strings.HasPrefix(key, "recovery_code")
+}
+
+// RedactHeader returns a copy of h with the values of sensitive headers
+// replaced.
+func RedactHeader(h http.Header) http.Header {
+	h = h.Clone()
+	for name := range h {
+		if IsSensitive(name) {
+			h[name] = []string{redacted}
+		}
+	}
+	return h
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if IsSensitive(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	if h, ok := a.Value.Any().(http.Header); ok {
+		return slog.Any(a.Key, RedactHeader(h))
+	}
+	return a
+}
+
+type contextKey string
+
+const (
+	loggerContextKey = contextKey("logger")
+	attrsContextKey  = contextKey("attrs")
+)
+
+// attrSet holds the fields of one request. It is shared by every context
+// derived from the request's, so fields added deep in the middleware chain,
+// like the user, still show up in the request log written at the top.
+type attrSet struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// NewContext returns a context carrying logger and an empty set of fields
+// that AddAttrs fills in.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	ctx = context.WithValue(ctx, loggerContextKey, logger)
+	return context.WithValue(ctx, attrsContextKey, &attrSet{})
+}
+
+// FromContext returns the logger stored by NewContext, or the default
+// logger when there is none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// AddAttrs adds key-value pairs, as taken by slog.Logger.Info, to the fields
+// of ctx. They are added to everything logged with ctx, or a context derived
+// from it, from then on. Without NewContext it does nothing.
+func AddAttrs(ctx context.Context, args ...any) {
+	set, ok := ctx.Value(attrsContextKey).(*attrSet)
+	if !ok {
+		return
+	}
+	attrs := slog.Group("", args...).Value.Group()
+	set.mu.Lock()
+	set.attrs = append(set.attrs, attrs...)
+	set.mu.Unlock()
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	set, ok := ctx.Value(attrsContextKey).(*attrSet)
+	if !ok {
+		return nil
+	}
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	return set.attrs[:len(set.attrs):len(set.attrs)]
+}
+
+// contextHandler adds the fields of the context a record is logged with.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs := attrsFrom(ctx); len(attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
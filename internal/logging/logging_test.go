@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var lines []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var line map[string]any
+		require.NoError(t, dec.Decode(&line))
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "warn", "json")
+	require.NoError(t, err)
+
+	logger.Info("dropped")
+	logger.Warn("kept", "n", 1)
+	lines := decodeLines(t, &buf)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "kept", lines[0]["msg"])
+	assert.Equal(t, float64(1), lines[0]["n"])
+
+	_, err = New(&buf, "loud", "json")
+	assert.Error(t, err)
+	_, err = New(&buf, "info", "xml")
+	assert.Error(t, err)
+}
+
+func TestRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "info", "json")
+	require.NoError(t, err)
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer abc")
+	header.Set("User-Agent", "curl")
+	logger.Info("signing in",
+		"username", "alice",
+		"password", "hunter2",
+		"refresh_token", "def",
+		"token_id", 7,
+		"header", header,
+	)
+
+	line := decodeLines(t, &buf)[0]
+	assert.Equal(t, "alice", line["username"])
+	assert.Equal(t, redacted, line["password"])
+	assert.Equal(t, redacted, line["refresh_token"])
+	assert.Equal(t, float64(7), line["token_id"])
+	assert.Equal(t, map[string]any{
+		"Authorization": []any{redacted},
+		"User-Agent":    []any{"curl"},
+	}, line["header"])
+	assert.Equal(t, "Bearer abc", header.Get("Authorization"), "the header itself is left alone")
+}
+
+func TestContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "info", "json")
+	require.NoError(t, err)
+
+	ctx := NewContext(context.Background(), logger)
+	assert.Same(t, logger, FromContext(ctx))
+	AddAttrs(ctx, "request_id", "r1")
+
+	// fields added through a derived context are shared with the parent
+	child, cancel := context.WithCancel(ctx)
+	defer cancel()
+	AddAttrs(child, "user_id", 42)
+
+	logger.InfoContext(ctx, "handled")
+	logger.Info("no context")
+	lines := decodeLines(t, &buf)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "r1", lines[0]["request_id"])
+	assert.Equal(t, float64(42), lines[0]["user_id"])
+	assert.NotContains(t, lines[1], "request_id")
+
+	AddAttrs(context.Background(), "ignored", true)
+	assert.NotNil(t, FromContext(context.Background()))
+}
@@ -0,0 +1,134 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull = errors.New("mailer: queue is full")
+	ErrClosed    = errors.New("mailer: closed")
+)
+
+const (
+	asyncQueueSize   = 256
+	asyncWorkers     = 2
+	asyncMaxAttempts = 4
+	asyncRetryDelay  = 2 * time.Second
+)
+
+// AsyncMailer queues messages and delivers them through the wrapped Mailer
+// from background workers, retrying failures with exponential backoff, so
+// a slow or unreachable mail server never holds up a request.
+type AsyncMailer struct {
+	next       Mailer
+	logger     *slog.Logger
+	queue      chan Message
+	retryDelay time.Duration
+	// stop is closed when Close runs out of time, cutting retries short and
+	// dropping the messages still queued.
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewAsyncMailer(next Mailer, logger *slog.Logger) *AsyncMailer {
+	m := &AsyncMailer{
+		next:       next,
+		logger:     logger,
+		queue:      make(chan Message, asyncQueueSize),
+		retryDelay: asyncRetryDelay,
+		stop:       make(chan struct{}),
+	}
+	for i := 0; i < asyncWorkers; i++ {
+		m.wg.Add(1)
+		go m.work()
+	}
+	return m
+}
+
+// Send queues msg and returns straight away. It only fails when the queue
+// is full or the mailer was closed.
+func (m *AsyncMailer) Send(msg Message) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return ErrClosed
+	}
+
+	select {
+	case m.queue <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting messages and waits for the queued ones to be sent.
+// Once ctx is done it gives up on them instead: retries stop, the messages
+// left are dropped and logged, and Close returns ctx's error without waiting
+// for a delivery in progress.
+func (m *AsyncMailer) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		m.stopOnce.Do(func() { close(m.stop) })
+		return ctx.Err()
+	}
+}
+
+func (m *AsyncMailer) work() {
+	defer m.wg.Done()
+	for msg := range m.queue {
+		select {
+		case <-m.stop:
+			m.logger.Error("dropping mail on shutdown", "to", msg.To, "subject", msg.Subject)
+			continue
+		default:
+		}
+		m.deliver(msg)
+	}
+}
+
+func (m *AsyncMailer) deliver(msg Message) {
+	delay := m.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := m.next.Send(msg)
+		if err == nil {
+			return
+		}
+		if attempt == asyncMaxAttempts {
+			m.logger.Error("giving up on mail", "to", msg.To, "subject", msg.Subject, "attempts", attempt, "error", err)
+			return
+		}
+		m.logger.Warn("sending mail", "to", msg.To, "attempt", attempt, "error", err)
+
+		select {
+		case <-time.After(delay):
+		case <-m.stop:
+			m.logger.Error("giving up on mail on shutdown", "to", msg.To, "subject", msg.Subject, "attempts", attempt, "error", err)
+			return
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,164 @@
+// Package mailer sends the emails of account flows such as activation and
+// password resets. Handlers depend on the Mailer interface so the delivery
+// backend can be swapped without touching them: SMTP in production, an
+// outbox directory or the log during development and tests.
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/mailer/templates"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// emailTemplates holds every template of templates.FS by file name. Each
+// file defines a "subject", a "plainBody" and an optional "htmlBody".
+var emailTemplates = mustParseTemplates(templates.FS)
+
+func mustParseTemplates(fsys fs.FS) map[string]emailTemplate {
+	names, err := fs.Glob(fsys, "*.tmpl")
+	if err != nil {
+		panic(err)
+	}
+
+	parsed := make(map[string]emailTemplate, len(names))
+	for _, name := range names {
+		parsed[name] = emailTemplate{
+			text: texttemplate.Must(texttemplate.New("").ParseFS(fsys, name)),
+			html: htmltemplate.Must(htmltemplate.New("").ParseFS(fsys, name)),
+		}
+	}
+	return parsed
+}
+
+// Render builds the message for recipient from the named template, eg.
+// Render(user.Email, "activation.tmpl", data).
+func Render(recipient, name string, data any) (Message, error) {
+	tmpl, ok := emailTemplates[name]
+	if !ok {
+		return Message{}, fmt.Errorf("mailer: unknown template %q", name)
+	}
+
+	msg := Message{To: recipient}
+	var buf bytes.Buffer
+
+	if err := tmpl.text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return Message{}, fmt.Errorf("mailer: %s subject: %w", name, err)
+	}
+	msg.Subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := tmpl.text.ExecuteTemplate(&buf, "plainBody", data); err != nil {
+		return Message{}, fmt.Errorf("mailer: %s plainBody: %w", name, err)
+	}
+	msg.Text = strings.TrimSpace(buf.String()) + "\n"
+
+	if tmpl.html.Lookup("htmlBody") != nil {
+		buf.Reset()
+		if err := tmpl.html.ExecuteTemplate(&buf, "htmlBody", data); err != nil {
+			return Message{}, fmt.Errorf("mailer: %s htmlBody: %w", name, err)
+		}
+		msg.HTML = strings.TrimSpace(buf.String()) + "\n"
+	}
+	return msg, nil
+}
+
+// encode formats msg as a MIME email from sender, with a plain text and,
+// when there is one, an HTML alternative.
+func encode(sender string, msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	header := textproto.MIMEHeader{}
+	header.Set("From", sender)
+	header.Set("To", msg.To)
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header.Set("Date", time.Now().Format(time.RFC1123Z))
+	header.Set("Message-ID", messageID(sender))
+	header.Set("MIME-Version", "1.0")
+
+	writer := multipart.NewWriter(&buf)
+	header.Set("Content-Type", "multipart/alternative; boundary="+writer.Boundary())
+	for _, key := range []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type"} {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, header.Get(key))
+	}
+	buf.WriteString("\r\n")
+
+	parts := []struct{ contentType, body string }{{"text/plain", msg.Text}}
+	if msg.HTML != "" {
+		parts = append(parts, struct{ contentType, body string }{"text/html", msg.HTML})
+	}
+	for _, part := range parts {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write([]byte(strings.ReplaceAll(part.body, "\n", "\r\n"))); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func messageID(sender string) string {
+	domain := "localhost"
+	if at := strings.LastIndex(sender, "@"); at >= 0 {
+		domain = strings.Trim(sender[at+1:], "> ")
+	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), randomHex(8), domain)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LogMailer writes messages to the log instead of delivering them, for
+// development without a mail server. Bodies carry tokens as good as a
+// password, so they are only logged at debug level; elsewhere the log shows
+// who was sent what.
+type LogMailer struct {
+	logger *slog.Logger
+}
+
+func NewLogMailer(logger *slog.Logger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	m.logger.Info("mail", "to", msg.To, "subject", msg.Subject)
+	m.logger.Debug("mail body", "to", msg.To, "body", msg.Text)
+	return nil
+}
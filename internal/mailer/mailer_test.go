@@ -0,0 +1,136 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	data := map[string]string{"Username": "sam", "Token": "ABC<123>", "ExpiresIn": "3 days"}
+
+	for _, name := range []string{"activation.tmpl", "password_reset.tmpl"} {
+		t.Run(name, func(t *testing.T) {
+			msg, err := Render("sam@example.com", name, data)
+			require.NoError(t, err)
+
+			assert.Equal(t, "sam@example.com", msg.To)
+			assert.NotEmpty(t, msg.Subject)
+			assert.Contains(t, msg.Text, "ABC<123>")
+			assert.Contains(t, msg.HTML, "ABC&lt;123&gt;", "html bodies are escaped")
+		})
+	}
+
+	_, err := Render("sam@example.com", "missing.tmpl", data)
+	assert.Error(t, err)
+}
+
+func TestOutboxMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	m, err := NewOutboxMailer(dir, "Workouts <no-reply@example.com>")
+	require.NoError(t, err)
+
+	err = m.Send(Message{To: "sam@example.com", Subject: "Hello", Text: "plain\n", HTML: "<p>html</p>\n"})
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+
+	parsed, err := mail.ReadMessage(f)
+	require.NoError(t, err)
+	assert.Equal(t, "sam@example.com", parsed.Header.Get("To"))
+	assert.Equal(t, "Hello", parsed.Header.Get("Subject"))
+	assert.Contains(t, parsed.Header.Get("Content-Type"), "multipart/alternative")
+}
+
+type flakyMailer struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	sent     []Message
+}
+
+func (m *flakyMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts++
+	if m.attempts <= m.failures {
+		return errors.New("connection refused")
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestAsyncMailerRetries(t *testing.T) {
+	backend := &flakyMailer{failures: 2}
+	m := NewAsyncMailer(backend, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m.retryDelay = time.Millisecond
+
+	require.NoError(t, m.Send(Message{To: "sam@example.com"}))
+	require.NoError(t, m.Close(context.Background()))
+
+	assert.Equal(t, 3, backend.attempts)
+	assert.Len(t, backend.sent, 1)
+	assert.ErrorIs(t, m.Send(Message{}), ErrClosed)
+}
+
+func TestAsyncMailerGivesUp(t *testing.T) {
+	backend := &flakyMailer{failures: 100}
+	m := NewAsyncMailer(backend, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m.retryDelay = time.Millisecond
+
+	require.NoError(t, m.Send(Message{To: "sam@example.com"}))
+	require.NoError(t, m.Close(context.Background()))
+
+	assert.Equal(t, asyncMaxAttempts, backend.attempts)
+	assert.Empty(t, backend.sent)
+}
+
+func TestAsyncMailerCloseTimeout(t *testing.T) {
+	backend := &flakyMailer{failures: 100}
+	m := NewAsyncMailer(backend, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	m.retryDelay = time.Hour
+
+	for range asyncWorkers + 1 {
+		require.NoError(t, m.Send(Message{To: "sam@example.com"}))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	assert.ErrorIs(t, m.Close(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second, "retries do not hold up the shutdown")
+
+	// the workers wind down without sending the rest
+	m.wg.Wait()
+	assert.Equal(t, asyncWorkers, backend.attempts)
+}
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(slog.New(slog.NewTextHandler(&buf, nil)))
+	require.NoError(t, m.Send(Message{To: "sam@example.com", Subject: "Reset your password", Text: "token: ABCDEF"}))
+	assert.Contains(t, buf.String(), "sam@example.com")
+	assert.NotContains(t, buf.String(), "ABCDEF", "bodies stay out of info logs")
+
+	buf.Reset()
+	m = NewLogMailer(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	require.NoError(t, m.Send(Message{To: "sam@example.com", Text: "token: ABCDEF"}))
+	assert.Contains(t, buf.String(), "ABCDEF")
+}
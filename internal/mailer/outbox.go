@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// OutboxMailer drops every message as an .eml file into a directory instead
+// of sending it, for local development and tests. The files open in any
+// mail client.
+type OutboxMailer struct {
+	dir    string
+	sender string
+}
+
+func NewOutboxMailer(dir, sender string) (*OutboxMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("mailer: outbox: %w", err)
+	}
+	return &OutboxMailer{dir: dir, sender: sender}, nil
+}
+
+func (m *OutboxMailer) Send(msg Message) error {
+	body, err := encode(m.sender, msg)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), randomHex(4))
+	// write then rename so readers polling the directory never see half a file
+	tmp := filepath.Join(m.dir, "."+name)
+	if err := os.WriteFile(tmp, body, 0o644); err != nil {
+		return fmt.Errorf("mailer: outbox: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(m.dir, name))
+}
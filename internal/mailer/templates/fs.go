@@ -0,0 +1,6 @@
+package templates
+
+import "embed"
+
+//go:embed *.tmpl
+var FS embed.FS
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const clientIPContextKey = contextKey("client_ip")
+
+// TrustedProxies takes the client address from the X-Forwarded-For header
+// of requests relayed by one of proxies. The header is read right to left
+// and the first address that is not a trusted proxy is the client's, so
+// addresses a client puts in the header itself are ignored. Without
+// proxies the header is never read.
+func TrustedProxies(proxies []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(proxies) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip, ok := forwardedFor(r, proxies); ok {
+				r = r.WithContext(context.WithValue(r.Context(), clientIPContextKey, ip))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func forwardedFor(r *http.Request, proxies []netip.Prefix) (string, bool) {
+	remote, err := netip.ParseAddr(remoteHost(r))
+	if err != nil || !trusted(remote, proxies) {
+		return "", false
+	}
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap().String()
+		if !trusted(addr, proxies) {
+			break
+		}
+	}
+	return client, client != ""
+}
+
+func trusted(addr netip.Addr, proxies []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, proxy := range proxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientIP is the address the request came from, without the port: the
+// one forwarded by a trusted proxy, see TrustedProxies, or else the peer's.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey).(string); ok {
+		return ip
+	}
+	return remoteHost(r)
+}
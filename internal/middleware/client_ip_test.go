@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+	tests := []struct {
+		name         string
+		proxies      []netip.Prefix
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{name: "no proxies", remoteAddr: "203.0.113.7:4000", forwardedFor: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "untrusted peer", proxies: proxies, remoteAddr: "203.0.113.7:4000", forwardedFor: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "trusted peer", proxies: proxies, remoteAddr: "10.0.0.2:4000", forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "spoofed hops ignored", proxies: proxies, remoteAddr: "10.0.0.2:4000", forwardedFor: []string{"1.2.3.4, 198.51.100.1, 10.0.0.9"}, want: "198.51.100.1"},
+		{name: "repeated headers", proxies: proxies, remoteAddr: "[::1]:4000", forwardedFor: []string{"1.2.3.4", "198.51.100.1"}, want: "198.51.100.1"},
+		{name: "garbage stops the walk", proxies: proxies, remoteAddr: "10.0.0.2:4000", forwardedFor: []string{"198.51.100.1, nonsense"}, want: "10.0.0.2"},
+		{name: "no header", proxies: proxies, remoteAddr: "10.0.0.2:4000", want: "10.0.0.2"},
+		{name: "only proxies", proxies: proxies, remoteAddr: "10.0.0.2:4000", forwardedFor: []string{"10.0.0.3"}, want: "10.0.0.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := TrustedProxies(tt.proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -3,20 +3,30 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"slices"
 	"strings"
 
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/logging"
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/tokens"
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/utils"
 )
 
 type UserMiddleware struct {
-	UserStore store.UserStore
+	UserStore  store.UserStore
+	TokenStore store.TokenStore
 }
 
 type contextKey string
 
-const UserContextKey = contextKey("user")
+const (
+	UserContextKey    = contextKey("user")
+	TokenIDContextKey = contextKey("token_id")
+	// TokenScopesContextKey holds the access scopes of a personal access
+	// token; it is unset for tokens from a password sign-in.
+	TokenScopesContextKey  = contextKey("token_scopes")
+	scopeCheckedContextKey = contextKey("scope_checked")
+)
 
 func SetUser(r *http.Request, user *store.User) *http.Request {
 	ctx := context.WithValue(r.Context(), UserContextKey, user)
@@ -31,6 +41,25 @@ func GetUser(r *http.Request) *store.User {
 	return user
 }
 
+// GetTokenID returns the id of the token the request was authenticated with,
+// or 0 for anonymous requests.
+func GetTokenID(r *http.Request) int {
+	id, _ := r.Context().Value(TokenIDContextKey).(int)
+	return id
+}
+
+// GetTokenScopes returns the access scopes of the personal access token the
+// request was authenticated with, or nil when it was not made with one.
+func GetTokenScopes(r *http.Request) []string {
+	scopes, _ := r.Context().Value(TokenScopesContextKey).([]string)
+	return scopes
+}
+
+func isPersonalAccessRequest(r *http.Request) bool {
+	_, ok := r.Context().Value(TokenScopesContextKey).([]string)
+	return ok
+}
+
 func (um *UserMiddleware) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Injecting the incoming request into the server
@@ -49,7 +78,11 @@ func (um *UserMiddleware) Authenticate(next http.Handler) http.Handler {
 		}
 
 		token := headerParts[1]
-		user, err := um.UserStore.GetUserToken(tokens.ScopeAuth, token)
+		scope := tokens.ScopeAuth
+		if strings.HasPrefix(token, tokens.PersonalAccessTokenPrefix) {
+			scope = tokens.ScopePersonalAccess
+		}
+		user, err := um.UserStore.GetUserToken(scope, token)
 
 		if err != nil {
 			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Invalid Token"})
@@ -59,6 +92,23 @@ func (um *UserMiddleware) Authenticate(next http.Handler) http.Handler {
 			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Token Expired or Invalid"})
 			return
 		}
+
+		info, err := um.TokenStore.RecordTokenUsage(token, r.UserAgent(), ClientIP(r))
+		if err != nil {
+			logging.FromContext(r.Context()).ErrorContext(r.Context(), "RecordTokenUsage", "user_id", user.ID, "error", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"Error": "internal server error"})
+			return
+		}
+		ctx := context.WithValue(r.Context(), TokenIDContextKey, info.ID)
+		if scope == tokens.ScopePersonalAccess {
+			scopes := info.Scopes
+			if scopes == nil {
+				scopes = []string{}
+			}
+			ctx = context.WithValue(ctx, TokenScopesContextKey, scopes)
+		}
+		logging.AddAttrs(ctx, "user_id", user.ID)
+		r = r.WithContext(ctx)
 		r = SetUser(r, user)
 		next.ServeHTTP(w, r)
 		return
@@ -72,6 +122,58 @@ func (um *UserMiddleware) RequireUser(next http.HandlerFunc) http.HandlerFunc {
 			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"Error": "Unauthorized"})
 			return
 		}
+		// personal access tokens only reach endpoints that declare a scope
+		if isPersonalAccessRequest(r) && r.Context().Value(scopeCheckedContextKey) == nil {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"Error": "this endpoint cannot be used with a personal access token"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope lets personal access tokens through to next only when they
+// were granted scope; other requests pass unchanged. It wraps RequireUser
+// or RequireActivatedUser, which turn personal access tokens away from any
+// endpoint without a RequireScope:
+//
+//	um.RequireScope(tokens.AccessWorkoutsRead, um.RequireUser(h.HandleListWorkouts))
+func (um *UserMiddleware) RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPersonalAccessRequest(r) {
+			if !slices.Contains(GetTokenScopes(r), scope) {
+				utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"Error": "this token is missing the " + scope + " scope"})
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), scopeCheckedContextKey, true))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireActivatedUser is RequireUser for endpoints that also need a
+// verified email address.
+func (um *UserMiddleware) RequireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return um.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r)
+		if !user.Activated {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"Error": "your account must be activated to access this resource"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAdmin is RequireUser for endpoints reserved to admins.
+func (um *UserMiddleware) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return um.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r)
+		if !user.IsAdmin {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"Error": "this resource is reserved to admins"})
+			return
+		}
 
 		next.ServeHTTP(w, r)
 	})
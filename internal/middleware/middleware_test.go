@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/tokens"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireScope(t *testing.T) {
+	um := &UserMiddleware{}
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+
+	request := func(scopes []string) *http.Request {
+		r := httptest.NewRequest(http.MethodDelete, "/workouts/1", nil)
+		r = SetUser(r, &store.User{ID: 1, Activated: true})
+		if scopes != nil {
+			r = r.WithContext(context.WithValue(r.Context(), TokenScopesContextKey, scopes))
+		}
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		scopes  []string
+		want    int
+	}{
+		{"sign-in token", um.RequireScope(tokens.AccessWorkoutsWrite, um.RequireUser(ok)), nil, http.StatusNoContent},
+		{"granted scope", um.RequireScope(tokens.AccessWorkoutsWrite, um.RequireActivatedUser(ok)), []string{tokens.AccessWorkoutsWrite}, http.StatusNoContent},
+		{"read-only token", um.RequireScope(tokens.AccessWorkoutsWrite, um.RequireActivatedUser(ok)), []string{tokens.AccessWorkoutsRead}, http.StatusForbidden},
+		{"endpoint without scope", um.RequireUser(ok), []string{tokens.AccessWorkoutsRead, tokens.AccessWorkoutsWrite}, http.StatusForbidden},
+		{"sign-in token without scope", um.RequireUser(ok), nil, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, request(tt.scopes))
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	um := &UserMiddleware{}
+	handler := um.RequireAdmin(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	tests := []struct {
+		name string
+		user *store.User
+		want int
+	}{
+		{"anonymous", store.AnonymousUser, http.StatusUnauthorized},
+		{"user", &store.User{ID: 1, Activated: true}, http.StatusForbidden},
+		{"admin", &store.User{ID: 2, Activated: true, IsAdmin: true}, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, SetUser(httptest.NewRequest(http.MethodGet, "/admin/login-attempts", nil), tt.user))
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
+
+func TestRequireActivatedUser(t *testing.T) {
+	um := &UserMiddleware{}
+	handler := um.RequireActivatedUser(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+
+	tests := []struct {
+		name string
+		user *store.User
+		want int
+	}{
+		{"anonymous", store.AnonymousUser, http.StatusUnauthorized},
+		{"inactive", &store.User{ID: 1}, http.StatusForbidden},
+		{"activated", &store.User{ID: 2, Activated: true}, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, SetUser(httptest.NewRequest(http.MethodPost, "/workouts", nil), tt.user))
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "info", "json")
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Use(RequestLogger(logger))
+	r.Get("/workouts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		logging.AddAttrs(r.Context(), "user_id", 7)
+		logging.FromContext(r.Context()).WarnContext(r.Context(), "GetWorkoutByID", "error", "boom")
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/workouts/12", nil)
+	req.Header.Set(RequestIDHeader, "proxy-id")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, "proxy-id", rec.Header().Get(RequestIDHeader))
+
+	var lines []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var line map[string]any
+		require.NoError(t, dec.Decode(&line))
+		lines = append(lines, line)
+	}
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		assert.Equal(t, "proxy-id", line["request_id"])
+		assert.Equal(t, "GET", line["method"])
+		assert.Equal(t, "/workouts/12", line["path"])
+		assert.Equal(t, float64(7), line["user_id"])
+	}
+	assert.Equal(t, "GetWorkoutByID", lines[0]["msg"])
+	assert.Equal(t, "request", lines[1]["msg"])
+	assert.Equal(t, "/workouts/{id}", lines[1]["route"])
+	assert.Equal(t, float64(http.StatusTeapot), lines[1]["status"])
+	assert.Contains(t, lines[1], "latency")
+
+	req = httptest.NewRequest(http.MethodGet, "/workouts/12", nil)
+	req.Header.Set(RequestIDHeader, "has spaces")
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Len(t, rec.Header().Get(RequestIDHeader), 16, "invalid IDs are replaced")
+}
@@ -2,24 +2,93 @@ package routes
 
 import (
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/app"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/middleware"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/tokens"
 	"github.com/go-chi/chi/v5"
 )
 
 func SetupRoutes(app *app.Application) *chi.Mux {
 	r := chi.NewRouter()
+	r.Use(middleware.TrustedProxies(app.TrustedProxies))
+	r.Use(middleware.RequestLogger(app.Logger))
 
 	r.Group(func(r chi.Router) {
 		r.Use(app.Middleware.Authenticate)
-		r.Get("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.HandleGetWorkoutByID))
-		r.Post("/workouts", app.Middleware.RequireUser(app.WorkoutHandler.HandleCreateWorkout))
-		r.Put("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.HandleUpdateWorkoutByID))
-		r.Delete("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.HandleDeleteWorkoutByID))
+		r.Get("/workouts", app.Middleware.RequireScope(tokens.AccessWorkoutsRead, app.Middleware.RequireUser(app.WorkoutHandler.HandleListWorkouts)))
+		r.Get("/workouts/{id}", app.Middleware.RequireScope(tokens.AccessWorkoutsRead, app.Middleware.RequireUser(app.WorkoutHandler.HandleGetWorkoutByID)))
+		r.Post("/workouts", app.Middleware.RequireScope(tokens.AccessWorkoutsWrite, app.Middleware.RequireActivatedUser(app.WorkoutHandler.HandleCreateWorkout)))
+		r.Put("/workouts/{id}", app.Middleware.RequireScope(tokens.AccessWorkoutsWrite, app.Middleware.RequireActivatedUser(app.WorkoutHandler.HandleUpdateWorkoutByID)))
+		r.Delete("/workouts/{id}", app.Middleware.RequireScope(tokens.AccessWorkoutsWrite, app.Middleware.RequireActivatedUser(app.WorkoutHandler.HandleDeleteWorkoutByID)))
+		r.Post("/workouts/{id}/save-as-template", app.Middleware.RequireActivatedUser(app.TemplateHandler.HandleSaveWorkoutAsTemplate))
+
+		r.Get("/templates", app.Middleware.RequireUser(app.TemplateHandler.HandleListTemplates))
+		r.Post("/templates", app.Middleware.RequireActivatedUser(app.TemplateHandler.HandleCreateTemplate))
+		r.Get("/templates/{id}", app.Middleware.RequireUser(app.TemplateHandler.HandleGetTemplateByID))
+		r.Put("/templates/{id}", app.Middleware.RequireActivatedUser(app.TemplateHandler.HandleUpdateTemplateByID))
+		r.Delete("/templates/{id}", app.Middleware.RequireActivatedUser(app.TemplateHandler.HandleDeleteTemplateByID))
+		r.Post("/templates/{id}/instantiate", app.Middleware.RequireActivatedUser(app.TemplateHandler.HandleInstantiateTemplate))
+
+		r.Post("/sessions", app.Middleware.RequireActivatedUser(app.SessionHandler.HandleStartSession))
+		r.Get("/sessions/active", app.Middleware.RequireUser(app.SessionHandler.HandleGetActiveSession))
+		r.Get("/sessions/{id}", app.Middleware.RequireUser(app.SessionHandler.HandleGetSessionByID))
+		r.Post("/sessions/{id}/sets", app.Middleware.RequireActivatedUser(app.SessionHandler.HandleLogSet))
+		r.Delete("/sessions/{id}/sets/{set_id}", app.Middleware.RequireActivatedUser(app.SessionHandler.HandleDeleteSet))
+		r.Put("/sessions/{id}/rest", app.Middleware.RequireActivatedUser(app.SessionHandler.HandleStartRest))
+		r.Delete("/sessions/{id}/rest", app.Middleware.RequireActivatedUser(app.SessionHandler.HandleStopRest))
+		r.Post("/sessions/{id}/finish", app.Middleware.RequireActivatedUser(app.SessionHandler.HandleFinishSession))
+		r.Post("/sessions/{id}/abandon", app.Middleware.RequireActivatedUser(app.SessionHandler.HandleAbandonSession))
+
+		r.Get("/events", app.Middleware.RequireUser(app.EventHandler.HandleStreamEvents))
+		r.Get("/events/ws", app.Middleware.RequireUser(app.EventHandler.HandleStreamEventsWebSocket))
+
+		r.Get("/programs", app.Middleware.RequireUser(app.ProgramHandler.HandleListPrograms))
+		r.Post("/programs", app.Middleware.RequireActivatedUser(app.ProgramHandler.HandleCreateProgram))
+		r.Get("/programs/{id}", app.Middleware.RequireUser(app.ProgramHandler.HandleGetProgramByID))
+		r.Delete("/programs/{id}", app.Middleware.RequireActivatedUser(app.ProgramHandler.HandleDeleteProgramByID))
+		r.Post("/programs/{id}/enroll", app.Middleware.RequireActivatedUser(app.ProgramHandler.HandleEnroll))
+		r.Delete("/enrollments/{id}", app.Middleware.RequireActivatedUser(app.ProgramHandler.HandleCancelEnrollment))
+		r.Post("/enrollments/{id}/days/{day_id}/workouts", app.Middleware.RequireActivatedUser(app.ProgramHandler.HandleStartProgramDay))
+
+		r.Get("/exercises", app.Middleware.RequireScope(tokens.AccessWorkoutsRead, app.Middleware.RequireUser(app.ExerciseHandler.HandleSearchExercises)))
+		r.Get("/exercises/{id}", app.Middleware.RequireScope(tokens.AccessWorkoutsRead, app.Middleware.RequireUser(app.ExerciseHandler.HandleGetExerciseByID)))
+		r.Post("/exercises", app.Middleware.RequireActivatedUser(app.ExerciseHandler.HandleCreateExercise))
+		r.Get("/exercises/{id}/records", app.Middleware.RequireScope(tokens.AccessStatsRead, app.Middleware.RequireUser(app.RecordHandler.HandleGetExerciseRecords)))
+
+		r.Get("/users/me", app.Middleware.RequireScope(tokens.AccessProfileRead, app.Middleware.RequireUser(app.UserHandler.HandleGetMe)))
+		r.Patch("/users/me", app.Middleware.RequireUser(app.UserHandler.HandleUpdateMe))
+		r.Put("/users/me/password", app.Middleware.RequireUser(app.UserHandler.HandleChangePassword))
+		r.Post("/users/me/2fa", app.Middleware.RequireUser(app.MFAHandler.HandleEnrollTOTP))
+		r.Post("/users/me/2fa/confirm", app.Middleware.RequireUser(app.MFAHandler.HandleConfirmTOTP))
+		r.Delete("/users/me/2fa", app.Middleware.RequireUser(app.MFAHandler.HandleDisableTOTP))
+		r.Post("/users/me/2fa/recovery-codes", app.Middleware.RequireUser(app.MFAHandler.HandleRegenerateRecoveryCodes))
+		r.Post("/tokens/activation", app.Middleware.RequireUser(app.TokenHander.HandleCreateActivationToken))
+		r.Get("/tokens", app.Middleware.RequireUser(app.TokenHander.HandleListTokens))
+		r.Delete("/tokens/current", app.Middleware.RequireUser(app.TokenHander.HandleDeleteCurrentToken))
+		r.Delete("/tokens/{id}", app.Middleware.RequireUser(app.TokenHander.HandleDeleteToken))
+		r.Get("/tokens/personal", app.Middleware.RequireUser(app.TokenHander.HandleListPersonalAccessTokens))
+		r.Post("/tokens/personal", app.Middleware.RequireActivatedUser(app.TokenHander.HandleCreatePersonalAccessToken))
+		r.Delete("/tokens/personal/{id}", app.Middleware.RequireUser(app.TokenHander.HandleDeletePersonalAccessToken))
+		r.Get("/users/me/records", app.Middleware.RequireScope(tokens.AccessStatsRead, app.Middleware.RequireUser(app.RecordHandler.HandleGetMyRecords)))
+		r.Get("/users/me/stats", app.Middleware.RequireScope(tokens.AccessStatsRead, app.Middleware.RequireUser(app.StatsHandler.HandleGetMyStats)))
+		r.Get("/users/me/enrollments", app.Middleware.RequireUser(app.ProgramHandler.HandleListMyEnrollments))
+		r.Get("/users/me/schedule", app.Middleware.RequireUser(app.ProgramHandler.HandleGetMySchedule))
+
+		r.Get("/admin/login-attempts", app.Middleware.RequireAdmin(app.AdminHandler.HandleListLoginAttempts))
 	})
 
-	r.Get("/health", app.HealthCheck)
+	r.Get("/healthz", app.HandleHealthz)
+	r.Get("/health", app.HandleHealthz)
+	r.Get("/readyz", app.HandleReadyz)
+	r.Get("/version", app.HandleVersion)
 
 	r.Post("/users", app.UserHandler.HandleRegisterUser)
+	r.Get("/users/{username}", app.UserHandler.HandleGetUserByUsername)
+	r.Put("/users/password", app.UserHandler.HandleResetPassword)
+	r.Put("/users/activated", app.UserHandler.HandleActivateUser)
 
 	r.Post("/tokens/authentication", app.TokenHander.HandleCreateToken)
+	r.Post("/tokens/authentication/mfa", app.TokenHander.HandleCreateMFAToken)
+	r.Post("/tokens/refresh", app.TokenHander.HandleRefreshToken)
+	r.Post("/tokens/password-reset", app.TokenHander.HandleCreatePasswordResetToken)
 	return r
 }
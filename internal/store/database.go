@@ -1,35 +1,39 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io/fs"
+	"path"
+	"strings"
+	"unicode/utf8"
 
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/config"
+	"github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/pressly/goose/v3"
 )
 
-func Open() (*sql.DB, error) {
-	db, err := sql.Open("pgx", "host=localhost user=postgres password=postgres dbname=postgres port=5432 sslmode=disable")
+func Open(cfg config.DBConfig) (*sql.DB, error) {
+	db, err := sql.Open("pgx", cfg.DSN)
 
 	if err != nil {
 		return nil, fmt.Errorf("db: open %w\n", err)
 	}
-	fmt.Println("Connected to Database...")
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("db: ping %w", err)
 	}
 	return db, nil
 }
 
-func MigrateFS(db *sql.DB, migrationsFS fs.FS, dir string) error {
-	goose.SetBaseFS(migrationsFS)
-	defer func() {
-		goose.SetBaseFS(nil)
-	}()
-	return Migrate(db, dir)
-}
-
 func Migrate(db *sql.DB, dir string) error {
 	err := goose.SetDialect("postgres")
 	if err != nil {
@@ -41,3 +45,74 @@ func Migrate(db *sql.DB, dir string) error {
 	}
 	return nil
 }
+
+// MigrationVersion returns the version of the latest migration applied to
+// db, without creating goose's version table the way goose itself would.
+func MigrationVersion(ctx context.Context, db *sql.DB) (int64, error) {
+	var version int64
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(max(version_id), 0) FROM goose_db_version WHERE is_applied`).Scan(&version)
+	return version, err
+}
+
+// LatestMigrationVersion returns the highest version among the .sql
+// migrations in dir of migrationsFS.
+func LatestMigrationVersion(migrationsFS fs.FS, dir string) (int64, error) {
+	files, err := fs.Glob(migrationsFS, path.Join(dir, "*.sql"))
+	if err != nil {
+		return 0, err
+	}
+	var latest int64
+	for _, file := range files {
+		version, err := goose.NumericComponent(file)
+		if err != nil {
+			return 0, fmt.Errorf("migration %s: %w", file, err)
+		}
+		latest = max(latest, version)
+	}
+	return latest, nil
+}
+
+// maxUserAgentLength caps the user agents kept with tokens and sign in
+// attempts, in bytes.
+const maxUserAgentLength = 255
+
+// truncateText makes s valid UTF-8, which postgres insists on for TEXT,
+// and cuts it down to at most n bytes without splitting a character.
+func truncateText(s string, n int) string {
+	s = strings.ToValidUTF8(s, "\uFFFD")
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// likeEscaper escapes the LIKE wildcards, and the escape character itself,
+// so user input matches literally in a pattern with ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// isUniqueViolation reports whether err is postgres rejecting a duplicate
+// value for a UNIQUE constraint (SQLSTATE 23505).
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// uniqueViolationConstraint returns the name of the UNIQUE constraint err
+// violated, or "" when err is not a unique violation.
+func uniqueViolationConstraint(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return pgErr.ConstraintName
+	}
+	return ""
+}
+
+// isForeignKeyViolation reports whether err is postgres refusing a change that
+// would break a FOREIGN KEY constraint (SQLSTATE 23503).
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
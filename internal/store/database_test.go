@@ -0,0 +1,46 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/migrations"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatestMigrationVersion(t *testing.T) {
+	fsys := fstest.MapFS{
+		"00001_users.sql":    {},
+		"0012_programs.sql":  {},
+		"0004_tokens.sql":    {},
+		"fs.go":              {},
+		"notes/0099_old.sql": {},
+	}
+	version, err := LatestMigrationVersion(fsys, ".")
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), version)
+
+	_, err = LatestMigrationVersion(fstest.MapFS{"users.sql": {}}, ".")
+	assert.Error(t, err)
+
+	version, err = LatestMigrationVersion(migrations.FS, ".")
+	require.NoError(t, err)
+	assert.Positive(t, version, "the embedded migrations are found")
+}
+
+func TestTruncateText(t *testing.T) {
+	assert.Equal(t, "curl/8.0", truncateText("curl/8.0", 255))
+	assert.Equal(t, "caf", truncateText("café", 4), "é is not split")
+	assert.Equal(t, "café", truncateText("café", 5))
+	assert.Equal(t, "a�b", truncateText("a\xffb", 255), "invalid bytes are replaced")
+	assert.Equal(t, strings.Repeat("日", 85), truncateText(strings.Repeat("日", 100), 255))
+}
+
+func TestLikeEscaper(t *testing.T) {
+	assert.Equal(t, "push day", likeEscaper.Replace("push day"))
+	assert.Equal(t, `100\% effort`, likeEscaper.Replace("100% effort"))
+	assert.Equal(t, `leg\_day`, likeEscaper.Replace("leg_day"))
+	assert.Equal(t, `a\\b`, likeEscaper.Replace(`a\b`))
+}
@@ -0,0 +1,347 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgtype"
+)
+
+const (
+	MetricTypeReps     = "reps"
+	MetricTypeDuration = "duration"
+)
+
+var (
+	ErrDuplicateExercise = errors.New("an exercise with this name already exists")
+	ErrUnknownExercise   = errors.New("unknown exercise")
+)
+
+type Exercise struct {
+	ID               int       `json:"id"`
+	UserID           *int      `json:"user_id"`
+	Name             string    `json:"name"`
+	Aliases          []string  `json:"aliases"`
+	PrimaryMuscles   []string  `json:"primary_muscles"`
+	SecondaryMuscles []string  `json:"secondary_muscles"`
+	Equipment        string    `json:"equipment"`
+	MovementPattern  string    `json:"movement_pattern"`
+	MetricType       string    `json:"metric_type"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// IsCustom reports whether the exercise was defined by a user rather than
+// coming from the shared catalog.
+func (e *Exercise) IsCustom() bool {
+	return e.UserID != nil
+}
+
+const (
+	DefaultExercisePageSize = 50
+	MaxExercisePageSize     = 100
+)
+
+type ExerciseFilter struct {
+	UserID    int
+	Query     string
+	Muscle    string
+	Equipment string
+	Limit     int
+}
+
+// NormalizeExerciseName folds the different spellings of an exercise name
+// into one key, so "Bench Press", "bench-press" and " BENCH  PRESS" match.
+func NormalizeExerciseName(name string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		default:
+			space = true
+		}
+	}
+	return b.String()
+}
+
+func normalizeAliases(aliases []string) []string {
+	normalized := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		if n := NormalizeExerciseName(alias); n != "" {
+			normalized = append(normalized, n)
+		}
+	}
+	return normalized
+}
+
+type PostgresExerciseStore struct {
+	db *sql.DB
+}
+
+func NewPostgresExerciseStore(db *sql.DB) *PostgresExerciseStore {
+	return &PostgresExerciseStore{db: db}
+}
+
+type ExerciseStore interface {
+	SearchExercises(filter ExerciseFilter) ([]*Exercise, error)
+	GetExerciseByID(id int64, userID int) (*Exercise, error)
+	CreateCustomExercise(*Exercise) error
+	ResolveExercise(userID int, name string) (*Exercise, error)
+}
+
+const exerciseColumns = `id, user_id, name, aliases, primary_muscles, secondary_muscles, equipment, movement_pattern, metric_type, created_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanExercise(row rowScanner) (*Exercise, error) {
+	exercise := &Exercise{}
+	var userID sql.NullInt64
+	var aliases, primary, secondary pgtype.TextArray
+	err := row.Scan(
+		&exercise.ID,
+		&userID,
+		&exercise.Name,
+		&aliases,
+		&primary,
+		&secondary,
+		&exercise.Equipment,
+		&exercise.MovementPattern,
+		&exercise.MetricType,
+		&exercise.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if userID.Valid {
+		id := int(userID.Int64)
+		exercise.UserID = &id
+	}
+	for _, arr := range []struct {
+		src *pgtype.TextArray
+		dst *[]string
+	}{{&aliases, &exercise.Aliases}, {&primary, &exercise.PrimaryMuscles}, {&secondary, &exercise.SecondaryMuscles}} {
+		*arr.dst = []string{}
+		if err := arr.src.AssignTo(arr.dst); err != nil {
+			return nil, err
+		}
+	}
+	return exercise, nil
+}
+
+func (pg *PostgresExerciseStore) SearchExercises(filter ExerciseFilter) ([]*Exercise, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultExercisePageSize
+	}
+	if limit > MaxExercisePageSize {
+		limit = MaxExercisePageSize
+	}
+
+	conditions := []string{"(user_id IS NULL OR user_id = $1)"}
+	args := []any{filter.UserID}
+	if filter.Query != "" {
+		args = append(args, NormalizeExerciseName(filter.Query))
+		conditions = append(conditions, fmt.Sprintf(
+			"(normalized_name LIKE '%%' || $%d || '%%' OR EXISTS (SELECT 1 FROM unnest(aliases) a WHERE a LIKE '%%' || $%d || '%%'))",
+			len(args), len(args)))
+	}
+	if filter.Muscle != "" {
+		args = append(args, strings.ToLower(filter.Muscle))
+		conditions = append(conditions, fmt.Sprintf("($%d = ANY(primary_muscles) OR $%d = ANY(secondary_muscles))", len(args), len(args)))
+	}
+	if filter.Equipment != "" {
+		args = append(args, strings.ToLower(filter.Equipment))
+		conditions = append(conditions, fmt.Sprintf("equipment = $%d", len(args)))
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM exercises
+	WHERE %s
+	ORDER BY user_id IS NULL, name
+	LIMIT $%d
+	`, exerciseColumns, strings.Join(conditions, " AND "), len(args))
+
+	rows, err := pg.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exercises := []*Exercise{}
+	for rows.Next() {
+		exercise, err := scanExercise(rows)
+		if err != nil {
+			return nil, err
+		}
+		exercises = append(exercises, exercise)
+	}
+	return exercises, rows.Err()
+}
+
+// GetExerciseByID returns a catalog exercise or one of userID's custom
+// exercises; other users' custom exercises are reported as not found.
+func (pg *PostgresExerciseStore) GetExerciseByID(id int64, userID int) (*Exercise, error) {
+	query := `
+	SELECT ` + exerciseColumns + `
+	FROM exercises
+	WHERE id = $1 AND (user_id IS NULL OR user_id = $2)
+	`
+	exercise, err := scanExercise(pg.db.QueryRow(query, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return exercise, err
+}
+
+func (pg *PostgresExerciseStore) CreateCustomExercise(exercise *Exercise) error {
+	if exercise.MetricType == "" {
+		exercise.MetricType = MetricTypeReps
+	}
+	if exercise.Equipment == "" {
+		exercise.Equipment = "none"
+	}
+	if exercise.MovementPattern == "" {
+		exercise.MovementPattern = "other"
+	}
+	for _, list := range []*[]string{&exercise.Aliases, &exercise.PrimaryMuscles, &exercise.SecondaryMuscles} {
+		if *list == nil {
+			*list = []string{}
+		}
+	}
+	query := `
+	INSERT INTO exercises (user_id, name, normalized_name, aliases, primary_muscles, secondary_muscles, equipment, movement_pattern, metric_type)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id, created_at
+	`
+	err := pg.db.QueryRow(query,
+		exercise.UserID,
+		exercise.Name,
+		NormalizeExerciseName(exercise.Name),
+		normalizeAliases(exercise.Aliases),
+		exercise.PrimaryMuscles,
+		exercise.SecondaryMuscles,
+		exercise.Equipment,
+		exercise.MovementPattern,
+		exercise.MetricType,
+	).Scan(&exercise.ID, &exercise.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrDuplicateExercise
+	}
+	return err
+}
+
+func (pg *PostgresExerciseStore) ResolveExercise(userID int, name string) (*Exercise, error) {
+	return resolveExercise(pg.db, userID, name)
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// resolveExercise maps a free-text exercise name onto the catalog, looking at
+// the user's own exercises before the shared ones and at names before aliases.
+// It returns nil when nothing matches.
+func resolveExercise(q queryRower, userID int, name string) (*Exercise, error) {
+	normalized := NormalizeExerciseName(name)
+	if normalized == "" {
+		return nil, nil
+	}
+	query := `
+	SELECT ` + exerciseColumns + `
+	FROM exercises
+	WHERE (user_id IS NULL OR user_id = $1)
+		AND (normalized_name = $2 OR $2 = ANY(aliases))
+	ORDER BY user_id IS NULL, normalized_name = $2 DESC
+	LIMIT 1
+	`
+	exercise, err := scanExercise(q.QueryRow(query, userID, normalized))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return exercise, err
+}
+
+// SeedExercises upserts the shared exercise catalog from exercises.json in
+// seedFS. It is safe to run on every start.
+func SeedExercises(db *sql.DB, seedFS fs.FS) error {
+	data, err := fs.ReadFile(seedFS, "exercises.json")
+	if err != nil {
+		return fmt.Errorf("seed exercises: %w", err)
+	}
+
+	var exercises []Exercise
+	if err := json.Unmarshal(data, &exercises); err != nil {
+		return fmt.Errorf("seed exercises: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO exercises (name, normalized_name, aliases, primary_muscles, secondary_muscles, equipment, movement_pattern, metric_type)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (normalized_name) WHERE user_id IS NULL DO UPDATE
+	SET name = EXCLUDED.name, aliases = EXCLUDED.aliases, primary_muscles = EXCLUDED.primary_muscles,
+		secondary_muscles = EXCLUDED.secondary_muscles, equipment = EXCLUDED.equipment,
+		movement_pattern = EXCLUDED.movement_pattern, metric_type = EXCLUDED.metric_type
+	`
+	for _, e := range exercises {
+		_, err := tx.Exec(query, e.Name, NormalizeExerciseName(e.Name), normalizeAliases(e.Aliases),
+			e.PrimaryMuscles, e.SecondaryMuscles, e.Equipment, e.MovementPattern, e.MetricType)
+		if err != nil {
+			return fmt.Errorf("seed exercise %q: %w", e.Name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// resolveEntryExercises links every entry to the catalog inside tx: entries
+// sent with an exercise_id are checked and get the canonical name when they
+// have none, entries sent with only a name are matched by name or alias.
+// Names that match nothing are kept as free text.
+func resolveEntryExercises(tx *sql.Tx, userID int, entries []WorkoutEntry) error {
+	for i := range entries {
+		entry := &entries[i]
+		if entry.ExerciseID != nil {
+			var name string
+			err := tx.QueryRow(`SELECT name FROM exercises WHERE id = $1 AND (user_id IS NULL OR user_id = $2)`, *entry.ExerciseID, userID).Scan(&name)
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("%w: %d", ErrUnknownExercise, *entry.ExerciseID)
+			}
+			if err != nil {
+				return err
+			}
+			if strings.TrimSpace(entry.ExerciseName) == "" {
+				entry.ExerciseName = name
+			}
+			continue
+		}
+
+		exercise, err := resolveExercise(tx, userID, entry.ExerciseName)
+		if err != nil {
+			return err
+		}
+		if exercise != nil {
+			entry.ExerciseID = &exercise.ID
+		}
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeExerciseName(t *testing.T) {
+	for _, name := range []string{"Bench Press", "bench press", "  BENCH-PRESS ", "bench_press"} {
+		assert.Equal(t, "bench press", NormalizeExerciseName(name), name)
+	}
+	assert.Equal(t, "", NormalizeExerciseName(" -- "))
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Reasons recorded with a login attempt.
+const (
+	LoginSucceeded   = "succeeded"
+	LoginUnknownUser = "unknown_user"
+	LoginBadPassword = "bad_password"
+	LoginBadMFACode  = "bad_mfa_code"
+	// LoginMFARequired marks a correct password still waiting for the
+	// second factor. It is neither a success nor a failure for throttling.
+	LoginMFARequired = "mfa_required"
+	// LoginThrottled attempts were turned away without checking the
+	// password and do not extend the lockout.
+	LoginThrottled = "throttled"
+	// LoginPending attempts are still being checked and count as failures
+	// until they are finished.
+	LoginPending = "pending"
+	// LoginAborted attempts ended before the credentials were judged, on a
+	// server error or a challenge gone stale. They are not failures either.
+	LoginAborted = "aborted"
+)
+
+// advisory lock classes serialising the sign in attempts on one username
+// and from one IP address
+const (
+	loginUsernameLock = 1
+	loginIPLock       = 2
+)
+
+type LoginAttempt struct {
+	ID          int64     `json:"id"`
+	Username    string    `json:"username"`
+	UserID      *int      `json:"user_id"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	Success     bool      `json:"success"`
+	Reason      string    `json:"reason"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+// LoginFailures counts recent failed attempts on a username, since its last
+// successful sign in, and from an IP address.
+type LoginFailures struct {
+	Username     int
+	LastUsername time.Time
+	IP           int
+	LastIP       time.Time
+}
+
+// MaxLoginAttemptPageSize caps how many attempts ListLoginAttempts returns.
+const MaxLoginAttemptPageSize = 200
+
+// LoginAttemptFilter selects the attempts an admin looks at; zero values
+// match everything. Before pages back through older attempts.
+type LoginAttemptFilter struct {
+	Username   string
+	IP         string
+	FailedOnly bool
+	Before     *time.Time
+	Limit      int
+}
+
+type PostgresLoginAttemptStore struct {
+	db *sql.DB
+}
+
+func NewPostgresLoginAttemptStore(db *sql.DB) *PostgresLoginAttemptStore {
+	return &PostgresLoginAttemptStore{db: db}
+}
+
+type LoginAttemptStore interface {
+	StartLoginAttempt(attempt *LoginAttempt, since time.Time) (*LoginFailures, error)
+	FinishLoginAttempt(attempt *LoginAttempt) error
+	ListLoginAttempts(filter LoginAttemptFilter) ([]*LoginAttempt, error)
+	DeleteLoginAttemptsBefore(before time.Time) (int64, error)
+}
+
+// StartLoginAttempt counts the failures on the attempt's username and IP
+// address since the given time, then records the attempt as pending, filling
+// in its ID. Attempts on the same username or from the same address are
+// serialised, and pending attempts count as failures, so parallel guesses
+// cannot all pass the throttle on the same count. FinishLoginAttempt
+// records the outcome.
+func (pg *PostgresLoginAttemptStore) StartLoginAttempt(attempt *LoginAttempt, since time.Time) (*LoginFailures, error) {
+	userAgent := attempt.UserAgent
+	userAgent = truncateText(userAgent, maxUserAgentLength)
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// always username first, then IP, so two attempts cannot deadlock
+	_, err = tx.Exec(`SELECT pg_advisory_xact_lock($1, hashtext($2))`, loginUsernameLock, attempt.Username)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`SELECT pg_advisory_xact_lock($1, hashtext($2))`, loginIPLock, attempt.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	failures, err := countLoginFailures(tx, attempt.Username, attempt.IP, since)
+	if err != nil {
+		return nil, err
+	}
+
+	attempt.Success = false
+	attempt.Reason = LoginPending
+	query := `
+	INSERT INTO login_attempts (username, user_id, ip, user_agent, success, reason)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, attempted_at
+	`
+	err = tx.QueryRow(query, attempt.Username, attempt.UserID, attempt.IP, userAgent, attempt.Success, attempt.Reason).Scan(&attempt.ID, &attempt.AttemptedAt)
+	if err != nil {
+		return nil, err
+	}
+	return failures, tx.Commit()
+}
+
+// FinishLoginAttempt records the user, success and reason of an attempt
+// started with StartLoginAttempt.
+func (pg *PostgresLoginAttemptStore) FinishLoginAttempt(attempt *LoginAttempt) error {
+	query := `
+	UPDATE login_attempts
+	SET user_id = $2, success = $3, reason = $4
+	WHERE id = $1
+	`
+	_, err := pg.db.Exec(query, attempt.ID, attempt.UserID, attempt.Success, attempt.Reason)
+	return err
+}
+
+// countLoginFailures counts the failures since the given time. A successful
+// sign in resets the count of its username but not of the IP address, or an
+// attacker could reset it by signing in to an account of their own.
+func countLoginFailures(q queryRower, username, ip string, since time.Time) (*LoginFailures, error) {
+	query := `
+	SELECT
+		count(*) FILTER (WHERE username = $1 AND attempted_at > COALESCE(
+			(SELECT max(attempted_at) FROM login_attempts WHERE username = $1 AND success), '-infinity')),
+		max(attempted_at) FILTER (WHERE username = $1),
+		count(*) FILTER (WHERE ip = $2),
+		max(attempted_at) FILTER (WHERE ip = $2)
+	FROM login_attempts
+	WHERE (username = $1 OR ip = $2)
+		AND attempted_at > $3
+		AND NOT success
+		AND reason NOT IN ($4, $5, $6)
+	`
+	f := &LoginFailures{}
+	var lastUsername, lastIP sql.NullTime
+	err := q.QueryRow(query, username, ip, since, LoginMFARequired, LoginThrottled, LoginAborted).Scan(&f.Username, &lastUsername, &f.IP, &lastIP)
+	if err != nil {
+		return nil, err
+	}
+	f.LastUsername = lastUsername.Time
+	f.LastIP = lastIP.Time
+	return f, nil
+}
+
+// ListLoginAttempts returns the attempts matching filter, newest first.
+func (pg *PostgresLoginAttemptStore) ListLoginAttempts(filter LoginAttemptFilter) ([]*LoginAttempt, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > MaxLoginAttemptPageSize {
+		limit = MaxLoginAttemptPageSize
+	}
+
+	query := `
+	SELECT id, username, user_id, ip, user_agent, success, reason, attempted_at
+	FROM login_attempts
+	WHERE ($1 = '' OR username = $1)
+		AND ($2 = '' OR ip = $2)
+		AND (NOT $3 OR NOT success)
+		AND ($4::timestamptz IS NULL OR attempted_at < $4)
+	ORDER BY attempted_at DESC, id DESC
+	LIMIT $5
+	`
+	rows, err := pg.db.Query(query, filter.Username, filter.IP, filter.FailedOnly, filter.Before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []*LoginAttempt{}
+	for rows.Next() {
+		attempt := &LoginAttempt{}
+		err := rows.Scan(&attempt.ID, &attempt.Username, &attempt.UserID, &attempt.IP, &attempt.UserAgent, &attempt.Success, &attempt.Reason, &attempt.AttemptedAt)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+// DeleteLoginAttemptsBefore deletes the attempts made before the given time
+// and returns how many there were.
+func (pg *PostgresLoginAttemptStore) DeleteLoginAttemptsBefore(before time.Time) (int64, error) {
+	result, err := pg.db.Exec(`DELETE FROM login_attempts WHERE attempted_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartLoginAttempt(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	attemptStore := NewPostgresLoginAttemptStore(db)
+	since := time.Now().Add(-time.Hour)
+
+	t.Run("parallel attempts see each other", func(t *testing.T) {
+		const n = 8
+		counts := make([]int, n)
+		var wg sync.WaitGroup
+		for i := range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				failures, err := attemptStore.StartLoginAttempt(&LoginAttempt{Username: "parallel", IP: "192.0.2.1"}, since)
+				assert.NoError(t, err)
+				if failures != nil {
+					counts[i] = failures.Username
+				}
+			}()
+		}
+		wg.Wait()
+		assert.ElementsMatch(t, []int{0, 1, 2, 3, 4, 5, 6, 7}, counts, "every pending attempt counts for the next")
+	})
+
+	t.Run("finished attempts", func(t *testing.T) {
+		attempt := &LoginAttempt{Username: "finishing", IP: "192.0.2.2"}
+		_, err := attemptStore.StartLoginAttempt(attempt, since)
+		require.NoError(t, err)
+		assert.NotZero(t, attempt.ID)
+
+		attempt.Reason = LoginMFARequired
+		require.NoError(t, attemptStore.FinishLoginAttempt(attempt))
+		failures, err := attemptStore.StartLoginAttempt(&LoginAttempt{Username: "finishing", IP: "192.0.2.2"}, since)
+		require.NoError(t, err)
+		assert.Equal(t, 0, failures.Username, "mfa challenges are not failures")
+
+		attempt.Reason = LoginBadPassword
+		require.NoError(t, attemptStore.FinishLoginAttempt(attempt))
+		failures, err = attemptStore.StartLoginAttempt(&LoginAttempt{Username: "finishing", IP: "192.0.2.2"}, since)
+		require.NoError(t, err)
+		assert.Equal(t, 2, failures.Username, "the bad password and the pending attempt")
+		assert.Equal(t, 2, failures.IP)
+	})
+
+	t.Run("aborted attempts", func(t *testing.T) {
+		attempt := &LoginAttempt{Username: "aborting", IP: "192.0.2.3"}
+		_, err := attemptStore.StartLoginAttempt(attempt, since)
+		require.NoError(t, err)
+
+		attempt.Reason = LoginAborted
+		require.NoError(t, attemptStore.FinishLoginAttempt(attempt))
+		failures, err := attemptStore.StartLoginAttempt(&LoginAttempt{Username: "aborting", IP: "192.0.2.3"}, since)
+		require.NoError(t, err)
+		assert.Equal(t, 0, failures.Username, "aborted attempts are not failures")
+		assert.Equal(t, 0, failures.IP)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		attempts, err := attemptStore.ListLoginAttempts(LoginAttemptFilter{Username: "finishing"})
+		require.NoError(t, err)
+		require.Len(t, attempts, 3)
+		assert.Equal(t, LoginPending, attempts[0].Reason, "newest first")
+		assert.Equal(t, LoginBadPassword, attempts[2].Reason)
+
+		attempts, err = attemptStore.ListLoginAttempts(LoginAttemptFilter{IP: "192.0.2.1", Limit: 3})
+		require.NoError(t, err)
+		assert.Len(t, attempts, 3)
+	})
+}
+
+func TestDeleteLoginAttemptsBefore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	attemptStore := NewPostgresLoginAttemptStore(db)
+	old := &LoginAttempt{Username: "purged", IP: "192.0.2.4"}
+	_, err := attemptStore.StartLoginAttempt(old, time.Now())
+	require.NoError(t, err)
+	_, err = db.Exec(`UPDATE login_attempts SET attempted_at = $2 WHERE id = $1`, old.ID, time.Now().AddDate(0, -6, 0))
+	require.NoError(t, err)
+	_, err = attemptStore.StartLoginAttempt(&LoginAttempt{Username: "purged", IP: "192.0.2.4"}, time.Now())
+	require.NoError(t, err)
+
+	purged, err := attemptStore.DeleteLoginAttemptsBefore(time.Now().AddDate(0, -3, 0))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	attempts, err := attemptStore.ListLoginAttempts(LoginAttemptFilter{Username: "purged"})
+	require.NoError(t, err)
+	require.Len(t, attempts, 1)
+	assert.NotEqual(t, old.ID, attempts[0].ID)
+}
@@ -0,0 +1,334 @@
+package store
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+
+// RecoveryCodeCount is how many recovery codes are handed out at a time.
+const RecoveryCodeCount = 10
+
+// TOTPEnrollment is a user's authenticator app secret. It only guards sign
+// in once EnabledAt is set.
+type TOTPEnrollment struct {
+	UserID       int
+	Secret       string
+	EnabledAt    *time.Time
+	LastUsedStep int64
+}
+
+func (e *TOTPEnrollment) Enabled() bool {
+	return e != nil && e.EnabledAt != nil
+}
+
+// encryptedSecretPrefix marks the secrets sealed by encryptSecret; older
+// rows hold them in plain text until EncryptPlaintextSecrets runs.
+const encryptedSecretPrefix = "v1:"
+
+var ErrInvalidTOTPSecret = errors.New("cannot decrypt totp secret")
+
+// PostgresMFAStore keeps the authenticator app secrets encrypted with
+// AES-256-GCM, so a leaked database or backup does not give away second
+// factors along with the password hashes.
+type PostgresMFAStore struct {
+	db   *sql.DB
+	aead cipher.AEAD
+}
+
+// NewPostgresMFAStore returns a store encrypting the secrets with key, 32
+// bytes long.
+func NewPostgresMFAStore(db *sql.DB, key []byte) (*PostgresMFAStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresMFAStore{db: db, aead: aead}, nil
+}
+
+type MFAStore interface {
+	GetTOTP(userID int) (*TOTPEnrollment, error)
+	SaveTOTPSecret(userID int, secret string) error
+	EnableTOTP(userID int, step int64, recoveryCodes []string) error
+	UseTOTPStep(userID int, step int64) (bool, error)
+	UseRecoveryCode(userID int, code string) (bool, error)
+	ReplaceRecoveryCodes(userID int, recoveryCodes []string) error
+	DisableTOTP(userID int) error
+}
+
+// encryptSecret seals secret, bound to userID so a sealed secret copied to
+// another user's row does not decrypt.
+func (pg *PostgresMFAStore) encryptSecret(userID int, secret string) (string, error) {
+	nonce := make([]byte, pg.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := pg.aead.Seal(nonce, nonce, []byte(secret), []byte(strconv.Itoa(userID)))
+	return encryptedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (pg *PostgresMFAStore) decryptSecret(userID int, stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, encryptedSecretPrefix)
+	if !ok {
+		return "", ErrInvalidTOTPSecret
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < pg.aead.NonceSize() {
+		return "", ErrInvalidTOTPSecret
+	}
+	nonce, ciphertext := sealed[:pg.aead.NonceSize()], sealed[pg.aead.NonceSize():]
+	secret, err := pg.aead.Open(nil, nonce, ciphertext, []byte(strconv.Itoa(userID)))
+	if err != nil {
+		return "", ErrInvalidTOTPSecret
+	}
+	return string(secret), nil
+}
+
+// EncryptPlaintextSecrets encrypts the secrets stored before they were
+// encrypted and returns how many it found. It is run at startup.
+func (pg *PostgresMFAStore) EncryptPlaintextSecrets() (int, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT user_id, secret FROM user_totp WHERE secret NOT LIKE $1 || '%' FOR UPDATE`, encryptedSecretPrefix)
+	if err != nil {
+		return 0, err
+	}
+	plaintext := map[int]string{}
+	for rows.Next() {
+		var userID int
+		var secret string
+		err = rows.Scan(&userID, &secret)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		plaintext[userID] = secret
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for userID, secret := range plaintext {
+		sealed, err := pg.encryptSecret(userID, secret)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(`UPDATE user_totp SET secret = $2 WHERE user_id = $1`, userID, sealed)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(plaintext), tx.Commit()
+}
+
+// GenerateRecoveryCodes returns RecoveryCodeCount new codes such as
+// "k3v9-q2xa". Only their hashes are stored.
+func GenerateRecoveryCodes() ([]string, error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(enc.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes so codes can be typed
+// in however they were written down.
+func hashRecoveryCode(code string) []byte {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	hash := sha256.Sum256([]byte(normalized))
+	return hash[:]
+}
+
+func (pg *PostgresMFAStore) GetTOTP(userID int) (*TOTPEnrollment, error) {
+	e := &TOTPEnrollment{UserID: userID}
+	query := `
+	SELECT secret, enabled_at, last_used_step
+	FROM user_totp
+	WHERE user_id = $1
+	`
+	var stored string
+	err := pg.db.QueryRow(query, userID).Scan(&stored, &e.EnabledAt, &e.LastUsedStep)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.Secret, err = pg.decryptSecret(userID, stored)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// SaveTOTPSecret starts (or restarts) an enrollment that still needs to be
+// confirmed with EnableTOTP.
+func (pg *PostgresMFAStore) SaveTOTPSecret(userID int, secret string) error {
+	query := `
+	INSERT INTO user_totp (user_id, secret)
+	VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE
+	SET secret = EXCLUDED.secret, last_used_step = 0, created_at = CURRENT_TIMESTAMP
+	WHERE user_totp.enabled_at IS NULL
+	`
+	sealed, err := pg.encryptSecret(userID, secret)
+	if err != nil {
+		return err
+	}
+	result, err := pg.db.Exec(query, userID, sealed)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+	return nil
+}
+
+// EnableTOTP confirms the pending enrollment with the step of its first
+// valid code and stores the user's recovery codes.
+func (pg *PostgresMFAStore) EnableTOTP(userID int, step int64, recoveryCodes []string) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE user_totp
+	SET enabled_at = $2, last_used_step = $3
+	WHERE user_id = $1 AND enabled_at IS NULL
+	`
+	result, err := tx.Exec(query, userID, time.Now(), step)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	err = replaceRecoveryCodes(tx, userID, recoveryCodes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a code of step was used and reports false when
+// that step, or a later one, was used before, ie. the code is replayed.
+func (pg *PostgresMFAStore) UseTOTPStep(userID int, step int64) (bool, error) {
+	query := `
+	UPDATE user_totp
+	SET last_used_step = $2
+	WHERE user_id = $1 AND last_used_step < $2
+	`
+	result, err := pg.db.Exec(query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode spends code and reports whether it was a valid, unused
+// recovery code of the user.
+func (pg *PostgresMFAStore) UseRecoveryCode(userID int, code string) (bool, error) {
+	query := `
+	UPDATE recovery_codes
+	SET used_at = $3
+	WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+	result, err := pg.db.Exec(query, userID, hashRecoveryCode(code), time.Now())
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+func (pg *PostgresMFAStore) ReplaceRecoveryCodes(userID int, recoveryCodes []string) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodes(tx, userID, recoveryCodes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int, recoveryCodes []string) error {
+	_, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	for _, code := range recoveryCodes {
+		_, err = tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hashRecoveryCode(code))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pg *PostgresMFAStore) DisableTOTP(userID int) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, RecoveryCodeCount)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		assert.Regexp(t, `^[a-z2-7]{4}-[a-z2-7]{4}$`, code)
+		assert.False(t, seen[code])
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	want := hashRecoveryCode("k3v9-q2xa")
+	assert.Equal(t, want, hashRecoveryCode("K3V9Q2XA"))
+	assert.Equal(t, want, hashRecoveryCode(" k3v9 q2xa "))
+	assert.NotEqual(t, want, hashRecoveryCode("k3v9-q2xb"))
+}
+
+func TestTOTPSecretEncryption(t *testing.T) {
+	mfaStore, err := NewPostgresMFAStore(nil, bytes.Repeat([]byte{7}, 32))
+	require.NoError(t, err)
+
+	sealed, err := mfaStore.encryptSecret(1, "JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	assert.NotContains(t, sealed, "JBSWY3DPEHPK3PXP")
+
+	secret, err := mfaStore.decryptSecret(1, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", secret)
+
+	_, err = mfaStore.decryptSecret(2, sealed)
+	assert.ErrorIs(t, err, ErrInvalidTOTPSecret, "secrets are bound to their user")
+	_, err = mfaStore.decryptSecret(1, "JBSWY3DPEHPK3PXP")
+	assert.ErrorIs(t, err, ErrInvalidTOTPSecret)
+
+	otherKey, err := NewPostgresMFAStore(nil, bytes.Repeat([]byte{8}, 32))
+	require.NoError(t, err)
+	_, err = otherKey.decryptSecret(1, sealed)
+	assert.ErrorIs(t, err, ErrInvalidTOTPSecret)
+
+	_, err = NewPostgresMFAStore(nil, []byte("short"))
+	assert.Error(t, err)
+}
+
+func TestEncryptPlaintextSecrets(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db, "legacy")
+	mfaStore, err := NewPostgresMFAStore(db, bytes.Repeat([]byte{7}, 32))
+	require.NoError(t, err)
+
+	_, err = db.Exec(`INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)`, user.ID, "JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	_, err = mfaStore.GetTOTP(user.ID)
+	assert.ErrorIs(t, err, ErrInvalidTOTPSecret)
+
+	n, err := mfaStore.EncryptPlaintextSecrets()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = mfaStore.EncryptPlaintextSecrets()
+	require.NoError(t, err)
+	assert.Equal(t, 0, n, "encrypted secrets are left alone")
+
+	var stored string
+	require.NoError(t, db.QueryRow(`SELECT secret FROM user_totp WHERE user_id = $1`, user.ID).Scan(&stored))
+	assert.NotEqual(t, "JBSWY3DPEHPK3PXP", stored)
+
+	enrollment, err := mfaStore.GetTOTP(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", enrollment.Secret)
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+// Migrator applies and rolls back the migrations in an embedded filesystem.
+// Every operation holds a postgres advisory lock for its duration, so
+// replicas starting at the same time apply each migration once.
+type Migrator struct {
+	provider *goose.Provider
+}
+
+// NewMigrator returns a Migrator for the .sql migrations at the root of
+// migrationsFS.
+func NewMigrator(db *sql.DB, migrationsFS fs.FS) (*Migrator, error) {
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, migrationsFS, goose.WithSessionLocker(locker))
+	if err != nil {
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	// the provider is not closed: that would close db, which belongs to the caller
+	return &Migrator{provider: provider}, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) ([]*goose.MigrationResult, error) {
+	results, err := m.provider.Up(ctx)
+	if err != nil {
+		return results, fmt.Errorf("migrate up: %w", err)
+	}
+	return results, nil
+}
+
+// Down rolls back the latest applied migration.
+func (m *Migrator) Down(ctx context.Context) (*goose.MigrationResult, error) {
+	result, err := m.provider.Down(ctx)
+	if err != nil {
+		return result, fmt.Errorf("migrate down: %w", err)
+	}
+	return result, nil
+}
+
+// Redo rolls back the latest applied migration and applies it again.
+func (m *Migrator) Redo(ctx context.Context) ([]*goose.MigrationResult, error) {
+	down, err := m.Down(ctx)
+	if err != nil {
+		return nil, err
+	}
+	up, err := m.provider.UpByOne(ctx)
+	if err != nil {
+		return []*goose.MigrationResult{down}, fmt.Errorf("migrate redo: %w", err)
+	}
+	return []*goose.MigrationResult{down, up}, nil
+}
+
+// Status lists every migration, applied or pending, in version order.
+func (m *Migrator) Status(ctx context.Context) ([]*goose.MigrationStatus, error) {
+	statuses, err := m.provider.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("migrate status: %w", err)
+	}
+	return statuses, nil
+}
+
+// Version returns the version of the latest applied migration.
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	version, err := m.provider.GetDBVersion(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("migrate version: %w", err)
+	}
+	return version, nil
+}
+
+var migrationNameSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+const migrationTemplate = `-- +goose Up
+-- +goose StatementBegin
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+
+-- +goose StatementEnd
+`
+
+// CreateMigration writes an empty migration named after name to dir,
+// numbered after the latest one already there, and returns its path.
+func CreateMigration(dir, name string) (string, error) {
+	name = strings.Trim(migrationNameSeparators.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return "", fmt.Errorf("migrate create: a name is required")
+	}
+
+	latest, err := LatestMigrationVersion(os.DirFS(dir), ".")
+	if err != nil {
+		return "", fmt.Errorf("migrate create: %w", err)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%04d_%s.sql", latest+1, name))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("migrate create: %w", err)
+	}
+	_, err = f.WriteString(migrationTemplate)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("migrate create: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pressly/goose/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateMigration(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0007_users.sql"), nil, 0o644))
+
+	path, err := CreateMigration(dir, "Add workout Notes!")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "0008_add_workout_notes.sql"), path)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "-- +goose Up")
+	assert.Contains(t, string(content), "-- +goose Down")
+
+	path, err = CreateMigration(dir, "next")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "0009_next.sql"), path)
+
+	_, err = CreateMigration(dir, " -- ")
+	assert.Error(t, err)
+}
+
+func TestUserActivationBackfill(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// back to before 0015_user_activation, with an account signed up then
+	dir := "../../migrations/"
+	require.NoError(t, goose.DownTo(db, dir, 14))
+	defer func() {
+		require.NoError(t, goose.Up(db, dir))
+	}()
+	_, err := db.Exec(`INSERT INTO users (username, email, password_hash) VALUES ('early', 'early@example.com', 'x')`)
+	require.NoError(t, err)
+
+	require.NoError(t, goose.UpTo(db, dir, 15))
+
+	var activated bool
+	var verifiedAt sql.NullTime
+	err = db.QueryRow(`SELECT activated, email_verified_at FROM users WHERE username = 'early'`).Scan(&activated, &verifiedAt)
+	require.NoError(t, err)
+	assert.True(t, activated, "existing accounts keep working")
+	assert.False(t, verifiedAt.Valid, "their email stays unverified")
+
+	_, err = db.Exec(`INSERT INTO users (username, email, password_hash) VALUES ('late', 'late@example.com', 'x')`)
+	require.NoError(t, err)
+	err = db.QueryRow(`SELECT activated FROM users WHERE username = 'late'`).Scan(&activated)
+	require.NoError(t, err)
+	assert.False(t, activated, "new accounts start out inactive")
+}
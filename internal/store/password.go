@@ -0,0 +1,228 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PasswordArgon2id = "argon2id"
+	PasswordBcrypt   = "bcrypt"
+)
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+// MaxPasswordLength caps passwords, in bytes. bcrypt, which can still be
+// configured in place of argon2id, refuses anything longer, and with the
+// cap a client cannot make the server hash arbitrarily long input.
+const MaxPasswordLength = 72
+
+// Argon2Params tune argon2id; Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Time        uint32
+	Parallelism uint8
+}
+
+// PasswordHashing picks how new passwords are hashed. Hashes made with
+// another algorithm or other parameters keep working and are reported by
+// NeedsRehash so they can be upgraded when the user next signs in.
+type PasswordHashing struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+	// MaxConcurrent bounds how many argon2id hashes are computed at once,
+	// 0 meaning one per CPU. Each holds Argon2.Memory for its duration, so
+	// a burst of sign ins would otherwise take as much memory as it likes.
+	MaxConcurrent int
+}
+
+// DefaultPasswordHashing follows the OWASP recommendation for argon2id.
+var DefaultPasswordHashing = PasswordHashing{
+	Algorithm:  PasswordArgon2id,
+	Argon2:     Argon2Params{Memory: 64 * 1024, Time: 3, Parallelism: 2},
+	BcryptCost: 12,
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var passwordHashing = DefaultPasswordHashing
+
+// argon2Slots holds a value for every argon2id hash being computed.
+var argon2Slots = make(chan struct{}, runtime.NumCPU())
+
+// SetPasswordHashing configures the hashing of new passwords. It is meant to
+// be called once at startup, before any password is set.
+func SetPasswordHashing(h PasswordHashing) error {
+	switch h.Algorithm {
+	case PasswordArgon2id:
+		if h.Argon2.Memory < 8*uint32(h.Argon2.Parallelism) || h.Argon2.Time < 1 || h.Argon2.Parallelism < 1 {
+			return fmt.Errorf("argon2id: need time >= 1, parallelism >= 1 and memory >= 8 KiB per thread")
+		}
+	case PasswordBcrypt:
+		if h.BcryptCost < bcrypt.MinCost || h.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt: cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return fmt.Errorf("unknown password hashing algorithm %q", h.Algorithm)
+	}
+	if h.MaxConcurrent < 0 {
+		return fmt.Errorf("max concurrent hashes must not be negative")
+	}
+	slots := h.MaxConcurrent
+	if slots == 0 {
+		slots = runtime.NumCPU()
+	}
+	passwordHashing = h
+	argon2Slots = make(chan struct{}, slots)
+	return nil
+}
+
+// password holds a hash in a self-describing format: the PHC string
+// "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>" for argon2id, or the
+// usual "$2a$12$..." of bcrypt.
+type password struct {
+	plainText *string
+	hash      []byte
+}
+
+func (p *password) Set(plainTextPassword string) error {
+	var hash []byte
+	var err error
+	switch passwordHashing.Algorithm {
+	case PasswordBcrypt:
+		hash, err = bcrypt.GenerateFromPassword([]byte(plainTextPassword), passwordHashing.BcryptCost)
+	default:
+		hash, err = hashArgon2id(plainTextPassword, passwordHashing.Argon2)
+	}
+	if err != nil {
+		return err
+	}
+	p.plainText = &plainTextPassword
+	p.hash = hash
+
+	return nil
+}
+
+// Matches reports whether plaintextPassword is the password. Passwords over
+// MaxPasswordLength never match and are not hashed.
+func (p *password) Matches(plaintextPassword string) (bool, error) {
+	if len(plaintextPassword) > MaxPasswordLength {
+		return false, nil
+	}
+	if isArgon2id(p.hash) {
+		params, salt, key, err := decodeArgon2id(p.hash)
+		if err != nil {
+			return false, err
+		}
+		other := argon2IDKey([]byte(plaintextPassword), salt, params, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	}
+
+	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// NeedsRehash reports whether the hash was made with another algorithm or
+// other parameters than new passwords get.
+func (p *password) NeedsRehash() bool {
+	switch passwordHashing.Algorithm {
+	case PasswordBcrypt:
+		cost, err := bcrypt.Cost(p.hash)
+		return isArgon2id(p.hash) || err != nil || cost != passwordHashing.BcryptCost
+	default:
+		if !isArgon2id(p.hash) {
+			return true
+		}
+		params, _, key, err := decodeArgon2id(p.hash)
+		return err != nil || params != passwordHashing.Argon2 || len(key) != argon2KeyLength
+	}
+}
+
+func isArgon2id(hash []byte) bool {
+	return strings.HasPrefix(string(hash), "$argon2id$")
+}
+
+var b64 = base64.RawStdEncoding
+
+// argon2IDKey is argon2.IDKey waiting for one of argon2Slots first.
+func argon2IDKey(password, salt []byte, params Argon2Params, keyLen uint32) []byte {
+	argon2Slots <- struct{}{}
+	defer func() { <-argon2Slots }()
+	return argon2.IDKey(password, salt, params.Time, params.Memory, params.Parallelism, keyLen)
+}
+
+func hashArgon2id(plainTextPassword string, params Argon2Params) ([]byte, error) {
+	salt := make([]byte, argon2SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	key := argon2IDKey([]byte(plainTextPassword), salt, params, argon2KeyLength)
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Time, params.Parallelism, b64.EncodeToString(salt), b64.EncodeToString(key))
+	return []byte(encoded), nil
+}
+
+func decodeArgon2id(hash []byte) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	return params, salt, key, nil
+}
+
+// dummyPassword stands in for the password of users that do not exist.
+var dummyPassword = sync.OnceValue(func() *password {
+	p := &password{}
+	_ = p.Set("not the password of any user")
+	return p
+})
+
+// CompareDummyPassword takes as long as checking a real password, so sign
+// in attempts on unknown usernames cannot be told apart by timing.
+func CompareDummyPassword(plaintextPassword string) {
+	_, _ = dummyPassword().Matches(plaintextPassword)
+}
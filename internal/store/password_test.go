@@ -0,0 +1,116 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// usePasswordHashing swaps the hashing configuration for the rest of the
+// test, with cheap parameters so the tests stay fast.
+func usePasswordHashing(t *testing.T, h PasswordHashing) {
+	previous, previousSlots := passwordHashing, argon2Slots
+	require.NoError(t, SetPasswordHashing(h))
+	t.Cleanup(func() { passwordHashing, argon2Slots = previous, previousSlots })
+}
+
+var testArgon2 = Argon2Params{Memory: 64, Time: 1, Parallelism: 1}
+
+func TestPasswordArgon2id(t *testing.T) {
+	usePasswordHashing(t, PasswordHashing{Algorithm: PasswordArgon2id, Argon2: testArgon2})
+
+	var p password
+	require.NoError(t, p.Set("correct horse"))
+	assert.True(t, strings.HasPrefix(string(p.hash), "$argon2id$v=19$m=64,t=1,p=1$"), string(p.hash))
+
+	ok, err := p.Matches("correct horse")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = p.Matches("wrong horse")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.False(t, p.NeedsRehash())
+
+	usePasswordHashing(t, PasswordHashing{Algorithm: PasswordArgon2id, Argon2: Argon2Params{Memory: 128, Time: 1, Parallelism: 1}})
+	assert.True(t, p.NeedsRehash(), "memory was raised")
+	ok, err = p.Matches("correct horse")
+	require.NoError(t, err)
+	assert.True(t, ok, "old parameters keep working")
+}
+
+func TestPasswordBcryptMigration(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	require.NoError(t, err)
+	p := password{hash: legacy}
+
+	usePasswordHashing(t, PasswordHashing{Algorithm: PasswordBcrypt, BcryptCost: bcrypt.MinCost})
+	assert.False(t, p.NeedsRehash())
+
+	usePasswordHashing(t, PasswordHashing{Algorithm: PasswordArgon2id, Argon2: testArgon2})
+	ok, err := p.Matches("correct horse")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, p.NeedsRehash())
+
+	require.NoError(t, p.Set("correct horse"))
+	assert.False(t, p.NeedsRehash())
+}
+
+func TestPasswordMaxLength(t *testing.T) {
+	long := strings.Repeat("a", MaxPasswordLength+1)
+	for _, h := range []PasswordHashing{
+		{Algorithm: PasswordArgon2id, Argon2: testArgon2},
+		{Algorithm: PasswordBcrypt, BcryptCost: bcrypt.MinCost},
+	} {
+		usePasswordHashing(t, h)
+		var p password
+		require.NoError(t, p.Set(long[:MaxPasswordLength]))
+
+		ok, err := p.Matches(long[:MaxPasswordLength])
+		require.NoError(t, err)
+		assert.True(t, ok)
+		ok, err = p.Matches(long)
+		require.NoError(t, err, h.Algorithm)
+		assert.False(t, ok, "longer passwords never match, whatever their prefix")
+	}
+}
+
+func TestPasswordInvalidHash(t *testing.T) {
+	p := password{hash: []byte("$argon2id$v=19$m=64,t=1$c2FsdA$a2V5")}
+	_, err := p.Matches("anything")
+	assert.ErrorIs(t, err, ErrInvalidPasswordHash)
+}
+
+func TestSetPasswordHashing(t *testing.T) {
+	assert.Error(t, SetPasswordHashing(PasswordHashing{Algorithm: "md5"}))
+	assert.Error(t, SetPasswordHashing(PasswordHashing{Algorithm: PasswordBcrypt, BcryptCost: 40}))
+	assert.Error(t, SetPasswordHashing(PasswordHashing{Algorithm: PasswordArgon2id, Argon2: Argon2Params{Memory: 64}}))
+	assert.Error(t, SetPasswordHashing(PasswordHashing{Algorithm: PasswordArgon2id, Argon2: testArgon2, MaxConcurrent: -1}))
+}
+
+func TestPasswordConcurrencyLimit(t *testing.T) {
+	usePasswordHashing(t, PasswordHashing{Algorithm: PasswordArgon2id, Argon2: testArgon2, MaxConcurrent: 1})
+	assert.Equal(t, 1, cap(argon2Slots))
+
+	var p password
+	require.NoError(t, p.Set("correct horse"))
+
+	// with the only slot taken, hashing waits for it
+	argon2Slots <- struct{}{}
+	done := make(chan bool)
+	go func() {
+		ok, _ := p.Matches("correct horse")
+		done <- ok
+	}()
+	select {
+	case <-done:
+		t.Fatal("hashed without a free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-argon2Slots
+	assert.True(t, <-done)
+}
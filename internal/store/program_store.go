@@ -0,0 +1,441 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	EnrollmentActive    = "active"
+	EnrollmentCancelled = "cancelled"
+
+	SessionCompleted = "completed"
+	SessionMissed    = "missed"
+	SessionUpcoming  = "upcoming"
+)
+
+var (
+	ErrProgramDayCompleted = errors.New("this program day already has a workout")
+	ErrInvalidProgram      = errors.New("invalid program")
+	ErrTemplateInUse       = errors.New("template is used by a program")
+)
+
+// Program is an ordered plan of templates across weeks, eg. a 12-week
+// linear-progression block.
+type Program struct {
+	ID          int          `json:"id"`
+	UserID      int          `json:"user_id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Weeks       int          `json:"weeks"`
+	Days        []ProgramDay `json:"days"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+// ProgramDay schedules a template on a day of a program week. DayNumber is
+// relative to the enrollment start date, 1 being the start date's weekday.
+type ProgramDay struct {
+	ID           int    `json:"id"`
+	WeekNumber   int    `json:"week_number"`
+	DayNumber    int    `json:"day_number"`
+	TemplateID   int    `json:"template_id"`
+	TemplateName string `json:"template_name"`
+}
+
+// Offset is the number of days between the enrollment start and this day.
+func (d ProgramDay) Offset() int {
+	return (d.WeekNumber-1)*7 + d.DayNumber - 1
+}
+
+func (p *Program) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidProgram)
+	}
+	if p.Weeks < 1 {
+		return fmt.Errorf("%w: weeks must be at least 1", ErrInvalidProgram)
+	}
+	if len(p.Days) == 0 {
+		return fmt.Errorf("%w: at least one day is required", ErrInvalidProgram)
+	}
+	seen := map[int]bool{}
+	for _, day := range p.Days {
+		if day.WeekNumber < 1 || day.WeekNumber > p.Weeks {
+			return fmt.Errorf("%w: week_number must be between 1 and %d", ErrInvalidProgram, p.Weeks)
+		}
+		if day.DayNumber < 1 || day.DayNumber > 7 {
+			return fmt.Errorf("%w: day_number must be between 1 and 7", ErrInvalidProgram)
+		}
+		if seen[day.Offset()] {
+			return fmt.Errorf("%w: week %d day %d is scheduled twice", ErrInvalidProgram, day.WeekNumber, day.DayNumber)
+		}
+		seen[day.Offset()] = true
+	}
+	return nil
+}
+
+type Enrollment struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	ProgramID   int       `json:"program_id"`
+	ProgramName string    `json:"program_name"`
+	StartDate   time.Time `json:"start_date"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	Adherence   Adherence `json:"adherence"`
+}
+
+// Adherence summarises how an enrollment is going so far. Rate is the share
+// of due sessions (completed + missed) that were completed.
+type Adherence struct {
+	TotalSessions int     `json:"total_sessions"`
+	Completed     int     `json:"completed"`
+	Missed        int     `json:"missed"`
+	Upcoming      int     `json:"upcoming"`
+	Rate          float64 `json:"rate"`
+}
+
+// ScheduledSession is one program day materialized onto the calendar.
+type ScheduledSession struct {
+	EnrollmentID  int       `json:"enrollment_id"`
+	ProgramID     int       `json:"program_id"`
+	ProgramName   string    `json:"program_name"`
+	ProgramDayID  int       `json:"program_day_id"`
+	WeekNumber    int       `json:"week_number"`
+	DayNumber     int       `json:"day_number"`
+	TemplateID    int       `json:"template_id"`
+	TemplateName  string    `json:"template_name"`
+	ScheduledDate time.Time `json:"scheduled_date"`
+	Status        string    `json:"status"`
+	WorkoutID     *int      `json:"workout_id"`
+}
+
+// sessionStatus classifies a scheduled session relative to today.
+func sessionStatus(scheduled, today time.Time, workoutID *int) string {
+	switch {
+	case workoutID != nil:
+		return SessionCompleted
+	case scheduled.Before(today):
+		return SessionMissed
+	default:
+		return SessionUpcoming
+	}
+}
+
+func truncateToDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+type PostgresProgramStore struct {
+	db *sql.DB
+}
+
+func NewPostgresProgramStore(db *sql.DB) *PostgresProgramStore {
+	return &PostgresProgramStore{db: db}
+}
+
+type ProgramStore interface {
+	CreateProgram(*Program) error
+	GetProgramByID(id int64) (*Program, error)
+	ListPrograms(userID int) ([]*Program, error)
+	DeleteProgram(id int64) error
+	Enroll(*Enrollment) error
+	GetEnrollment(id int64) (*Enrollment, error)
+	ListEnrollments(userID int) ([]*Enrollment, error)
+	CancelEnrollment(id int64) error
+	GetSchedule(userID int, from, to time.Time) ([]*ScheduledSession, error)
+	GetProgramDay(programID int, dayID int64) (*ProgramDay, error)
+}
+
+func (pg *PostgresProgramStore) CreateProgram(program *Program) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO programs (user_id, name, description, weeks)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, updated_at
+	`
+	err = tx.QueryRow(query, program.UserID, program.Name, program.Description, program.Weeks).Scan(&program.ID, &program.CreatedAt, &program.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	dayQuery := `
+	INSERT INTO program_days (program_id, week_number, day_number, template_id)
+	SELECT $1, $2, $3, t.id
+	FROM workout_templates t
+	WHERE t.id = $4 AND t.user_id = $5
+	RETURNING id, (SELECT name FROM workout_templates WHERE id = $4)
+	`
+	for i := range program.Days {
+		day := &program.Days[i]
+		err := tx.QueryRow(dayQuery, program.ID, day.WeekNumber, day.DayNumber, day.TemplateID, program.UserID).Scan(&day.ID, &day.TemplateName)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: template %d not found", ErrInvalidProgram, day.TemplateID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (pg *PostgresProgramStore) GetProgramByID(id int64) (*Program, error) {
+	program := &Program{}
+	query := `
+	SELECT id, user_id, name, description, weeks, created_at, updated_at
+	FROM programs
+	WHERE id = $1
+	`
+	err := pg.db.QueryRow(query, id).Scan(&program.ID, &program.UserID, &program.Name, &program.Description, &program.Weeks, &program.CreatedAt, &program.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = pg.loadProgramDays([]*Program{program})
+	if err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+func (pg *PostgresProgramStore) ListPrograms(userID int) ([]*Program, error) {
+	query := `
+	SELECT id, user_id, name, description, weeks, created_at, updated_at
+	FROM programs
+	WHERE user_id = $1
+	ORDER BY name, id
+	`
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	programs := []*Program{}
+	for rows.Next() {
+		program := &Program{Days: []ProgramDay{}}
+		err := rows.Scan(&program.ID, &program.UserID, &program.Name, &program.Description, &program.Weeks, &program.CreatedAt, &program.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		programs = append(programs, program)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = pg.loadProgramDays(programs)
+	if err != nil {
+		return nil, err
+	}
+	return programs, nil
+}
+
+func (pg *PostgresProgramStore) loadProgramDays(programs []*Program) error {
+	if len(programs) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(programs))
+	byID := make(map[int]*Program, len(programs))
+	for _, program := range programs {
+		ids = append(ids, int64(program.ID))
+		byID[program.ID] = program
+	}
+
+	query := `
+	SELECT d.program_id, d.id, d.week_number, d.day_number, d.template_id, t.name
+	FROM program_days d
+	INNER JOIN workout_templates t ON t.id = d.template_id
+	WHERE d.program_id = ANY($1)
+	ORDER BY d.program_id, d.week_number, d.day_number
+	`
+	rows, err := pg.db.Query(query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var programID int
+		var day ProgramDay
+		err := rows.Scan(&programID, &day.ID, &day.WeekNumber, &day.DayNumber, &day.TemplateID, &day.TemplateName)
+		if err != nil {
+			return err
+		}
+		if program, ok := byID[programID]; ok {
+			program.Days = append(program.Days, day)
+		}
+	}
+	return rows.Err()
+}
+
+func (pg *PostgresProgramStore) DeleteProgram(id int64) error {
+	result, err := pg.db.Exec(`DELETE FROM programs WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (pg *PostgresProgramStore) GetProgramDay(programID int, dayID int64) (*ProgramDay, error) {
+	day := &ProgramDay{}
+	query := `
+	SELECT d.id, d.week_number, d.day_number, d.template_id, t.name
+	FROM program_days d
+	INNER JOIN workout_templates t ON t.id = d.template_id
+	WHERE d.program_id = $1 AND d.id = $2
+	`
+	err := pg.db.QueryRow(query, programID, dayID).Scan(&day.ID, &day.WeekNumber, &day.DayNumber, &day.TemplateID, &day.TemplateName)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return day, nil
+}
+
+func (pg *PostgresProgramStore) Enroll(enrollment *Enrollment) error {
+	enrollment.StartDate = truncateToDate(enrollment.StartDate)
+	query := `
+	INSERT INTO user_program_enrollments (user_id, program_id, start_date)
+	VALUES ($1, $2, $3)
+	RETURNING id, status, created_at, (SELECT name FROM programs WHERE id = $2)
+	`
+	return pg.db.QueryRow(query, enrollment.UserID, enrollment.ProgramID, enrollment.StartDate).
+		Scan(&enrollment.ID, &enrollment.Status, &enrollment.CreatedAt, &enrollment.ProgramName)
+}
+
+// enrollmentQuery selects enrollments together with their adherence as of
+// $1, counting program days against the workouts that completed them.
+const enrollmentQuery = `
+	SELECT e.id, e.user_id, e.program_id, p.name, e.start_date, e.status, e.created_at,
+		COUNT(d.id),
+		COUNT(w.id),
+		COUNT(d.id) FILTER (WHERE w.id IS NULL AND e.start_date + ((d.week_number - 1) * 7 + d.day_number - 1) < $1::date),
+		COUNT(d.id) FILTER (WHERE w.id IS NULL AND e.start_date + ((d.week_number - 1) * 7 + d.day_number - 1) >= $1::date)
+	FROM user_program_enrollments e
+	INNER JOIN programs p ON p.id = e.program_id
+	LEFT JOIN program_days d ON d.program_id = e.program_id
+	LEFT JOIN workouts w ON w.program_enrollment_id = e.id AND w.program_day_id = d.id
+	`
+
+func scanEnrollment(row rowScanner) (*Enrollment, error) {
+	enrollment := &Enrollment{}
+	a := &enrollment.Adherence
+	err := row.Scan(&enrollment.ID, &enrollment.UserID, &enrollment.ProgramID, &enrollment.ProgramName, &enrollment.StartDate,
+		&enrollment.Status, &enrollment.CreatedAt, &a.TotalSessions, &a.Completed, &a.Missed, &a.Upcoming)
+	if err != nil {
+		return nil, err
+	}
+	if due := a.Completed + a.Missed; due > 0 {
+		a.Rate = float64(a.Completed) / float64(due)
+	}
+	return enrollment, nil
+}
+
+func (pg *PostgresProgramStore) GetEnrollment(id int64) (*Enrollment, error) {
+	query := enrollmentQuery + `
+	WHERE e.id = $2
+	GROUP BY e.id, p.name
+	`
+	enrollment, err := scanEnrollment(pg.db.QueryRow(query, truncateToDate(time.Now()), id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return enrollment, err
+}
+
+func (pg *PostgresProgramStore) ListEnrollments(userID int) ([]*Enrollment, error) {
+	query := enrollmentQuery + `
+	WHERE e.user_id = $2
+	GROUP BY e.id, p.name
+	ORDER BY e.start_date DESC, e.id DESC
+	`
+	rows, err := pg.db.Query(query, truncateToDate(time.Now()), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	enrollments := []*Enrollment{}
+	for rows.Next() {
+		enrollment, err := scanEnrollment(rows)
+		if err != nil {
+			return nil, err
+		}
+		enrollments = append(enrollments, enrollment)
+	}
+	return enrollments, rows.Err()
+}
+
+func (pg *PostgresProgramStore) CancelEnrollment(id int64) error {
+	result, err := pg.db.Exec(`UPDATE user_program_enrollments SET status = $1 WHERE id = $2`, EnrollmentCancelled, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetSchedule materializes the days of the user's active enrollments that
+// fall between from and to (inclusive dates).
+func (pg *PostgresProgramStore) GetSchedule(userID int, from, to time.Time) ([]*ScheduledSession, error) {
+	query := `
+	SELECT e.id, e.program_id, p.name, d.id, d.week_number, d.day_number, d.template_id, t.name,
+		s.scheduled_date, w.id
+	FROM user_program_enrollments e
+	INNER JOIN programs p ON p.id = e.program_id
+	INNER JOIN program_days d ON d.program_id = e.program_id
+	INNER JOIN workout_templates t ON t.id = d.template_id
+	CROSS JOIN LATERAL (SELECT e.start_date + ((d.week_number - 1) * 7 + d.day_number - 1) AS scheduled_date) s
+	LEFT JOIN workouts w ON w.program_enrollment_id = e.id AND w.program_day_id = d.id
+	WHERE e.user_id = $1 AND e.status = 'active'
+		AND s.scheduled_date BETWEEN $2::date AND $3::date
+	ORDER BY s.scheduled_date, e.id, d.id
+	`
+	rows, err := pg.db.Query(query, userID, truncateToDate(from), truncateToDate(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	today := truncateToDate(time.Now())
+	sessions := []*ScheduledSession{}
+	for rows.Next() {
+		session := &ScheduledSession{}
+		err := rows.Scan(&session.EnrollmentID, &session.ProgramID, &session.ProgramName, &session.ProgramDayID,
+			&session.WeekNumber, &session.DayNumber, &session.TemplateID, &session.TemplateName,
+			&session.ScheduledDate, &session.WorkoutID)
+		if err != nil {
+			return nil, err
+		}
+		session.Status = sessionStatus(session.ScheduledDate, today, session.WorkoutID)
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
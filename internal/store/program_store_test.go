@@ -0,0 +1,103 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgramValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		program Program
+		wantErr bool
+	}{
+		{
+			name:    "valid",
+			program: Program{Name: "5x5", Weeks: 2, Days: []ProgramDay{{WeekNumber: 1, DayNumber: 1}, {WeekNumber: 2, DayNumber: 3}}},
+		},
+		{
+			name:    "missing name",
+			program: Program{Weeks: 1, Days: []ProgramDay{{WeekNumber: 1, DayNumber: 1}}},
+			wantErr: true,
+		},
+		{
+			name:    "no days",
+			program: Program{Name: "5x5", Weeks: 1},
+			wantErr: true,
+		},
+		{
+			name:    "week out of range",
+			program: Program{Name: "5x5", Weeks: 1, Days: []ProgramDay{{WeekNumber: 2, DayNumber: 1}}},
+			wantErr: true,
+		},
+		{
+			name:    "day out of range",
+			program: Program{Name: "5x5", Weeks: 1, Days: []ProgramDay{{WeekNumber: 1, DayNumber: 8}}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate day",
+			program: Program{Name: "5x5", Weeks: 1, Days: []ProgramDay{{WeekNumber: 1, DayNumber: 2}, {WeekNumber: 1, DayNumber: 2}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.program.Validate()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidProgram)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSessionStatus(t *testing.T) {
+	today := time.Date(2025, 6, 10, 0, 0, 0, 0, time.UTC)
+	workoutID := 7
+
+	assert.Equal(t, SessionCompleted, sessionStatus(today.AddDate(0, 0, -3), today, &workoutID))
+	assert.Equal(t, SessionMissed, sessionStatus(today.AddDate(0, 0, -1), today, nil))
+	assert.Equal(t, SessionUpcoming, sessionStatus(today, today, nil))
+	assert.Equal(t, 8, ProgramDay{WeekNumber: 2, DayNumber: 2}.Offset())
+}
+
+func TestCreateWorkoutForProgramDay(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db, "enrolled")
+	templateStore := NewPostgresTemplateStore(db)
+	programStore := NewPostgresProgramStore(db)
+	workoutStore := NewPostgresWorkoutStore(db)
+
+	template := &WorkoutTemplate{
+		UserID:  user.ID,
+		Name:    "push day",
+		Entries: []WorkoutEntry{{ExerciseName: "Bench Press", Sets: 3, Reps: IntPtr(5), OrderIndex: 1}},
+	}
+	require.NoError(t, templateStore.CreateTemplate(template))
+	program := &Program{UserID: user.ID, Name: "5x5", Weeks: 1, Days: []ProgramDay{{WeekNumber: 1, DayNumber: 1, TemplateID: template.ID}}}
+	require.NoError(t, programStore.CreateProgram(program))
+	enrollment := &Enrollment{UserID: user.ID, ProgramID: program.ID, StartDate: time.Now()}
+	require.NoError(t, programStore.Enroll(enrollment))
+
+	newWorkout := func() *Workout {
+		return &Workout{
+			UserID:              user.ID,
+			Title:               "push day",
+			ProgramEnrollmentID: &enrollment.ID,
+			ProgramDayID:        &program.Days[0].ID,
+			Entries:             []WorkoutEntry{{ExerciseName: "Bench Press", Sets: 3, Reps: IntPtr(5), OrderIndex: 1}},
+		}
+	}
+	_, err := workoutStore.CreateWorkout(newWorkout())
+	require.NoError(t, err)
+	_, err = workoutStore.CreateWorkout(newWorkout())
+	assert.ErrorIs(t, err, ErrProgramDayCompleted)
+}
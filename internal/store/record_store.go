@@ -0,0 +1,443 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	RecordMaxWeight    = "max_weight"
+	RecordMaxReps      = "max_reps"
+	RecordEstimated1RM = "estimated_1rm"
+	RecordMaxDuration  = "max_duration"
+)
+
+// PersonalRecord is one point of a user's PR history for an exercise. Value
+// is the weight, reps, estimated one-rep max or seconds depending on
+// RecordType; Weight and Reps describe the set that achieved it.
+type PersonalRecord struct {
+	ID           int       `json:"id"`
+	UserID       int       `json:"user_id"`
+	ExerciseID   int       `json:"exercise_id"`
+	ExerciseName string    `json:"exercise_name"`
+	RecordType   string    `json:"record_type"`
+	Value        float64   `json:"value"`
+	Weight       *float64  `json:"weight"`
+	Reps         *int      `json:"reps"`
+	WorkoutID    int       `json:"workout_id"`
+	SetID        *int      `json:"set_id"`
+	AchievedAt   time.Time `json:"achieved_at"`
+
+	// entryIndex and setIndex locate the set within its workout; they are
+	// unknown for records whose set was gone before they were kept.
+	entryIndex *int
+	setIndex   *int
+}
+
+// EstimateOneRepMax estimates the one-rep max of a set using Brzycki up to
+// ten reps, where it is the more accurate of the two, and Epley above.
+func EstimateOneRepMax(weight float64, reps int) float64 {
+	var estimate float64
+	switch {
+	case reps <= 0 || weight <= 0:
+		return 0
+	case reps == 1:
+		estimate = weight
+	case reps <= 10:
+		estimate = weight * 36 / float64(37-reps)
+	default:
+		estimate = weight * (1 + float64(reps)/30)
+	}
+	return math.Round(estimate*100) / 100
+}
+
+// recordSet is a logged set as seen by the PR computation. EntryIndex and
+// SetIndex locate it within its workout.
+type recordSet struct {
+	WorkoutID       int
+	SetID           int
+	EntryIndex      int
+	SetIndex        int
+	PerformedAt     time.Time
+	SetType         string
+	Reps            *int
+	DurationSeconds *int
+	Weight          *float64
+}
+
+// workoutBest is the best set of a workout for one record.
+type workoutBest struct {
+	value float64
+	set   recordSet
+}
+
+// computeRecords replays an exercise's workouts in chronological order and
+// returns the records each of them set: the workout's best of a record type
+// when it beats every earlier workout. Max reps only count at a weight
+// lifted in an earlier workout, so a first set at a new weight is not a PR.
+// Warm-up sets never count.
+func computeRecords(userID, exerciseID int, sets []recordSet) []PersonalRecord {
+	var records []PersonalRecord
+	best := map[string]float64{}
+	repsAtWeight := map[float64]int{}
+
+	record := func(recordType string, b workoutBest) {
+		set := b.set
+		records = append(records, PersonalRecord{
+			UserID:     userID,
+			ExerciseID: exerciseID,
+			RecordType: recordType,
+			Value:      b.value,
+			Weight:     set.Weight,
+			Reps:       set.Reps,
+			WorkoutID:  set.WorkoutID,
+			SetID:      &set.SetID,
+			AchievedAt: set.PerformedAt,
+			entryIndex: &set.EntryIndex,
+			setIndex:   &set.SetIndex,
+		})
+	}
+
+	for start := 0; start < len(sets); {
+		end := start
+		for end < len(sets) && sets[end].WorkoutID == sets[start].WorkoutID {
+			end++
+		}
+
+		workout := map[string]workoutBest{}
+		improve := func(recordType string, value float64, set recordSet) {
+			if value > workout[recordType].value {
+				workout[recordType] = workoutBest{value: value, set: set}
+			}
+		}
+		var weights []float64
+		repsAt := map[float64]workoutBest{}
+		for _, set := range sets[start:end] {
+			if set.SetType == SetTypeWarmup {
+				continue
+			}
+			if set.DurationSeconds != nil && *set.DurationSeconds > 0 {
+				improve(RecordMaxDuration, float64(*set.DurationSeconds), set)
+				continue
+			}
+			if set.Reps == nil || *set.Reps <= 0 || set.Weight == nil || *set.Weight <= 0 {
+				continue
+			}
+			weight, reps := *set.Weight, *set.Reps
+			improve(RecordMaxWeight, weight, set)
+			improve(RecordEstimated1RM, EstimateOneRepMax(weight, reps), set)
+			if _, ok := repsAt[weight]; !ok {
+				weights = append(weights, weight)
+			}
+			if float64(reps) > repsAt[weight].value {
+				repsAt[weight] = workoutBest{value: float64(reps), set: set}
+			}
+		}
+
+		if b, ok := workout[RecordMaxWeight]; ok && b.value > best[RecordMaxWeight] {
+			best[RecordMaxWeight] = b.value
+			record(RecordMaxWeight, b)
+		}
+		for _, weight := range weights {
+			b := repsAt[weight]
+			previous, lifted := repsAtWeight[weight]
+			if lifted && int(b.value) > previous {
+				record(RecordMaxReps, b)
+			}
+			if !lifted || int(b.value) > previous {
+				repsAtWeight[weight] = int(b.value)
+			}
+		}
+		for _, recordType := range []string{RecordEstimated1RM, RecordMaxDuration} {
+			if b, ok := workout[recordType]; ok && b.value > best[recordType] {
+				best[recordType] = b.value
+				record(recordType, b)
+			}
+		}
+		start = end
+	}
+	return records
+}
+
+type PostgresRecordStore struct {
+	db *sql.DB
+}
+
+func NewPostgresRecordStore(db *sql.DB) *PostgresRecordStore {
+	return &PostgresRecordStore{db: db}
+}
+
+type RecordStore interface {
+	GetCurrentRecords(userID int) ([]*PersonalRecord, error)
+	GetExerciseRecordHistory(userID int, exerciseID int64) ([]*PersonalRecord, error)
+}
+
+// GetCurrentRecords returns the standing best of every record type of every
+// exercise; for max_reps that is one record per weight lifted.
+func (pg *PostgresRecordStore) GetCurrentRecords(userID int) ([]*PersonalRecord, error) {
+	query := `
+	SELECT DISTINCT ON (pr.exercise_id, pr.record_type, CASE WHEN pr.record_type = 'max_reps' THEN pr.weight END)
+		pr.id, pr.user_id, pr.exercise_id, e.name, pr.record_type, pr.value, pr.weight, pr.reps, pr.workout_id, pr.set_id, pr.achieved_at
+	FROM personal_records pr
+	INNER JOIN exercises e ON e.id = pr.exercise_id
+	WHERE pr.user_id = $1
+	ORDER BY pr.exercise_id, pr.record_type, CASE WHEN pr.record_type = 'max_reps' THEN pr.weight END, pr.value DESC, pr.achieved_at DESC
+	`
+	return pg.queryRecords(query, userID)
+}
+
+func (pg *PostgresRecordStore) GetExerciseRecordHistory(userID int, exerciseID int64) ([]*PersonalRecord, error) {
+	query := `
+	SELECT pr.id, pr.user_id, pr.exercise_id, e.name, pr.record_type, pr.value, pr.weight, pr.reps, pr.workout_id, pr.set_id, pr.achieved_at
+	FROM personal_records pr
+	INNER JOIN exercises e ON e.id = pr.exercise_id
+	WHERE pr.user_id = $1 AND pr.exercise_id = $2
+	ORDER BY pr.achieved_at, pr.id
+	`
+	return pg.queryRecords(query, userID, exerciseID)
+}
+
+func (pg *PostgresRecordStore) queryRecords(query string, args ...any) ([]*PersonalRecord, error) {
+	rows, err := pg.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []*PersonalRecord{}
+	for rows.Next() {
+		record := &PersonalRecord{}
+		err := rows.Scan(
+			&record.ID,
+			&record.UserID,
+			&record.ExerciseID,
+			&record.ExerciseName,
+			&record.RecordType,
+			&record.Value,
+			&record.Weight,
+			&record.Reps,
+			&record.WorkoutID,
+			&record.SetID,
+			&record.AchievedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// workoutExerciseIDs returns the catalog exercises logged in a workout.
+func workoutExerciseIDs(tx *sql.Tx, workoutID int) ([]int, error) {
+	rows, err := tx.Query(`SELECT DISTINCT exercise_id FROM workout_entries WHERE workout_id = $1 AND exercise_id IS NOT NULL`, workoutID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// recordsLock is the advisory lock class serialising the PR computations of
+// one user, so concurrent saves do not both insert the same records.
+const recordsLock = 3
+
+// recomputeRecords brings the PR history of the given exercises in line with
+// the user's logged sets inside tx, so creating, editing or deleting any
+// workout leaves the records consistent. Only the records that changed are
+// written; the others keep their row. It returns the records of workoutID
+// that did not exist before, which callers report back as newly hit PRs.
+func recomputeRecords(tx *sql.Tx, userID int, exerciseIDs []int, workoutID int) ([]PersonalRecord, error) {
+	setQuery := `
+	SELECT w.id, s.id, e.order_index, s.set_index, w.performed_at, s.set_type, s.reps, s.duration_seconds, s.weight
+	FROM workout_sets s
+	INNER JOIN workout_entries e ON e.id = s.entry_id
+	INNER JOIN workouts w ON w.id = e.workout_id
+	WHERE w.user_id = $1 AND e.exercise_id = $2
+	ORDER BY w.performed_at, w.id, e.order_index, s.set_index
+	`
+	insertQuery := `
+	INSERT INTO personal_records (user_id, exercise_id, record_type, value, weight, reps, workout_id, set_id, achieved_at, entry_index, set_index)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	RETURNING id
+	`
+
+	// the records of every later workout depend on this one, so the whole
+	// load, diff and write runs under the user's lock
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, recordsLock, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	achieved := []PersonalRecord{}
+	seen := map[int]bool{}
+	for _, exerciseID := range exerciseIDs {
+		if seen[exerciseID] {
+			continue
+		}
+		seen[exerciseID] = true
+
+		var exerciseName string
+		err := tx.QueryRow(`SELECT name FROM exercises WHERE id = $1`, exerciseID).Scan(&exerciseName)
+		if err != nil {
+			return nil, err
+		}
+
+		sets, err := loadRecordSets(tx, setQuery, userID, exerciseID)
+		if err != nil {
+			return nil, err
+		}
+		existing, err := loadExistingRecords(tx, userID, exerciseID)
+		if err != nil {
+			return nil, err
+		}
+
+		diff := diffRecords(existing, computeRecords(userID, exerciseID, sets))
+		for _, id := range diff.remove {
+			_, err = tx.Exec(`DELETE FROM personal_records WHERE id = $1`, id)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// edited workouts get their sets re-inserted, so records they keep
+		// follow their set to its new id, and the workout's new time
+		for _, record := range diff.moved {
+			_, err = tx.Exec(`UPDATE personal_records SET set_id = $1, achieved_at = $2 WHERE id = $3`, record.SetID, record.AchievedAt, record.ID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, record := range diff.insert {
+			err := tx.QueryRow(insertQuery, record.UserID, record.ExerciseID, record.RecordType, record.Value,
+				record.Weight, record.Reps, record.WorkoutID, record.SetID, record.AchievedAt, record.entryIndex, record.setIndex).Scan(&record.ID)
+			if err != nil {
+				return nil, err
+			}
+			if record.WorkoutID == workoutID {
+				record.ExerciseName = exerciseName
+				achieved = append(achieved, record)
+			}
+		}
+	}
+	return achieved, nil
+}
+
+// recordDiff turns the stored records of an exercise into the computed ones.
+type recordDiff struct {
+	remove []int
+	// moved are stored records, with their id, whose set has a new id or
+	// whose workout was moved in time
+	moved  []PersonalRecord
+	insert []PersonalRecord
+}
+
+// recordKey identifies a record by what was achieved and by the workout and
+// position of its set, leaving out the set id, which changes whenever a
+// workout is edited, and the time, which moves with the workout.
+func recordKey(r PersonalRecord) string {
+	weight, reps := "-", "-"
+	if r.Weight != nil {
+		weight = strconv.FormatFloat(*r.Weight, 'f', 2, 64)
+	}
+	if r.Reps != nil {
+		reps = strconv.Itoa(*r.Reps)
+	}
+	entry, set := "-", "-"
+	if r.entryIndex != nil && r.setIndex != nil {
+		entry, set = strconv.Itoa(*r.entryIndex), strconv.Itoa(*r.setIndex)
+	}
+	return fmt.Sprintf("%s|%.2f|%s|%s|%d|%s|%s", r.RecordType, r.Value, weight, reps, r.WorkoutID, entry, set)
+}
+
+func diffRecords(existing, computed []PersonalRecord) recordDiff {
+	stored := map[string][]PersonalRecord{}
+	for _, record := range existing {
+		key := recordKey(record)
+		stored[key] = append(stored[key], record)
+	}
+
+	var diff recordDiff
+	for _, record := range computed {
+		key := recordKey(record)
+		matches := stored[key]
+		if len(matches) == 0 {
+			diff.insert = append(diff.insert, record)
+			continue
+		}
+		kept := matches[0]
+		stored[key] = matches[1:]
+		if !equalIntPtr(kept.SetID, record.SetID) || !kept.AchievedAt.Equal(record.AchievedAt) {
+			kept.SetID, kept.AchievedAt = record.SetID, record.AchievedAt
+			diff.moved = append(diff.moved, kept)
+		}
+	}
+	for _, record := range existing {
+		if rest := stored[recordKey(record)]; len(rest) > 0 {
+			diff.remove = append(diff.remove, rest[0].ID)
+			stored[recordKey(record)] = rest[1:]
+		}
+	}
+	return diff
+}
+
+func equalIntPtr(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func loadExistingRecords(tx *sql.Tx, userID, exerciseID int) ([]PersonalRecord, error) {
+	query := `
+	SELECT id, record_type, value, weight, reps, workout_id, set_id, achieved_at, entry_index, set_index
+	FROM personal_records
+	WHERE user_id = $1 AND exercise_id = $2
+	`
+	rows, err := tx.Query(query, userID, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []PersonalRecord
+	for rows.Next() {
+		record := PersonalRecord{UserID: userID, ExerciseID: exerciseID}
+		err := rows.Scan(&record.ID, &record.RecordType, &record.Value, &record.Weight, &record.Reps, &record.WorkoutID, &record.SetID, &record.AchievedAt, &record.entryIndex, &record.setIndex)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func loadRecordSets(tx *sql.Tx, query string, userID, exerciseID int) ([]recordSet, error) {
+	rows, err := tx.Query(query, userID, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sets []recordSet
+	for rows.Next() {
+		var set recordSet
+		err := rows.Scan(&set.WorkoutID, &set.SetID, &set.EntryIndex, &set.SetIndex, &set.PerformedAt, &set.SetType, &set.Reps, &set.DurationSeconds, &set.Weight)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	return sets, rows.Err()
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateOneRepMax(t *testing.T) {
+	assert.Equal(t, 100.0, EstimateOneRepMax(100, 1))
+	assert.Equal(t, 112.5, EstimateOneRepMax(100, 5))  // Brzycki
+	assert.Equal(t, 140.0, EstimateOneRepMax(100, 12)) // Epley
+	assert.Equal(t, 0.0, EstimateOneRepMax(0, 5))
+}
+
+func TestComputeRecords(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 5, d, 0, 0, 0, 0, time.UTC) }
+	set := func(workoutID, setID int, setType string, reps int, weight float64) recordSet {
+		return recordSet{WorkoutID: workoutID, SetID: setID, SetIndex: setID, PerformedAt: day(workoutID),
+			SetType: setType, Reps: IntPtr(reps), Weight: FloatPtr(weight)}
+	}
+	sets := []recordSet{
+		set(1, 1, SetTypeWarmup, 10, 200),
+		// a pyramid only sets the workout's best
+		set(1, 2, SetTypeWorking, 5, 60),
+		set(1, 3, SetTypeWorking, 5, 70),
+		set(1, 4, SetTypeWorking, 5, 80),
+		set(2, 5, SetTypeWorking, 8, 80),
+		set(2, 6, SetTypeWorking, 3, 90),
+		set(3, 7, SetTypeWorking, 9, 80),
+		set(3, 8, SetTypeWorking, 10, 80),
+	}
+
+	type got struct {
+		recordType string
+		setID      int
+	}
+	var records []got
+	for _, record := range computeRecords(7, 3, sets) {
+		records = append(records, got{record.RecordType, *record.SetID})
+	}
+	assert.Equal(t, []got{
+		{RecordMaxWeight, 4}, {RecordEstimated1RM, 4}, // 5x80 is the first workout's best
+		{RecordMaxWeight, 6}, {RecordMaxReps, 5}, {RecordEstimated1RM, 5}, // 90 is new, so only 8x80 beats an earlier workout
+		{RecordMaxReps, 8}, {RecordEstimated1RM, 8}, // 10x80 is the best of the workout
+	}, records)
+}
+
+func TestDiffRecords(t *testing.T) {
+	day := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	record := func(id, workoutID, setID int, recordType string, value float64) PersonalRecord {
+		return PersonalRecord{ID: id, WorkoutID: workoutID, SetID: IntPtr(setID), RecordType: recordType,
+			Value: value, Weight: FloatPtr(value), Reps: IntPtr(5), AchievedAt: day,
+			entryIndex: IntPtr(1), setIndex: IntPtr(setID % 100)}
+	}
+
+	existing := []PersonalRecord{
+		record(1, 10, 100, RecordMaxWeight, 80),
+		record(2, 10, 101, RecordMaxReps, 5),
+		record(3, 11, 110, RecordMaxWeight, 90),
+		record(4, 13, 130, RecordMaxWeight, 95),
+	}
+	// workout 10 was edited: its sets got new ids, the 80 stays a record and
+	// the max_reps set is gone; workout 12 adds a new best and workout 13
+	// was moved a day later
+	moved := record(0, 13, 130, RecordMaxWeight, 95)
+	moved.AchievedAt = day.AddDate(0, 0, 1)
+	computed := []PersonalRecord{
+		record(0, 10, 200, RecordMaxWeight, 80),
+		record(0, 11, 110, RecordMaxWeight, 90),
+		record(0, 12, 120, RecordMaxWeight, 95),
+		moved,
+	}
+
+	diff := diffRecords(existing, computed)
+	assert.Equal(t, []int{2}, diff.remove)
+	require.Len(t, diff.moved, 2)
+	assert.Equal(t, 1, diff.moved[0].ID)
+	assert.Equal(t, 200, *diff.moved[0].SetID)
+	assert.Equal(t, 4, diff.moved[1].ID)
+	assert.Equal(t, moved.AchievedAt, diff.moved[1].AchievedAt, "moving a workout in time keeps its records")
+	require.Len(t, diff.insert, 1, "records already held are not reported again")
+	assert.Equal(t, 12, diff.insert[0].WorkoutID)
+
+	diff = diffRecords(existing, nil)
+	assert.ElementsMatch(t, []int{1, 2, 3, 4}, diff.remove)
+}
+
+func TestRecomputeRecords(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db, "lifter")
+	exercise := &Exercise{UserID: &user.ID, Name: "Zercher Squat"}
+	require.NoError(t, NewPostgresExerciseStore(db).CreateCustomExercise(exercise))
+	workoutStore := NewPostgresWorkoutStore(db)
+	recordStore := NewPostgresRecordStore(db)
+
+	newWorkout := func(performedAt time.Time, weights ...float64) *Workout {
+		workout := &Workout{UserID: user.ID, Title: "squats", PerformedAt: performedAt}
+		for i, weight := range weights {
+			workout.Entries = append(workout.Entries, WorkoutEntry{ExerciseID: &exercise.ID, Sets: 1, Reps: IntPtr(5), Weight: FloatPtr(weight), OrderIndex: i + 1})
+		}
+		return workout
+	}
+	start := time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	workout, err := workoutStore.CreateWorkout(newWorkout(start, 60, 70, 80))
+	require.NoError(t, err)
+	assert.Len(t, workout.NewRecords, 2, "a pyramid sets its best weight and e1RM once")
+
+	t.Run("moving a workout in time reports no new records", func(t *testing.T) {
+		workout.PerformedAt = start.Add(time.Hour)
+		require.NoError(t, workoutStore.UpdateWorkout(workout))
+		assert.Empty(t, workout.NewRecords)
+
+		history, err := recordStore.GetExerciseRecordHistory(user.ID, int64(exercise.ID))
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		assert.True(t, history[0].AchievedAt.Equal(workout.PerformedAt))
+	})
+
+	t.Run("concurrent saves do not duplicate records", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := workoutStore.CreateWorkout(newWorkout(start.AddDate(0, 0, 1+i), 100))
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+
+		var n int
+		require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM personal_records WHERE user_id = $1 AND record_type = $2 AND value = 100`, user.ID, RecordMaxWeight).Scan(&n))
+		assert.Equal(t, 1, n)
+	})
+}
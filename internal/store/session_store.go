@@ -0,0 +1,516 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	WorkoutSessionActive    = "active"
+	WorkoutSessionFinished  = "finished"
+	WorkoutSessionAbandoned = "abandoned"
+)
+
+var (
+	ErrSessionInProgress = errors.New("another workout session is already in progress")
+	ErrSessionNotActive  = errors.New("workout session is no longer active")
+	ErrEmptySession      = errors.New("cannot finish a workout session without sets")
+)
+
+// WorkoutSession is a workout being logged live, one set at a time. Sets are
+// saved as they happen so nothing is lost if the client goes away; finishing
+// the session turns it into a regular Workout.
+type WorkoutSession struct {
+	ID                 int          `json:"id"`
+	UserID             int          `json:"user_id"`
+	Title              string       `json:"title"`
+	Timezone           string       `json:"timezone"`
+	TemplateID         *int         `json:"template_id"`
+	Status             string       `json:"status"`
+	DefaultRestSeconds *int         `json:"default_rest_seconds"`
+	RestTimer          *RestTimer   `json:"rest_timer"`
+	WorkoutID          *int         `json:"workout_id"`
+	StartedAt          time.Time    `json:"started_at"`
+	LastActivityAt     time.Time    `json:"last_activity_at"`
+	EndedAt            *time.Time   `json:"ended_at"`
+	Sets               []SessionSet `json:"sets"`
+}
+
+// RestTimer is the rest period running between two sets. RemainingSeconds is
+// computed by the server so clients with a skewed clock still agree on it.
+type RestTimer struct {
+	StartedAt        time.Time  `json:"started_at"`
+	TargetSeconds    *int       `json:"target_seconds"`
+	EndsAt           *time.Time `json:"ends_at"`
+	RemainingSeconds *int       `json:"remaining_seconds"`
+}
+
+func newRestTimer(startedAt *time.Time, targetSeconds *int, now time.Time) *RestTimer {
+	if startedAt == nil {
+		return nil
+	}
+	timer := &RestTimer{StartedAt: *startedAt, TargetSeconds: targetSeconds}
+	if targetSeconds != nil {
+		endsAt := startedAt.Add(time.Duration(*targetSeconds) * time.Second)
+		remaining := max(int(endsAt.Sub(now).Round(time.Second)/time.Second), 0)
+		timer.EndsAt = &endsAt
+		timer.RemainingSeconds = &remaining
+	}
+	return timer
+}
+
+// SessionSet is one set logged during a session. ClientID is an optional
+// client-generated key making retries of the same set idempotent.
+type SessionSet struct {
+	ID              int       `json:"id"`
+	ClientID        *string   `json:"client_id"`
+	ExerciseID      *int      `json:"exercise_id"`
+	ExerciseName    string    `json:"exercise_name"`
+	SetType         string    `json:"set_type"`
+	Reps            *int      `json:"reps"`
+	DurationSeconds *int      `json:"duration_seconds"`
+	Weight          *float64  `json:"weight"`
+	RPE             *float64  `json:"rpe"`
+	RIR             *int      `json:"rir"`
+	RestSeconds     *int      `json:"rest_seconds"`
+	LoggedAt        time.Time `json:"logged_at"`
+}
+
+func (s *SessionSet) workoutSet() WorkoutSet {
+	return WorkoutSet{
+		SetType:         s.SetType,
+		Reps:            s.Reps,
+		DurationSeconds: s.DurationSeconds,
+		Weight:          s.Weight,
+		RPE:             s.RPE,
+		RIR:             s.RIR,
+		RestSeconds:     s.RestSeconds,
+	}
+}
+
+func (s *SessionSet) Validate() error {
+	if NormalizeExerciseName(s.ExerciseName) == "" && s.ExerciseID == nil {
+		return fmt.Errorf("%w: exercise_name or exercise_id is required", ErrInvalidWorkoutSet)
+	}
+	if len(s.ExerciseName) > 255 {
+		return fmt.Errorf("%w: exercise_name cannot be greater than 255", ErrInvalidWorkoutSet)
+	}
+	if s.ClientID != nil && len(*s.ClientID) > 64 {
+		return fmt.Errorf("%w: client_id cannot be greater than 64", ErrInvalidWorkoutSet)
+	}
+	set := s.workoutSet()
+	if err := set.validate(); err != nil {
+		return err
+	}
+	s.SetType = set.SetType
+	return nil
+}
+
+// SessionFinish holds the details only known once a session is over.
+type SessionFinish struct {
+	Title          *string `json:"title"`
+	Description    string  `json:"description"`
+	CaloriesBurned int     `json:"calories_burned"`
+}
+
+// toWorkout groups the sets of the session into one entry per exercise, in
+// the order the exercises were first performed.
+func (s *WorkoutSession) toWorkout(endedAt time.Time, finish SessionFinish) *Workout {
+	startedAt := s.StartedAt
+	workout := &Workout{
+		UserID:         s.UserID,
+		Title:          s.Title,
+		Description:    finish.Description,
+		CaloriesBurned: finish.CaloriesBurned,
+		PerformedAt:    startedAt,
+		StartedAt:      &startedAt,
+		EndedAt:        &endedAt,
+		Timezone:       s.Timezone,
+		TemplateID:     s.TemplateID,
+	}
+	if finish.Title != nil {
+		workout.Title = *finish.Title
+	}
+
+	byExercise := map[string]int{}
+	for i := range s.Sets {
+		set := &s.Sets[i]
+		key := "name:" + NormalizeExerciseName(set.ExerciseName)
+		if set.ExerciseID != nil {
+			key = "id:" + strconv.Itoa(*set.ExerciseID)
+		}
+
+		index, ok := byExercise[key]
+		if !ok {
+			index = len(workout.Entries)
+			byExercise[key] = index
+			workout.Entries = append(workout.Entries, WorkoutEntry{
+				ExerciseID:   set.ExerciseID,
+				ExerciseName: set.ExerciseName,
+				OrderIndex:   index + 1,
+			})
+		}
+		entry := &workout.Entries[index]
+		entry.SetDetails = append(entry.SetDetails, set.workoutSet())
+	}
+	return workout
+}
+
+type PostgresSessionStore struct {
+	db *sql.DB
+}
+
+func NewPostgresSessionStore(db *sql.DB) *PostgresSessionStore {
+	return &PostgresSessionStore{db: db}
+}
+
+type SessionStore interface {
+	StartSession(*WorkoutSession) error
+	GetSession(id int64) (*WorkoutSession, error)
+	GetActiveSession(userID int) (*WorkoutSession, error)
+	LogSet(sessionID int64, set *SessionSet) error
+	DeleteSet(sessionID int64, setID int64) error
+	SetRestTimer(sessionID int64, targetSeconds *int, running bool) error
+	FinishSession(id int64, finish SessionFinish) (*Workout, error)
+	AbandonSession(id int64) error
+	AbandonStaleSessions(staleAfter time.Duration) (int64, error)
+}
+
+const sessionColumns = `id, user_id, title, timezone, template_id, status, default_rest_seconds,
+	rest_started_at, rest_target_seconds, workout_id, started_at, last_activity_at, ended_at`
+
+func scanSession(row rowScanner) (*WorkoutSession, error) {
+	session := &WorkoutSession{Sets: []SessionSet{}}
+	var restStartedAt *time.Time
+	var restTargetSeconds *int
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.Title,
+		&session.Timezone,
+		&session.TemplateID,
+		&session.Status,
+		&session.DefaultRestSeconds,
+		&restStartedAt,
+		&restTargetSeconds,
+		&session.WorkoutID,
+		&session.StartedAt,
+		&session.LastActivityAt,
+		&session.EndedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if session.Status == WorkoutSessionActive {
+		session.RestTimer = newRestTimer(restStartedAt, restTargetSeconds, time.Now())
+	}
+	return session, nil
+}
+
+func (pg *PostgresSessionStore) StartSession(session *WorkoutSession) error {
+	query := `
+	INSERT INTO workout_sessions (user_id, title, timezone, template_id, default_rest_seconds)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING ` + sessionColumns
+
+	started, err := scanSession(pg.db.QueryRow(query, session.UserID, session.Title, session.Timezone, session.TemplateID, session.DefaultRestSeconds))
+	if isUniqueViolation(err) {
+		return ErrSessionInProgress
+	}
+	if err != nil {
+		return err
+	}
+	*session = *started
+	return nil
+}
+
+func (pg *PostgresSessionStore) GetSession(id int64) (*WorkoutSession, error) {
+	query := `SELECT ` + sessionColumns + ` FROM workout_sessions WHERE id = $1`
+	return pg.getSession(query, id)
+}
+
+func (pg *PostgresSessionStore) GetActiveSession(userID int) (*WorkoutSession, error) {
+	query := `SELECT ` + sessionColumns + ` FROM workout_sessions WHERE user_id = $1 AND status = 'active'`
+	return pg.getSession(query, userID)
+}
+
+func (pg *PostgresSessionStore) getSession(query string, arg any) (*WorkoutSession, error) {
+	session, err := scanSession(pg.db.QueryRow(query, arg))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	session.Sets, err = loadSessionSets(pg.db, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+const sessionSetColumns = `id, client_id, exercise_id, exercise_name, set_type, reps, duration_seconds, weight, rpe, rir, rest_seconds, logged_at`
+
+func scanSessionSet(row rowScanner) (*SessionSet, error) {
+	set := &SessionSet{}
+	err := row.Scan(
+		&set.ID,
+		&set.ClientID,
+		&set.ExerciseID,
+		&set.ExerciseName,
+		&set.SetType,
+		&set.Reps,
+		&set.DurationSeconds,
+		&set.Weight,
+		&set.RPE,
+		&set.RIR,
+		&set.RestSeconds,
+		&set.LoggedAt,
+	)
+	return set, err
+}
+
+func loadSessionSets(q querier, sessionID int) ([]SessionSet, error) {
+	query := `
+	SELECT ` + sessionSetColumns + `
+	FROM workout_session_sets
+	WHERE session_id = $1
+	ORDER BY logged_at, id
+	`
+	rows, err := q.Query(query, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sets := []SessionSet{}
+	for rows.Next() {
+		set, err := scanSessionSet(rows)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, *set)
+	}
+	return sets, rows.Err()
+}
+
+// lockActiveSession locks the session row for the rest of tx and fails with
+// ErrSessionNotActive once it has been finished or abandoned.
+func lockActiveSession(tx *sql.Tx, sessionID int64) (*WorkoutSession, error) {
+	query := `SELECT ` + sessionColumns + ` FROM workout_sessions WHERE id = $1 FOR UPDATE`
+	session, err := scanSession(tx.QueryRow(query, sessionID))
+	if err != nil {
+		return nil, err
+	}
+	if session.Status != WorkoutSessionActive {
+		return nil, ErrSessionNotActive
+	}
+	return session, nil
+}
+
+// LogSet appends a set to an active session and starts the rest timer, using
+// the set's rest_seconds or else the session default as its target. A set
+// whose client_id was already logged is returned as stored instead.
+func (pg *PostgresSessionStore) LogSet(sessionID int64, set *SessionSet) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	session, err := lockActiveSession(tx, sessionID)
+	if err != nil {
+		return err
+	}
+
+	if set.ClientID != nil {
+		query := `SELECT ` + sessionSetColumns + ` FROM workout_session_sets WHERE session_id = $1 AND client_id = $2`
+		existing, err := scanSessionSet(tx.QueryRow(query, sessionID, *set.ClientID))
+		if err == nil {
+			*set = *existing
+			return nil
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	entries := []WorkoutEntry{{ExerciseID: set.ExerciseID, ExerciseName: set.ExerciseName}}
+	err = resolveEntryExercises(tx, session.UserID, entries)
+	if err != nil {
+		return err
+	}
+	set.ExerciseID, set.ExerciseName = entries[0].ExerciseID, entries[0].ExerciseName
+
+	query := `
+	INSERT INTO workout_session_sets (session_id, client_id, exercise_id, exercise_name, set_type, reps, duration_seconds, weight, rpe, rir, rest_seconds)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	RETURNING id, logged_at
+	`
+	err = tx.QueryRow(query, sessionID, set.ClientID, set.ExerciseID, set.ExerciseName, set.SetType, set.Reps,
+		set.DurationSeconds, set.Weight, set.RPE, set.RIR, set.RestSeconds).Scan(&set.ID, &set.LoggedAt)
+	if err != nil {
+		return err
+	}
+
+	restTarget := session.DefaultRestSeconds
+	if set.RestSeconds != nil {
+		restTarget = set.RestSeconds
+	}
+	_, err = tx.Exec(`
+	UPDATE workout_sessions
+	SET last_activity_at = $1, rest_started_at = $1, rest_target_seconds = $2
+	WHERE id = $3
+	`, set.LoggedAt, restTarget, sessionID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (pg *PostgresSessionStore) DeleteSet(sessionID int64, setID int64) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockActiveSession(tx, sessionID); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`DELETE FROM workout_session_sets WHERE id = $1 AND session_id = $2`, setID, sessionID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(`UPDATE workout_sessions SET last_activity_at = CURRENT_TIMESTAMP WHERE id = $1`, sessionID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetRestTimer starts a rest timer of targetSeconds (nil for an open-ended
+// rest) from now, or stops the running one when running is false.
+func (pg *PostgresSessionStore) SetRestTimer(sessionID int64, targetSeconds *int, running bool) error {
+	query := `
+	UPDATE workout_sessions
+	SET rest_started_at = CASE WHEN $1 THEN CURRENT_TIMESTAMP END,
+		rest_target_seconds = CASE WHEN $1 THEN $2::integer END,
+		last_activity_at = CURRENT_TIMESTAMP
+	WHERE id = $3 AND status = 'active'
+	`
+	result, err := pg.db.Exec(query, running, targetSeconds, sessionID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrSessionNotActive
+	}
+	return nil
+}
+
+// FinishSession converts an active session into a Workout and links the two,
+// all in one transaction so a session is never finished twice.
+func (pg *PostgresSessionStore) FinishSession(id int64, finish SessionFinish) (*Workout, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	session, err := lockActiveSession(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	session.Sets, err = loadSessionSets(tx, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(session.Sets) == 0 {
+		return nil, ErrEmptySession
+	}
+
+	workout := session.toWorkout(time.Now(), finish)
+	if err := workout.NormalizeEntries(); err != nil {
+		return nil, err
+	}
+	if err := workout.NormalizeTimes(); err != nil {
+		return nil, err
+	}
+
+	err = insertWorkout(tx, workout)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+	UPDATE workout_sessions
+	SET status = $1, workout_id = $2, ended_at = $3, last_activity_at = $3, rest_started_at = NULL, rest_target_seconds = NULL
+	WHERE id = $4
+	`, WorkoutSessionFinished, workout.ID, *workout.EndedAt, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return workout, nil
+}
+
+func (pg *PostgresSessionStore) AbandonSession(id int64) error {
+	query := `
+	UPDATE workout_sessions
+	SET status = $1, ended_at = CURRENT_TIMESTAMP, rest_started_at = NULL, rest_target_seconds = NULL
+	WHERE id = $2 AND status = 'active'
+	`
+	result, err := pg.db.Exec(query, WorkoutSessionAbandoned, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrSessionNotActive
+	}
+	return nil
+}
+
+// AbandonStaleSessions abandons the active sessions nothing was logged to
+// for staleAfter, keeping their sets, and reports how many there were.
+func (pg *PostgresSessionStore) AbandonStaleSessions(staleAfter time.Duration) (int64, error) {
+	query := `
+	UPDATE workout_sessions
+	SET status = $1, ended_at = last_activity_at, rest_started_at = NULL, rest_target_seconds = NULL
+	WHERE status = 'active' AND last_activity_at < $2
+	`
+	result, err := pg.db.Exec(query, WorkoutSessionAbandoned, time.Now().Add(-staleAfter))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
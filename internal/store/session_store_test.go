@@ -0,0 +1,75 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionToWorkout(t *testing.T) {
+	startedAt := time.Date(2025, 6, 10, 18, 0, 0, 0, time.UTC)
+	benchID := 3
+	session := &WorkoutSession{
+		UserID:    1,
+		Title:     "Push",
+		Timezone:  "Europe/Berlin",
+		StartedAt: startedAt,
+		Sets: []SessionSet{
+			{ExerciseID: &benchID, ExerciseName: "Bench Press", SetType: SetTypeWarmup, Reps: IntPtr(10), Weight: FloatPtr(40)},
+			{ExerciseName: "Dips", SetType: SetTypeWorking, Reps: IntPtr(12)},
+			{ExerciseID: &benchID, ExerciseName: "bench", SetType: SetTypeWorking, Reps: IntPtr(5), Weight: FloatPtr(80)},
+			{ExerciseName: " dips ", SetType: SetTypeWorking, Reps: IntPtr(10)},
+		},
+	}
+
+	title := "Push day"
+	workout := session.toWorkout(startedAt.Add(62*time.Minute), SessionFinish{Title: &title, CaloriesBurned: 300})
+	require.NoError(t, workout.NormalizeEntries())
+	require.NoError(t, workout.NormalizeTimes())
+
+	assert.Equal(t, "Push day", workout.Title)
+	assert.Equal(t, 300, workout.CaloriesBurned)
+	assert.Equal(t, startedAt, workout.PerformedAt)
+	assert.Equal(t, 62, workout.DurationMinutes)
+	assert.Equal(t, "Europe/Berlin", workout.Timezone)
+
+	require.Len(t, workout.Entries, 2)
+	assert.Equal(t, "Bench Press", workout.Entries[0].ExerciseName)
+	assert.Equal(t, 2, workout.Entries[0].Sets)
+	assert.Equal(t, 80.0, *workout.Entries[0].Weight)
+	assert.Equal(t, "Dips", workout.Entries[1].ExerciseName)
+	assert.Equal(t, 2, workout.Entries[1].OrderIndex)
+	assert.Equal(t, 2, workout.Entries[1].Sets)
+}
+
+func TestNewRestTimer(t *testing.T) {
+	startedAt := time.Date(2025, 6, 10, 18, 0, 0, 0, time.UTC)
+
+	assert.Nil(t, newRestTimer(nil, IntPtr(90), startedAt))
+
+	timer := newRestTimer(&startedAt, IntPtr(90), startedAt.Add(30*time.Second))
+	require.NotNil(t, timer.RemainingSeconds)
+	assert.Equal(t, 60, *timer.RemainingSeconds)
+	assert.Equal(t, startedAt.Add(90*time.Second), *timer.EndsAt)
+
+	timer = newRestTimer(&startedAt, IntPtr(90), startedAt.Add(5*time.Minute))
+	assert.Equal(t, 0, *timer.RemainingSeconds)
+
+	timer = newRestTimer(&startedAt, nil, startedAt.Add(time.Minute))
+	assert.Nil(t, timer.EndsAt)
+}
+
+func TestSessionSetValidate(t *testing.T) {
+	set := SessionSet{ExerciseName: "Squat", Reps: IntPtr(5)}
+	require.NoError(t, set.Validate())
+	assert.Equal(t, SetTypeWorking, set.SetType)
+
+	assert.ErrorIs(t, (&SessionSet{Reps: IntPtr(5)}).Validate(), ErrInvalidWorkoutSet)
+	assert.ErrorIs(t, (&SessionSet{ExerciseName: "Plank", Reps: IntPtr(5), DurationSeconds: IntPtr(60)}).Validate(), ErrInvalidWorkoutSet)
+	assert.ErrorIs(t, (&SessionSet{ExerciseName: strings.Repeat("a", 256), Reps: IntPtr(5)}).Validate(), ErrInvalidWorkoutSet)
+	clientID := strings.Repeat("a", 65)
+	assert.ErrorIs(t, (&SessionSet{ClientID: &clientID, ExerciseName: "Squat", Reps: IntPtr(5)}).Validate(), ErrInvalidWorkoutSet)
+}
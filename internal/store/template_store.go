@@ -0,0 +1,423 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+)
+
+// WorkoutTemplate is a reusable workout plan. Its entries use the same shape
+// as a logged workout, with the sets acting as targets.
+type WorkoutTemplate struct {
+	ID              int            `json:"id"`
+	UserID          int            `json:"user_id"`
+	Name            string         `json:"name"`
+	Description     string         `json:"description"`
+	DurationMinutes int            `json:"duration_minutes"`
+	Entries         []WorkoutEntry `json:"entries"`
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+}
+
+// Progression describes the progressive overload applied when a template is
+// turned into a workout. Warm-up sets are left untouched.
+type Progression struct {
+	WeightIncrement   float64 `json:"weight_increment"`
+	WeightPercent     float64 `json:"weight_percent"`
+	RepsIncrement     int     `json:"reps_increment"`
+	DurationIncrement int     `json:"duration_increment"`
+}
+
+var ErrInvalidProgression = errors.New("progression cannot make weight, reps or duration negative")
+
+// NewWorkoutFromTemplate copies a template into a fresh, unsaved workout,
+// applying progression to every working set.
+func NewWorkoutFromTemplate(template *WorkoutTemplate, progression Progression) (*Workout, error) {
+	templateID := template.ID
+	workout := &Workout{
+		UserID:          template.UserID,
+		Title:           template.Name,
+		Description:     template.Description,
+		DurationMinutes: template.DurationMinutes,
+		TemplateID:      &templateID,
+		Entries:         make([]WorkoutEntry, 0, len(template.Entries)),
+	}
+
+	for _, templateEntry := range template.Entries {
+		entry := templateEntry
+		entry.ID = 0
+		entry.SetDetails = make([]WorkoutSet, 0, len(templateEntry.SetDetails))
+		for _, templateSet := range templateEntry.SetDetails {
+			set, err := templateSet.progress(progression)
+			if err != nil {
+				return nil, err
+			}
+			entry.SetDetails = append(entry.SetDetails, set)
+		}
+		workout.Entries = append(workout.Entries, entry)
+	}
+
+	if err := workout.NormalizeEntries(); err != nil {
+		return nil, err
+	}
+	return workout, nil
+}
+
+// NewTemplateFromWorkout captures the entries of a logged workout as a template.
+func NewTemplateFromWorkout(workout *Workout, name string) *WorkoutTemplate {
+	if name == "" {
+		name = workout.Title
+	}
+	template := &WorkoutTemplate{
+		UserID:          workout.UserID,
+		Name:            name,
+		Description:     workout.Description,
+		DurationMinutes: workout.DurationMinutes,
+		Entries:         make([]WorkoutEntry, 0, len(workout.Entries)),
+	}
+	for _, workoutEntry := range workout.Entries {
+		entry := workoutEntry
+		entry.ID = 0
+		entry.SetDetails = make([]WorkoutSet, 0, len(workoutEntry.SetDetails))
+		for _, set := range workoutEntry.SetDetails {
+			set.ID = 0
+			entry.SetDetails = append(entry.SetDetails, set)
+		}
+		template.Entries = append(template.Entries, entry)
+	}
+	return template
+}
+
+func (s WorkoutSet) progress(p Progression) (WorkoutSet, error) {
+	s.ID = 0
+	if s.SetType == SetTypeWarmup {
+		return s, nil
+	}
+
+	if s.Weight != nil {
+		weight := (*s.Weight + p.WeightIncrement) * (1 + p.WeightPercent/100)
+		weight = math.Round(weight*100) / 100
+		if weight < 0 {
+			return s, ErrInvalidProgression
+		}
+		s.Weight = &weight
+	}
+	if s.Reps != nil {
+		reps := *s.Reps + p.RepsIncrement
+		if reps < 0 {
+			return s, ErrInvalidProgression
+		}
+		s.Reps = &reps
+	}
+	if s.DurationSeconds != nil {
+		duration := *s.DurationSeconds + p.DurationIncrement
+		if duration < 0 {
+			return s, ErrInvalidProgression
+		}
+		s.DurationSeconds = &duration
+	}
+	return s, nil
+}
+
+type PostgresTemplateStore struct {
+	db *sql.DB
+}
+
+func NewPostgresTemplateStore(db *sql.DB) *PostgresTemplateStore {
+	return &PostgresTemplateStore{db: db}
+}
+
+type TemplateStore interface {
+	CreateTemplate(*WorkoutTemplate) error
+	GetTemplateByID(id int64) (*WorkoutTemplate, error)
+	ListTemplates(userID int) ([]*WorkoutTemplate, error)
+	UpdateTemplate(*WorkoutTemplate) error
+	DeleteTemplate(id int64) error
+	GetTemplateOwner(id int64) (int, error)
+}
+
+func (pg *PostgresTemplateStore) CreateTemplate(template *WorkoutTemplate) error {
+	err := normalizeTemplateEntries(template)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO workout_templates (user_id, name, description, duration_minutes)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, updated_at
+	`
+	err = tx.QueryRow(query, template.UserID, template.Name, template.Description, template.DurationMinutes).Scan(&template.ID, &template.CreatedAt, &template.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	err = resolveEntryExercises(tx, template.UserID, template.Entries)
+	if err != nil {
+		return err
+	}
+	err = insertTemplateEntries(tx, template)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (pg *PostgresTemplateStore) GetTemplateByID(id int64) (*WorkoutTemplate, error) {
+	template := &WorkoutTemplate{Entries: []WorkoutEntry{}}
+	query := `
+	SELECT id, user_id, name, description, duration_minutes, created_at, updated_at
+	FROM workout_templates
+	WHERE id = $1
+	`
+	err := pg.db.QueryRow(query, id).Scan(&template.ID, &template.UserID, &template.Name, &template.Description, &template.DurationMinutes, &template.CreatedAt, &template.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = pg.loadTemplateEntries([]*WorkoutTemplate{template})
+	if err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+func (pg *PostgresTemplateStore) ListTemplates(userID int) ([]*WorkoutTemplate, error) {
+	query := `
+	SELECT id, user_id, name, description, duration_minutes, created_at, updated_at
+	FROM workout_templates
+	WHERE user_id = $1
+	ORDER BY name, id
+	`
+	rows, err := pg.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []*WorkoutTemplate{}
+	for rows.Next() {
+		template := &WorkoutTemplate{Entries: []WorkoutEntry{}}
+		err := rows.Scan(&template.ID, &template.UserID, &template.Name, &template.Description, &template.DurationMinutes, &template.CreatedAt, &template.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = pg.loadTemplateEntries(templates)
+	if err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func (pg *PostgresTemplateStore) UpdateTemplate(template *WorkoutTemplate) error {
+	err := normalizeTemplateEntries(template)
+	if err != nil {
+		return err
+	}
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE workout_templates
+	SET name = $1, description = $2, duration_minutes = $3, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $4
+	RETURNING updated_at
+	`
+	err = tx.QueryRow(query, template.Name, template.Description, template.DurationMinutes, template.ID).Scan(&template.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM template_entries WHERE template_id = $1`, template.ID)
+	if err != nil {
+		return err
+	}
+
+	err = resolveEntryExercises(tx, template.UserID, template.Entries)
+	if err != nil {
+		return err
+	}
+	err = insertTemplateEntries(tx, template)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (pg *PostgresTemplateStore) DeleteTemplate(id int64) error {
+	result, err := pg.db.Exec(`DELETE FROM workout_templates WHERE id = $1`, id)
+	if isForeignKeyViolation(err) {
+		return ErrTemplateInUse
+	}
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (pg *PostgresTemplateStore) GetTemplateOwner(id int64) (int, error) {
+	var userID int
+	err := pg.db.QueryRow(`SELECT user_id FROM workout_templates WHERE id = $1`, id).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// normalizeTemplateEntries applies the same set rules as logged workouts.
+func normalizeTemplateEntries(template *WorkoutTemplate) error {
+	workout := &Workout{Entries: template.Entries}
+	return workout.NormalizeEntries()
+}
+
+func insertTemplateEntries(tx *sql.Tx, template *WorkoutTemplate) error {
+	entryQuery := `
+	INSERT INTO template_entries (template_id, exercise_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id
+	`
+	setQuery := `
+	INSERT INTO template_sets (entry_id, set_index, set_type, reps, duration_seconds, weight, rpe, rir, rest_seconds)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id
+	`
+
+	for i := range template.Entries {
+		entry := &template.Entries[i]
+		err := tx.QueryRow(entryQuery, template.ID, entry.ExerciseID, entry.ExerciseName, entry.Sets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex).Scan(&entry.ID)
+		if err != nil {
+			return err
+		}
+
+		for j := range entry.SetDetails {
+			set := &entry.SetDetails[j]
+			err := tx.QueryRow(setQuery, entry.ID, set.SetIndex, set.SetType, set.Reps, set.DurationSeconds, set.Weight, set.RPE, set.RIR, set.RestSeconds).Scan(&set.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadTemplateEntries fetches the entries and target sets of every given
+// template with one query per table.
+func (pg *PostgresTemplateStore) loadTemplateEntries(templates []*WorkoutTemplate) error {
+	if len(templates) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(templates))
+	byID := make(map[int]*WorkoutTemplate, len(templates))
+	for _, template := range templates {
+		ids = append(ids, int64(template.ID))
+		byID[template.ID] = template
+	}
+
+	query := `
+	SELECT template_id, id, exercise_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index
+	FROM template_entries
+	WHERE template_id = ANY($1)
+	ORDER BY template_id, order_index
+	`
+	rows, err := pg.db.Query(query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var templateID int
+		var entry WorkoutEntry
+		err := rows.Scan(
+			&templateID,
+			&entry.ID,
+			&entry.ExerciseID,
+			&entry.ExerciseName,
+			&entry.Sets,
+			&entry.Reps,
+			&entry.DurationSeconds,
+			&entry.Weight,
+			&entry.Notes,
+			&entry.OrderIndex,
+		)
+		if err != nil {
+			return err
+		}
+		if template, ok := byID[templateID]; ok {
+			template.Entries = append(template.Entries, entry)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var entries []*WorkoutEntry
+	entryByID := map[int]*WorkoutEntry{}
+	for _, template := range templates {
+		for i := range template.Entries {
+			entries = append(entries, &template.Entries[i])
+			entryByID[template.Entries[i].ID] = &template.Entries[i]
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	entryIDs := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		entryIDs = append(entryIDs, int64(entry.ID))
+	}
+
+	setQuery := `
+	SELECT entry_id, id, set_index, set_type, reps, duration_seconds, weight, rpe, rir, rest_seconds
+	FROM template_sets
+	WHERE entry_id = ANY($1)
+	ORDER BY entry_id, set_index
+	`
+	setRows, err := pg.db.Query(setQuery, entryIDs)
+	if err != nil {
+		return err
+	}
+	defer setRows.Close()
+
+	for setRows.Next() {
+		var entryID int
+		var set WorkoutSet
+		err := setRows.Scan(&entryID, &set.ID, &set.SetIndex, &set.SetType, &set.Reps, &set.DurationSeconds, &set.Weight, &set.RPE, &set.RIR, &set.RestSeconds)
+		if err != nil {
+			return err
+		}
+		if entry, ok := entryByID[entryID]; ok {
+			entry.SetDetails = append(entry.SetDetails, set)
+		}
+	}
+	return setRows.Err()
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewWorkoutFromTemplate(t *testing.T) {
+	template := &WorkoutTemplate{
+		ID:     4,
+		UserID: 1,
+		Name:   "Push A",
+		Entries: []WorkoutEntry{
+			{
+				ExerciseName: "Bench Press",
+				SetDetails: []WorkoutSet{
+					{ID: 10, SetType: SetTypeWarmup, Reps: IntPtr(10), Weight: FloatPtr(40)},
+					{ID: 11, SetType: SetTypeWorking, Reps: IntPtr(5), Weight: FloatPtr(80)},
+				},
+			},
+			{
+				ExerciseName: "Plank",
+				SetDetails:   []WorkoutSet{{SetType: SetTypeWorking, DurationSeconds: IntPtr(60)}},
+			},
+		},
+	}
+
+	workout, err := NewWorkoutFromTemplate(template, Progression{WeightIncrement: 2.5, RepsIncrement: 1, DurationIncrement: 15})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Push A", workout.Title)
+	require.NotNil(t, workout.TemplateID)
+	assert.Equal(t, 4, *workout.TemplateID)
+
+	bench := workout.Entries[0]
+	assert.Equal(t, 40.0, *bench.SetDetails[0].Weight, "warm-up sets are not progressed")
+	assert.Equal(t, 82.5, *bench.SetDetails[1].Weight)
+	assert.Equal(t, 6, *bench.SetDetails[1].Reps)
+	assert.Zero(t, bench.SetDetails[1].ID)
+	assert.Equal(t, 75, *workout.Entries[1].SetDetails[0].DurationSeconds)
+
+	// the template itself is left untouched
+	assert.Equal(t, 80.0, *template.Entries[0].SetDetails[1].Weight)
+
+	_, err = NewWorkoutFromTemplate(template, Progression{RepsIncrement: -20})
+	assert.ErrorIs(t, err, ErrInvalidProgression)
+}
+
+func TestTemplateStore(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db, "planner")
+	templateStore := NewPostgresTemplateStore(db)
+
+	var id int64
+	err := db.QueryRow(`INSERT INTO workout_templates (user_id, name) VALUES ($1, 'rest day') RETURNING id`, user.ID).Scan(&id)
+	require.NoError(t, err)
+
+	t.Run("templates without entries list none", func(t *testing.T) {
+		template, err := templateStore.GetTemplateByID(id)
+		require.NoError(t, err)
+		require.NotNil(t, template.Entries)
+		assert.Empty(t, template.Entries)
+	})
+
+	t.Run("sets are checked like workout sets", func(t *testing.T) {
+		var entryID int64
+		err := db.QueryRow(`INSERT INTO template_entries (template_id, exercise_name, sets, order_index) VALUES ($1, 'Plank', 1, 1) RETURNING id`, id).Scan(&entryID)
+		require.NoError(t, err)
+
+		_, err = db.Exec(`INSERT INTO template_sets (entry_id, set_index, reps, duration_seconds) VALUES ($1, 1, 5, 60)`, entryID)
+		assert.Error(t, err, "reps and a duration")
+		_, err = db.Exec(`INSERT INTO template_sets (entry_id, set_index) VALUES ($1, 1)`, entryID)
+		assert.Error(t, err, "neither reps nor a duration")
+		_, err = db.Exec(`INSERT INTO template_sets (entry_id, set_index, reps, rpe) VALUES ($1, 1, 5, 11)`, entryID)
+		assert.Error(t, err, "rpe out of range")
+		_, err = db.Exec(`INSERT INTO template_sets (entry_id, set_index, duration_seconds, rpe) VALUES ($1, 1, 60, 8)`, entryID)
+		assert.NoError(t, err)
+	})
+}
@@ -1,10 +1,13 @@
 package store
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/tokens"
+	"github.com/jackc/pgtype"
 )
 
 type PostgresTokenStore struct {
@@ -17,10 +20,45 @@ func NewPostgresTokenStore(db *sql.DB) *PostgresTokenStore {
 	}
 }
 
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrRefreshTokenReused means an already rotated refresh token was
+	// presented again, so it has most likely been stolen. The whole family
+	// has been revoked by the time it is returned.
+	ErrRefreshTokenReused = errors.New("refresh token has already been used")
+)
+
+// TokenInfo describes an issued token without its secret, eg. to list the
+// devices a user is signed in on.
+type TokenInfo struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name,omitempty"`
+	Scopes     []string   `json:"scopes,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     *time.Time `json:"expiry"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	Current    bool       `json:"current"`
+}
+
+// tokenUsageInterval limits how often the usage of a token is written back,
+// so busy clients do not turn every request into an UPDATE.
+const tokenUsageInterval = time.Minute
+
 type TokenStore interface {
 	Insert(token *tokens.Token) error
 	CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error)
 	DeleteAllTokensForUser(userID int, scope string) error
+	RecordTokenUsage(tokenPlainText, userAgent, ip string) (*TokenInfo, error)
+	ListTokens(userID int, scope string) ([]*TokenInfo, error)
+	DeleteToken(userID int, scope string, id int64) error
+	CreateTokenPair(userID int, accessTTL, refreshTTL time.Duration) (access, refresh *tokens.Token, err error)
+	RotateRefreshToken(refreshPlainText string, accessTTL, refreshTTL time.Duration) (access, refresh *tokens.Token, err error)
+	DeleteExpiredTokens() (int64, error)
+	DeleteOtherSessions(userID int, keepTokenID int) error
+	ListSessions(userID int, currentTokenID int) ([]*TokenInfo, error)
+	DeleteSession(userID int, sessionID int64) error
 }
 
 func (t *PostgresTokenStore) CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error) {
@@ -34,13 +72,165 @@ func (t *PostgresTokenStore) CreateNewToken(userID int, ttl time.Duration, scope
 }
 
 func (t *PostgresTokenStore) Insert(token *tokens.Token) error {
+	return insertToken(t.db, token)
+}
+
+func insertToken(q queryRower, token *tokens.Token) error {
 	query := `
-	INSERT INTO tokens (hash, user_id , expiry, scope)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO tokens (hash, user_id , expiry, scope, family_id, name, access_scopes)
+	VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6, $7)
+	RETURNING id
 	`
 
-	_, err := t.db.Exec(query, token.Hash, token.UserID, token.Expiry, token.Scope)
-	return err
+	var expiry *time.Time
+	if !token.Expiry.IsZero() {
+		expiry = &token.Expiry
+	}
+	var accessScopes []string
+	if len(token.AccessScopes) > 0 {
+		accessScopes = token.AccessScopes
+	}
+	return q.QueryRow(query, token.Hash, token.UserID, expiry, token.Scope, token.FamilyID, token.Name, accessScopes).Scan(&token.ID)
+}
+
+// insertTokenPair issues an access and a refresh token in family.
+func insertTokenPair(q queryRower, userID int, familyID int64, accessTTL, refreshTTL time.Duration) (*tokens.Token, *tokens.Token, error) {
+	access, err := tokens.GenerateToken(userID, accessTTL, tokens.ScopeAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh, err := tokens.GenerateToken(userID, refreshTTL, tokens.ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*tokens.Token{access, refresh} {
+		token.FamilyID = familyID
+		err = insertToken(q, token)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return access, refresh, nil
+}
+
+// CreateTokenPair signs a user in: it starts a new token family with an
+// access token and the refresh token to renew it.
+func (t *PostgresTokenStore) CreateTokenPair(userID int, accessTTL, refreshTTL time.Duration) (*tokens.Token, *tokens.Token, error) {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var familyID int64
+	err = tx.QueryRow(`SELECT nextval('token_family_seq')`).Scan(&familyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := insertTokenPair(tx, userID, familyID, accessTTL, refreshTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, tx.Commit()
+}
+
+// RotateRefreshToken trades a refresh token for a new access and refresh
+// token in the same family. The old refresh token is kept, marked as
+// rotated, until it expires so that presenting it again is recognised as
+// reuse; that revokes the whole family and returns ErrRefreshTokenReused.
+// The family's previous access tokens are revoked on rotation, leaving one
+// live access token per sign-in.
+func (t *PostgresTokenStore) RotateRefreshToken(refreshPlainText string, accessTTL, refreshTTL time.Duration) (*tokens.Token, *tokens.Token, error) {
+	tokenHash := sha256.Sum256([]byte(refreshPlainText))
+
+	tx, err := t.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	SELECT user_id, family_id, expiry, rotated_at
+	FROM tokens
+	WHERE hash = $1 AND scope = $2
+	FOR UPDATE
+	`
+	var (
+		userID    int
+		familyID  sql.NullInt64
+		expiry    time.Time
+		rotatedAt sql.NullTime
+	)
+	err = tx.QueryRow(query, tokenHash[:], tokens.ScopeRefresh).Scan(&userID, &familyID, &expiry, &rotatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if !familyID.Valid || !expiry.After(time.Now()) {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	if rotatedAt.Valid {
+		_, err = tx.Exec(`DELETE FROM tokens WHERE family_id = $1`, familyID.Int64)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	_, err = tx.Exec(`UPDATE tokens SET rotated_at = $1 WHERE hash = $2`, time.Now(), tokenHash[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the new pair continues the same session, so it keeps when it started
+	// and where it was last used
+	var (
+		createdAt  time.Time
+		lastUsedAt sql.NullTime
+		userAgent  string
+		ip         string
+	)
+	err = tx.QueryRow(`SELECT `+sessionUsageColumns+` FROM tokens WHERE family_id = $1`, familyID.Int64).
+		Scan(&createdAt, &lastUsedAt, &userAgent, &ip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM tokens WHERE family_id = $1 AND scope = $2`, familyID.Int64, tokens.ScopeAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := insertTokenPair(tx, userID, familyID.Int64, accessTTL, refreshTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = tx.Exec(`UPDATE tokens SET created_at = $2, last_used_at = $3, user_agent = $4, ip = $5 WHERE id IN ($1, $6)`,
+		access.ID, createdAt, lastUsedAt, userAgent, ip, refresh.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return access, refresh, tx.Commit()
+}
+
+// DeleteExpiredTokens removes the tokens past their expiry, which includes
+// rotated refresh tokens once they can no longer be replayed, and returns
+// how many were removed.
+func (t *PostgresTokenStore) DeleteExpiredTokens() (int64, error) {
+	result, err := t.db.Exec(`DELETE FROM tokens WHERE expiry <= $1`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 func (t *PostgresTokenStore) DeleteAllTokensForUser(userID int, scope string) error {
@@ -51,3 +241,158 @@ func (t *PostgresTokenStore) DeleteAllTokensForUser(userID int, scope string) er
 	_, err := t.db.Exec(query, scope, userID)
 	return err
 }
+
+// RecordTokenUsage notes when, from where and by what client a token was
+// last used. It returns the token's id and, for personal access tokens, its
+// scopes.
+func (t *PostgresTokenStore) RecordTokenUsage(tokenPlainText, userAgent, ip string) (*TokenInfo, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+	userAgent = truncateText(userAgent, maxUserAgentLength)
+
+	query := `
+	WITH used AS (
+		UPDATE tokens
+		SET last_used_at = $4, user_agent = $2, ip = $3
+		WHERE hash = $1
+			AND (last_used_at IS NULL OR last_used_at < $5 OR user_agent <> $2 OR ip <> $3)
+	)
+	SELECT id, access_scopes FROM tokens WHERE hash = $1
+	`
+	now := time.Now()
+	info := &TokenInfo{}
+	var scopes pgtype.TextArray
+	err := t.db.QueryRow(query, tokenHash[:], userAgent, ip, now, now.Add(-tokenUsageInterval)).Scan(&info.ID, &scopes)
+	if err != nil {
+		return nil, err
+	}
+	err = scopes.AssignTo(&info.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (t *PostgresTokenStore) ListTokens(userID int, scope string) ([]*TokenInfo, error) {
+	query := `
+	SELECT id, name, access_scopes, created_at, last_used_at, expiry, user_agent, ip
+	FROM tokens
+	WHERE user_id = $1 AND scope = $2 AND (expiry IS NULL OR expiry > $3)
+	ORDER BY last_used_at DESC NULLS LAST, created_at DESC
+	`
+	rows, err := t.db.Query(query, userID, scope, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	infos := []*TokenInfo{}
+	for rows.Next() {
+		info := &TokenInfo{}
+		var scopes pgtype.TextArray
+		err := rows.Scan(&info.ID, &info.Name, &scopes, &info.CreatedAt, &info.LastUsedAt, &info.Expiry, &info.UserAgent, &info.IP)
+		if err != nil {
+			return nil, err
+		}
+		err = scopes.AssignTo(&info.Scopes)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, rows.Err()
+}
+
+// sessionUsageColumns aggregate the tokens of a family into when its sign in
+// started and when, from where and by what client it was last used.
+const sessionUsageColumns = `
+	MIN(created_at),
+	MAX(last_used_at),
+	COALESCE((ARRAY_AGG(user_agent ORDER BY last_used_at DESC NULLS LAST, id DESC))[1], ''),
+	COALESCE((ARRAY_AGG(ip ORDER BY last_used_at DESC NULLS LAST, id DESC))[1], '')`
+
+// ListSessions lists the sign ins of a user that can still be used or
+// refreshed, one per token family. Their id is the family's, which stays
+// the same however often the tokens are rotated. currentTokenID marks the
+// session of the request.
+func (t *PostgresTokenStore) ListSessions(userID int, currentTokenID int) ([]*TokenInfo, error) {
+	query := `
+	SELECT family_id,` + sessionUsageColumns + `,
+		MAX(expiry) FILTER (WHERE rotated_at IS NULL),
+		BOOL_OR(id = $4)
+	FROM tokens
+	WHERE user_id = $1 AND scope IN ($2, $3) AND family_id IS NOT NULL
+	GROUP BY family_id
+	HAVING BOOL_OR(rotated_at IS NULL AND expiry > $5)
+	ORDER BY MAX(last_used_at) DESC NULLS LAST, MIN(created_at) DESC
+	`
+	rows, err := t.db.Query(query, userID, tokens.ScopeAuth, tokens.ScopeRefresh, currentTokenID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	infos := []*TokenInfo{}
+	for rows.Next() {
+		info := &TokenInfo{}
+		err := rows.Scan(&info.ID, &info.CreatedAt, &info.LastUsedAt, &info.UserAgent, &info.IP, &info.Expiry, &info.Current)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, rows.Err()
+}
+
+// DeleteSession signs a user out of the session listed by ListSessions,
+// revoking its access and refresh tokens.
+func (t *PostgresTokenStore) DeleteSession(userID int, sessionID int64) error {
+	result, err := t.db.Exec(`DELETE FROM tokens WHERE user_id = $1 AND family_id = $2`, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteOtherSessions signs the user out everywhere but on the sign in the
+// token keepTokenID belongs to: access, refresh and pending second factor
+// tokens outside of its family are revoked.
+func (t *PostgresTokenStore) DeleteOtherSessions(userID int, keepTokenID int) error {
+	query := `
+	DELETE FROM tokens
+	WHERE user_id = $1 AND scope IN ($3, $4, $5) AND id <> $2
+		AND (family_id IS NULL OR family_id IS DISTINCT FROM (SELECT family_id FROM tokens WHERE id = $2 AND user_id = $1))
+	`
+	_, err := t.db.Exec(query, userID, keepTokenID, tokens.ScopeAuth, tokens.ScopeRefresh, tokens.ScopeMFAChallenge)
+	return err
+}
+
+// DeleteToken revokes a token together with the rest of its family, so a
+// signed out device cannot refresh its way back in.
+func (t *PostgresTokenStore) DeleteToken(userID int, scope string, id int64) error {
+	query := `
+	DELETE FROM tokens
+	WHERE user_id = $2 AND (
+		(id = $1 AND scope = $3)
+		OR family_id = (SELECT family_id FROM tokens WHERE id = $1 AND user_id = $2 AND scope = $3)
+	)
+	`
+	result, err := t.db.Exec(query, id, userID, scope)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
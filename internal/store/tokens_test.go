@@ -0,0 +1,235 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateRefreshToken(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db, "rotating")
+	tokenStore := NewPostgresTokenStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	signedIn := func(scope, plainText string) bool {
+		u, err := userStore.GetUserToken(scope, plainText)
+		require.NoError(t, err)
+		return u != nil
+	}
+
+	access, refresh, err := tokenStore.CreateTokenPair(user.ID, time.Minute, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, access.FamilyID, refresh.FamilyID)
+
+	t.Run("rotation issues a new pair", func(t *testing.T) {
+		newAccess, newRefresh, err := tokenStore.RotateRefreshToken(refresh.PlainText, time.Minute, time.Hour)
+		require.NoError(t, err)
+		assert.NotEqual(t, refresh.PlainText, newRefresh.PlainText)
+		assert.Equal(t, refresh.FamilyID, newRefresh.FamilyID)
+		assert.True(t, signedIn(tokens.ScopeAuth, newAccess.PlainText))
+		assert.False(t, signedIn(tokens.ScopeAuth, access.PlainText), "the family's previous access token is revoked")
+
+		access, refresh = newAccess, newRefresh
+	})
+
+	t.Run("replaying a rotated token revokes the family", func(t *testing.T) {
+		rotated := refresh
+		_, next, err := tokenStore.RotateRefreshToken(rotated.PlainText, time.Minute, time.Hour)
+		require.NoError(t, err)
+
+		_, _, err = tokenStore.RotateRefreshToken(rotated.PlainText, time.Minute, time.Hour)
+		assert.ErrorIs(t, err, ErrRefreshTokenReused)
+
+		_, _, err = tokenStore.RotateRefreshToken(next.PlainText, time.Minute, time.Hour)
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken, "the live refresh token went with the family")
+		assert.False(t, signedIn(tokens.ScopeRefresh, next.PlainText))
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		_, _, err := tokenStore.RotateRefreshToken("NOTATOKEN", time.Minute, time.Hour)
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		_, expired, err := tokenStore.CreateTokenPair(user.ID, time.Minute, -time.Second)
+		require.NoError(t, err)
+		_, _, err = tokenStore.RotateRefreshToken(expired.PlainText, time.Minute, time.Hour)
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	})
+}
+
+func TestDeleteExpiredTokens(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db, "expiring")
+	tokenStore := NewPostgresTokenStore(db)
+
+	_, _, err := tokenStore.CreateTokenPair(user.ID, -time.Second, -time.Second)
+	require.NoError(t, err)
+	live, err := tokenStore.CreateNewToken(user.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+
+	deleted, err := tokenStore.DeleteExpiredTokens()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	infos, err := tokenStore.ListTokens(user.ID, tokens.ScopeAuth)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, live.ID, infos[0].ID)
+}
+
+func TestListTokens(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db, "listing")
+	other := createTestUser(t, db, "other")
+	tokenStore := NewPostgresTokenStore(db)
+
+	access, _, err := tokenStore.CreateTokenPair(user.ID, time.Minute, time.Hour)
+	require.NoError(t, err)
+	_, err = tokenStore.CreateNewToken(user.ID, -time.Second, tokens.ScopeAuth)
+	require.NoError(t, err)
+	_, err = tokenStore.CreateNewToken(other.ID, time.Minute, tokens.ScopeAuth)
+	require.NoError(t, err)
+
+	// a long user agent is cut down without splitting a character
+	userAgent := strings.Repeat("é", 200)
+	info, err := tokenStore.RecordTokenUsage(access.PlainText, userAgent, "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, access.ID, info.ID)
+
+	infos, err := tokenStore.ListTokens(user.ID, tokens.ScopeAuth)
+	require.NoError(t, err)
+	require.Len(t, infos, 1, "only the user's live tokens of the scope")
+	assert.Equal(t, access.ID, infos[0].ID)
+	assert.Equal(t, "192.0.2.1", infos[0].IP)
+	assert.Equal(t, strings.Repeat("é", 127), infos[0].UserAgent)
+	assert.NotNil(t, infos[0].LastUsedAt)
+}
+
+func TestDeleteToken(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db, "deleting")
+	other := createTestUser(t, db, "other")
+	tokenStore := NewPostgresTokenStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	access, refresh, err := tokenStore.CreateTokenPair(user.ID, time.Minute, time.Hour)
+	require.NoError(t, err)
+	kept, _, err := tokenStore.CreateTokenPair(user.ID, time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	err = tokenStore.DeleteToken(other.ID, tokens.ScopeAuth, int64(access.ID))
+	assert.ErrorIs(t, err, sql.ErrNoRows, "other users' tokens are out of reach")
+	err = tokenStore.DeleteToken(user.ID, tokens.ScopeRefresh, int64(access.ID))
+	assert.ErrorIs(t, err, sql.ErrNoRows, "the scope must match")
+
+	require.NoError(t, tokenStore.DeleteToken(user.ID, tokens.ScopeAuth, int64(access.ID)))
+	u, err := userStore.GetUserToken(tokens.ScopeRefresh, refresh.PlainText)
+	require.NoError(t, err)
+	assert.Nil(t, u, "the refresh token of the same sign in goes too")
+	u, err = userStore.GetUserToken(tokens.ScopeAuth, kept.PlainText)
+	require.NoError(t, err)
+	assert.NotNil(t, u, "other sessions stay signed in")
+
+	err = tokenStore.DeleteToken(user.ID, tokens.ScopeAuth, int64(access.ID))
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestDeleteOtherSessions(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db, "enrolling")
+	other := createTestUser(t, db, "other")
+	tokenStore := NewPostgresTokenStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	signedIn := func(scope, plainText string) bool {
+		u, err := userStore.GetUserToken(scope, plainText)
+		require.NoError(t, err)
+		return u != nil
+	}
+
+	current, currentRefresh, err := tokenStore.CreateTokenPair(user.ID, time.Hour, time.Hour)
+	require.NoError(t, err)
+	elsewhere, elsewhereRefresh, err := tokenStore.CreateTokenPair(user.ID, time.Hour, time.Hour)
+	require.NoError(t, err)
+	legacy, err := tokenStore.CreateNewToken(user.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+	personal, err := tokenStore.CreateNewToken(user.ID, time.Hour, tokens.ScopePersonalAccess)
+	require.NoError(t, err)
+	othersAccess, _, err := tokenStore.CreateTokenPair(other.ID, time.Hour, time.Hour)
+	require.NoError(t, err)
+
+	require.NoError(t, tokenStore.DeleteOtherSessions(user.ID, current.ID))
+
+	assert.True(t, signedIn(tokens.ScopeAuth, current.PlainText))
+	assert.True(t, signedIn(tokens.ScopeRefresh, currentRefresh.PlainText))
+	assert.False(t, signedIn(tokens.ScopeAuth, elsewhere.PlainText))
+	assert.False(t, signedIn(tokens.ScopeRefresh, elsewhereRefresh.PlainText))
+	assert.False(t, signedIn(tokens.ScopeAuth, legacy.PlainText), "tokens without a family are revoked")
+	assert.True(t, signedIn(tokens.ScopePersonalAccess, personal.PlainText))
+	assert.True(t, signedIn(tokens.ScopeAuth, othersAccess.PlainText))
+}
+
+func TestListSessions(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db, "sessions")
+	tokenStore := NewPostgresTokenStore(db)
+	userStore := NewPostgresUserStore(db)
+
+	// the access token is already expired, as after a device sat idle
+	access, refresh, err := tokenStore.CreateTokenPair(user.ID, -time.Second, time.Hour)
+	require.NoError(t, err)
+	_, err = tokenStore.RecordTokenUsage(access.PlainText, "phone", "192.0.2.1")
+	require.NoError(t, err)
+	other, _, err := tokenStore.CreateTokenPair(user.ID, time.Minute, time.Hour)
+	require.NoError(t, err)
+
+	sessions, err := tokenStore.ListSessions(user.ID, other.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2, "idle devices stay listed while they can refresh")
+	phone := sessions[0]
+	assert.Equal(t, int(access.FamilyID), phone.ID)
+	assert.Equal(t, "phone", phone.UserAgent)
+	assert.False(t, phone.Current)
+	assert.True(t, sessions[1].Current)
+
+	newAccess, _, err := tokenStore.RotateRefreshToken(refresh.PlainText, time.Minute, time.Hour)
+	require.NoError(t, err)
+	sessions, err = tokenStore.ListSessions(user.ID, newAccess.ID)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, phone.ID, sessions[0].ID, "rotation keeps the session")
+	assert.True(t, phone.CreatedAt.Equal(sessions[0].CreatedAt))
+	assert.Equal(t, "phone", sessions[0].UserAgent)
+	assert.Equal(t, "192.0.2.1", sessions[0].IP)
+	assert.NotNil(t, sessions[0].LastUsedAt)
+	assert.True(t, sessions[0].Current)
+
+	_, err = tokenStore.DeleteExpiredTokens()
+	require.NoError(t, err)
+	require.NoError(t, tokenStore.DeleteSession(user.ID, int64(phone.ID)))
+	u, err := userStore.GetUserToken(tokens.ScopeAuth, newAccess.PlainText)
+	require.NoError(t, err)
+	assert.Nil(t, u, "the session is signed out")
+	_, _, err = tokenStore.RotateRefreshToken(refresh.PlainText, time.Minute, time.Hour)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	assert.ErrorIs(t, tokenStore.DeleteSession(user.ID, int64(phone.ID)), sql.ErrNoRows)
+}
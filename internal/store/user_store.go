@@ -5,54 +5,42 @@ import (
 	"database/sql"
 	"errors"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	ID           int       `json:"id"`
-	Username     string    `json:"username"`
-	Email        string    `json:"email"`
-	PasswordHash password  `json:"-"`
-	Bio          string    `json:"bio"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID              int        `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	PasswordHash    password   `json:"-"`
+	Bio             string     `json:"bio"`
+	Activated       bool       `json:"activated"`
+	IsAdmin         bool       `json:"-"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
 var AnonymousUser = &User{}
 
-func (u *User) IsAnonymous() bool {
-	return u == AnonymousUser
-}
-
-type password struct {
-	plainText *string
-	hash      []byte
-}
+var (
+	ErrDuplicateUsername = errors.New("a user with this username already exists")
+	ErrDuplicateEmail    = errors.New("a user with this email already exists")
+)
 
-func (p *password) Set(plainTextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plainTextPassword), 12)
-	if err != nil {
-		return err
+// userConflict maps a unique violation on the users table onto the matching
+// ErrDuplicate error, returning err unchanged otherwise.
+func userConflict(err error) error {
+	switch uniqueViolationConstraint(err) {
+	case "users_username_key":
+		return ErrDuplicateUsername
+	case "users_email_key":
+		return ErrDuplicateEmail
 	}
-	p.plainText = &plainTextPassword
-	p.hash = hash
-
-	return nil
+	return err
 }
 
-func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
-		}
-	}
-
-	return true, nil
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
 }
 
 type PostgresUserStore struct {
@@ -68,7 +56,10 @@ func NewPostgresUserStore(db *sql.DB) *PostgresUserStore {
 type UserStore interface {
 	CreateUser(*User) error
 	GetUserByUsername(username string) (*User, error)
+	GetUserByEmail(email string) (*User, error)
 	UpdateUser(*User) error
+	UpdatePassword(*User) error
+	ActivateUser(*User) error
 	GetUserToken(scope, tokenPlainText string) (*User, error)
 }
 
@@ -77,12 +68,12 @@ func (s *PostgresUserStore) CreateUser(user *User) error {
 	query := `
 	INSERT INTO users (username, email, password_hash, bio)
 	VALUES ($1, $2, $3, $4)
-	RETURNING id, created_at, updated_at
+	RETURNING id, activated, created_at, updated_at
 	`
 
-	err := s.db.QueryRow(query, user.Username, user.Email, string(user.PasswordHash.hash), user.Bio).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	err := s.db.QueryRow(query, user.Username, user.Email, string(user.PasswordHash.hash), user.Bio).Scan(&user.ID, &user.Activated, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
-		return err
+		return userConflict(err)
 	}
 	return nil
 }
@@ -93,11 +84,11 @@ func (s *PostgresUserStore) GetUserByUsername(username string) (*User, error) {
 	}
 
 	query := `
-	SELECT id, username, email, password_hash, bio, created_at, updated_at
+	SELECT id, username, email, password_hash, bio, activated, is_admin, email_verified_at, created_at, updated_at
 	FROM users
 	WHERE username = $1
 	`
-	err := s.db.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash.hash, &user.Bio, &user.CreatedAt, &user.UpdatedAt)
+	err := s.db.QueryRow(query, username).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash.hash, &user.Bio, &user.Activated, &user.IsAdmin, &user.EmailVerifiedAt, &user.CreatedAt, &user.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -110,45 +101,88 @@ func (s *PostgresUserStore) GetUserByUsername(username string) (*User, error) {
 	return user, nil
 }
 
-func (s *PostgresUserStore) UpdateUser(user *User) error {
+func (s *PostgresUserStore) GetUserByEmail(email string) (*User, error) {
+	user := &User{
+		PasswordHash: password{},
+	}
 
 	query := `
-	UPDATE user
-	SET username = $1, email=$2, bio=$3, updated_at=CURRENT_TIMESTAMP
-	WHERE id= $4
-	RETURNING updated_at
+	SELECT id, username, email, password_hash, bio, activated, is_admin, email_verified_at, created_at, updated_at
+	FROM users
+	WHERE email = $1
 	`
+	err := s.db.QueryRow(query, email).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash.hash, &user.Bio, &user.Activated, &user.IsAdmin, &user.EmailVerifiedAt, &user.CreatedAt, &user.UpdatedAt)
 
-	result, err := s.db.Exec(query, user.Username, user.Email, user.Bio, user.ID)
-	if err != nil {
-		return err
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
-	rowsAffected, err := result.RowsAffected()
+
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	return user, nil
+}
+
+// UpdateUser saves the profile fields of user. Changing the email address
+// deactivates the account until the new address is verified.
+func (s *PostgresUserStore) UpdateUser(user *User) error {
+
+	query := `
+	UPDATE users
+	SET username = $1, email=$2, bio=$3, updated_at=CURRENT_TIMESTAMP,
+		activated = activated AND email = $2,
+		email_verified_at = CASE WHEN email = $2 THEN email_verified_at END
+	WHERE id= $4
+	RETURNING activated, email_verified_at, updated_at
+	`
+
+	err := s.db.QueryRow(query, user.Username, user.Email, user.Bio, user.ID).Scan(&user.Activated, &user.EmailVerifiedAt, &user.UpdatedAt)
+	if err != nil {
+		return userConflict(err)
 	}
 	return nil
 }
 
+// ActivateUser marks the user's email as verified.
+func (s *PostgresUserStore) ActivateUser(user *User) error {
+	query := `
+	UPDATE users
+	SET activated = true, email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $1
+	RETURNING activated, email_verified_at, updated_at
+	`
+
+	return s.db.QueryRow(query, user.ID).Scan(&user.Activated, &user.EmailVerifiedAt, &user.UpdatedAt)
+}
+
+// UpdatePassword stores the hash set through user.PasswordHash.Set.
+func (s *PostgresUserStore) UpdatePassword(user *User) error {
+	query := `
+	UPDATE users
+	SET password_hash = $1, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $2
+	RETURNING updated_at
+	`
+
+	return s.db.QueryRow(query, string(user.PasswordHash.hash), user.ID).Scan(&user.UpdatedAt)
+}
+
 func (s *PostgresUserStore) GetUserToken(scope, plainTextPassword string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(plainTextPassword))
 
 	query := `
-	SELECT u.id, u.username, u.email, u.password_hash, u.bio, u.created_at, u.updated_at
+	SELECT u.id, u.username, u.email, u.password_hash, u.bio, u.activated, u.is_admin, u.email_verified_at, u.created_at, u.updated_at
 	FROM users u
 	INNER JOIN tokens t ON t.user_id = u.id
-	WHERE t.hash = $1 AND t.scope = $2 AND t.expiry > $3
+	WHERE t.hash = $1 AND t.scope = $2 AND (t.expiry IS NULL OR t.expiry > $3)
 	`
 
 	user := &User{
 		PasswordHash: password{},
 	}
 
-	err := s.db.QueryRow(query, tokenHash[:], scope, time.Now()).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash.hash, &user.Bio, &user.CreatedAt, &user.UpdatedAt)
+	err := s.db.QueryRow(query, tokenHash[:], scope, time.Now()).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash.hash, &user.Bio, &user.Activated, &user.IsAdmin, &user.EmailVerifiedAt, &user.CreatedAt, &user.UpdatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createTestUser signs up username with the password "secret-password".
+func createTestUser(t *testing.T, db *sql.DB, username string) *User {
+	user := &User{Username: username, Email: username + "@example.com"}
+	require.NoError(t, user.PasswordHash.Set("secret-password"))
+	require.NoError(t, NewPostgresUserStore(db).CreateUser(user))
+	return user
+}
+
+func TestUserConflict(t *testing.T) {
+	assert.ErrorIs(t, userConflict(&pgconn.PgError{Code: "23505", ConstraintName: "users_username_key"}), ErrDuplicateUsername)
+	assert.ErrorIs(t, userConflict(&pgconn.PgError{Code: "23505", ConstraintName: "users_email_key"}), ErrDuplicateEmail)
+
+	other := errors.New("connection refused")
+	assert.Equal(t, other, userConflict(other))
+	assert.Nil(t, userConflict(nil))
+}
+
+func TestGetUserByEmail(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	created := createTestUser(t, db, "emailed")
+	userStore := NewPostgresUserStore(db)
+
+	user, err := userStore.GetUserByEmail("emailed@example.com")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, created.ID, user.ID)
+	assert.Equal(t, "emailed", user.Username)
+
+	user, err = userStore.GetUserByEmail("nobody@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+}
+
+func TestUpdatePassword(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db, "changing")
+	userStore := NewPostgresUserStore(db)
+
+	require.NoError(t, user.PasswordHash.Set("new-password"))
+	require.NoError(t, userStore.UpdatePassword(user))
+
+	stored, err := userStore.GetUserByUsername("changing")
+	require.NoError(t, err)
+	ok, err := stored.PasswordHash.Matches("new-password")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = stored.PasswordHash.Matches("secret-password")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, user.UpdatedAt, stored.UpdatedAt)
+}
+
+func TestUpdateUserDeactivatesOnEmailChange(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db, "moving")
+	userStore := NewPostgresUserStore(db)
+	assert.False(t, user.Activated, "new accounts start out inactive")
+	require.NoError(t, userStore.ActivateUser(user))
+	assert.True(t, user.Activated)
+	require.NotNil(t, user.EmailVerifiedAt)
+
+	user.Bio = "new bio"
+	require.NoError(t, userStore.UpdateUser(user))
+	assert.True(t, user.Activated, "the same address stays verified")
+	assert.NotNil(t, user.EmailVerifiedAt)
+
+	user.Email = "moved@example.com"
+	require.NoError(t, userStore.UpdateUser(user))
+	assert.False(t, user.Activated)
+	assert.Nil(t, user.EmailVerifiedAt)
+
+	stored, err := userStore.GetUserByUsername("moving")
+	require.NoError(t, err)
+	assert.False(t, stored.Activated)
+	assert.Equal(t, "moved@example.com", stored.Email)
+}
@@ -0,0 +1,228 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	SetTypeWarmup  = "warmup"
+	SetTypeWorking = "working"
+	SetTypeDrop    = "drop"
+	SetTypeFailure = "failure"
+)
+
+var validSetTypes = map[string]bool{
+	SetTypeWarmup:  true,
+	SetTypeWorking: true,
+	SetTypeDrop:    true,
+	SetTypeFailure: true,
+}
+
+// MaxWeight is the heaviest weight the DECIMAL(6, 2) weight columns hold.
+const MaxWeight = 9999.99
+
+var ErrInvalidWorkoutSet = errors.New("invalid workout set")
+
+// WorkoutSet is a single logged set of a WorkoutEntry, eg. the 10x70 of a
+// 12x60, 10x70, 8x80 pyramid.
+type WorkoutSet struct {
+	ID              int      `json:"id"`
+	SetIndex        int      `json:"set_index"`
+	SetType         string   `json:"set_type"`
+	Reps            *int     `json:"reps"`
+	DurationSeconds *int     `json:"duration_seconds"`
+	Weight          *float64 `json:"weight"`
+	RPE             *float64 `json:"rpe"`
+	RIR             *int     `json:"rir"`
+	RestSeconds     *int     `json:"rest_seconds"`
+}
+
+func (s *WorkoutSet) validate() error {
+	if s.SetType == "" {
+		s.SetType = SetTypeWorking
+	}
+	if !validSetTypes[s.SetType] {
+		return fmt.Errorf("%w: unknown set_type %q", ErrInvalidWorkoutSet, s.SetType)
+	}
+	if (s.Reps == nil) == (s.DurationSeconds == nil) {
+		return fmt.Errorf("%w: exactly one of reps or duration_seconds is required", ErrInvalidWorkoutSet)
+	}
+	if s.Reps != nil && *s.Reps < 0 {
+		return fmt.Errorf("%w: reps cannot be negative", ErrInvalidWorkoutSet)
+	}
+	if s.DurationSeconds != nil && *s.DurationSeconds < 0 {
+		return fmt.Errorf("%w: duration_seconds cannot be negative", ErrInvalidWorkoutSet)
+	}
+	if s.Weight != nil && *s.Weight < 0 {
+		return fmt.Errorf("%w: weight cannot be negative", ErrInvalidWorkoutSet)
+	}
+	if s.Weight != nil && *s.Weight > MaxWeight {
+		return fmt.Errorf("%w: weight cannot exceed %.2f", ErrInvalidWorkoutSet, MaxWeight)
+	}
+	if s.RPE != nil && (*s.RPE < 1 || *s.RPE > 10) {
+		return fmt.Errorf("%w: rpe must be between 1 and 10", ErrInvalidWorkoutSet)
+	}
+	if s.RIR != nil && *s.RIR < 0 {
+		return fmt.Errorf("%w: rir cannot be negative", ErrInvalidWorkoutSet)
+	}
+	if s.RestSeconds != nil && *s.RestSeconds < 0 {
+		return fmt.Errorf("%w: rest_seconds cannot be negative", ErrInvalidWorkoutSet)
+	}
+	return nil
+}
+
+// NormalizeEntries makes the per-set log and the aggregate sets/reps/weight
+// fields of every entry agree with each other. Entries sent with set_details
+// get their aggregates recomputed; entries sent the old way only with
+// aggregates are expanded into identical working sets.
+func (w *Workout) NormalizeEntries() error {
+	for i := range w.Entries {
+		if err := w.Entries[i].normalizeSets(); err != nil {
+			return fmt.Errorf("entry %q: %w", w.Entries[i].ExerciseName, err)
+		}
+	}
+	return nil
+}
+
+func (e *WorkoutEntry) normalizeSets() error {
+	if len(e.SetDetails) == 0 {
+		if (e.Reps == nil) == (e.DurationSeconds == nil) {
+			return fmt.Errorf("%w: exactly one of reps or duration_seconds is required", ErrInvalidWorkoutSet)
+		}
+		for i := 0; i < max(e.Sets, 1); i++ {
+			e.SetDetails = append(e.SetDetails, WorkoutSet{
+				SetType:         SetTypeWorking,
+				Reps:            e.Reps,
+				DurationSeconds: e.DurationSeconds,
+				Weight:          e.Weight,
+			})
+		}
+	}
+
+	e.Sets = len(e.SetDetails)
+	var top *WorkoutSet
+	for i := range e.SetDetails {
+		set := &e.SetDetails[i]
+		set.SetIndex = i + 1
+		if err := set.validate(); err != nil {
+			return err
+		}
+		if (set.Reps == nil) != (e.SetDetails[0].Reps == nil) {
+			return fmt.Errorf("%w: sets of one entry cannot mix reps and duration_seconds", ErrInvalidWorkoutSet)
+		}
+		if top == nil || set.outranks(top) {
+			top = set
+		}
+	}
+
+	// the aggregate fields summarise the top set of the entry
+	e.Reps, e.DurationSeconds, e.Weight = top.Reps, top.DurationSeconds, top.Weight
+	return nil
+}
+
+// outranks reports whether s is a better top set than other: heavier first,
+// then more reps or a longer hold.
+func (s *WorkoutSet) outranks(other *WorkoutSet) bool {
+	weight, otherWeight := 0.0, 0.0
+	if s.Weight != nil {
+		weight = *s.Weight
+	}
+	if other.Weight != nil {
+		otherWeight = *other.Weight
+	}
+	if weight != otherWeight {
+		return weight > otherWeight
+	}
+	return intValue(s.Reps)+intValue(s.DurationSeconds) > intValue(other.Reps)+intValue(other.DurationSeconds)
+}
+
+func intValue(i *int) int {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
+// insertEntries writes the entries of a workout and their sets inside tx,
+// storing the generated ids back on the workout.
+func insertEntries(tx *sql.Tx, workout *Workout) error {
+	entryQuery := `
+	INSERT INTO workout_entries (workout_id, exercise_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id
+	`
+	setQuery := `
+	INSERT INTO workout_sets (entry_id, set_index, set_type, reps, duration_seconds, weight, rpe, rir, rest_seconds)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING id
+	`
+
+	for i := range workout.Entries {
+		entry := &workout.Entries[i]
+		err := tx.QueryRow(entryQuery, workout.ID, entry.ExerciseID, entry.ExerciseName, entry.Sets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex).Scan(&entry.ID)
+		if err != nil {
+			return err
+		}
+
+		for j := range entry.SetDetails {
+			set := &entry.SetDetails[j]
+			err := tx.QueryRow(setQuery, entry.ID, set.SetIndex, set.SetType, set.Reps, set.DurationSeconds, set.Weight, set.RPE, set.RIR, set.RestSeconds).Scan(&set.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadSets fetches the sets of every given entry in a single query.
+func (pg *PostgresWorkoutStore) loadSets(entries []*WorkoutEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(entries))
+	byID := make(map[int]*WorkoutEntry, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, int64(entry.ID))
+		byID[entry.ID] = entry
+	}
+
+	query := `
+	SELECT entry_id, id, set_index, set_type, reps, duration_seconds, weight, rpe, rir, rest_seconds
+	FROM workout_sets
+	WHERE entry_id = ANY($1)
+	ORDER BY entry_id, set_index
+	`
+	rows, err := pg.db.Query(query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entryID int
+		var set WorkoutSet
+		err := rows.Scan(
+			&entryID,
+			&set.ID,
+			&set.SetIndex,
+			&set.SetType,
+			&set.Reps,
+			&set.DurationSeconds,
+			&set.Weight,
+			&set.RPE,
+			&set.RIR,
+			&set.RestSeconds,
+		)
+		if err != nil {
+			return err
+		}
+		if entry, ok := byID[entryID]; ok {
+			entry.SetDetails = append(entry.SetDetails, set)
+		}
+	}
+	return rows.Err()
+}
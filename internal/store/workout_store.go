@@ -2,27 +2,104 @@ package store
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 )
 
+// Workout is a logged training session. TemplateID, ProgramEnrollmentID and
+// ProgramDayID record where it was started from; a workout linked to a
+// program day is what marks that day complete.
 type Workout struct {
-	ID              int            `json:"id"`
-	UserID          int            `json:"user_id"`
-	Title           string         `json:"title"`
-	Description     string         `json:"description"`
-	DurationMinutes int            `json:"duration_minutes"`
-	CaloriesBurned  int            `json:"calories_burned"`
-	Entries         []WorkoutEntry `json:"entries"`
+	ID                  int            `json:"id"`
+	UserID              int            `json:"user_id"`
+	Title               string         `json:"title"`
+	Description         string         `json:"description"`
+	DurationMinutes     int            `json:"duration_minutes"`
+	CaloriesBurned      int            `json:"calories_burned"`
+	PerformedAt         time.Time      `json:"performed_at"`
+	StartedAt           *time.Time     `json:"started_at"`
+	EndedAt             *time.Time     `json:"ended_at"`
+	Timezone            string         `json:"timezone"`
+	TemplateID          *int           `json:"template_id"`
+	ProgramEnrollmentID *int           `json:"program_enrollment_id"`
+	ProgramDayID        *int           `json:"program_day_id"`
+	Entries             []WorkoutEntry `json:"entries"`
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+	// NewRecords holds the PRs achieved in this workout, filled in by
+	// CreateWorkout and UpdateWorkout.
+	NewRecords []PersonalRecord `json:"-"`
 }
 
+func (w *Workout) exerciseIDs() []int {
+	var ids []int
+	for _, entry := range w.Entries {
+		if entry.ExerciseID != nil {
+			ids = append(ids, *entry.ExerciseID)
+		}
+	}
+	return ids
+}
+
+var ErrInvalidWorkoutTimes = errors.New("ended_at must not be before started_at")
+
+// ValidTimezone reports whether name is an IANA time zone that postgres
+// can convert to. time.LoadLocation also takes "" and "Local", which
+// depend on the server and mean nothing to postgres.
+func ValidTimezone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// NormalizeTimes validates the performed/started/ended timestamps and fills
+// in whatever can be derived from them: performed_at falls back to started_at
+// (or now), and the duration is computed when both ends are known.
+func (w *Workout) NormalizeTimes() error {
+	if w.Timezone == "" {
+		w.Timezone = "UTC"
+	}
+	if !ValidTimezone(w.Timezone) {
+		return fmt.Errorf("invalid timezone %q", w.Timezone)
+	}
+
+	if w.StartedAt != nil && w.EndedAt != nil {
+		if w.EndedAt.Before(*w.StartedAt) {
+			return ErrInvalidWorkoutTimes
+		}
+		w.DurationMinutes = int(w.EndedAt.Sub(*w.StartedAt).Round(time.Minute) / time.Minute)
+	}
+
+	if w.PerformedAt.IsZero() {
+		if w.StartedAt != nil {
+			w.PerformedAt = *w.StartedAt
+		} else {
+			w.PerformedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+// WorkoutEntry is one exercise of a workout. The per-set log lives in
+// SetDetails; Sets, Reps, DurationSeconds and Weight are kept as a summary
+// of it for older clients (see NormalizeEntries).
 type WorkoutEntry struct {
-	ID              int      `json:"id"`
-	ExerciseName    string   `json:"exercise_name"`
-	Sets            int      `json:"sets"`
-	Reps            *int     `json:"reps"`
-	DurationSeconds *int     `json:"duration_seconds"`
-	Weight          *float64 `json:"weight"`
-	Notes           string   `json:"notes"`
-	OrderIndex      int      `json:"order_index"`
+	ID              int          `json:"id"`
+	ExerciseID      *int         `json:"exercise_id"`
+	ExerciseName    string       `json:"exercise_name"`
+	Sets            int          `json:"sets"`
+	Reps            *int         `json:"reps"`
+	DurationSeconds *int         `json:"duration_seconds"`
+	Weight          *float64     `json:"weight"`
+	Notes           string       `json:"notes"`
+	OrderIndex      int          `json:"order_index"`
+	SetDetails      []WorkoutSet `json:"set_details"`
 }
 
 type PostgresWorkoutStore struct {
@@ -39,16 +116,117 @@ type WorkoutStore interface {
 	UpdateWorkout(*Workout) error
 	DeleteWorkout(id int64) error
 	GetWorkoutOwner(id int64) (int, error)
+	ListWorkouts(filter WorkoutFilter) (*WorkoutPage, error)
+}
+
+const (
+	DefaultWorkoutPageSize = 20
+	MaxWorkoutPageSize     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// WorkoutFilter narrows down the workouts returned by ListWorkouts.
+// Nil pointers and empty strings mean "no filter".
+type WorkoutFilter struct {
+	UserID      int
+	From        *time.Time
+	To          *time.Time
+	Title       string
+	MinDuration *int
+	MaxDuration *int
+	MinCalories *int
+	MaxCalories *int
+	// Sort is one of the keys of workoutSortColumns, optionally prefixed
+	// with "-" for descending order. Defaults to "-performed_at".
+	Sort   string
+	Cursor string
+	Limit  int
+}
+
+type WorkoutPage struct {
+	Workouts   []*Workout `json:"workouts"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+type workoutSortColumn struct {
+	expr string
+	cast string
+}
+
+var workoutSortColumns = map[string]workoutSortColumn{
+	"performed_at":     {expr: "w.performed_at", cast: "timestamptz"},
+	"created_at":       {expr: "w.created_at", cast: "timestamptz"},
+	"title":            {expr: "w.title", cast: "text"},
+	"duration_minutes": {expr: "w.duration_minutes", cast: "integer"},
+	"calories_burned":  {expr: "COALESCE(w.calories_burned, 0)", cast: "integer"},
+}
+
+// workoutCursor is the opaque keyset position handed back to clients as
+// next_cursor. Value holds the sort column of the last row as text so a
+// single shape covers every sortable column.
+type workoutCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func encodeWorkoutCursor(c workoutCursor) string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeWorkoutCursor(s string) (*workoutCursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c workoutCursor
+	if err := json.Unmarshal(js, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// ParseWorkoutSort validates a sort parameter and returns the column key
+// and whether the order is descending.
+func ParseWorkoutSort(sort string) (string, bool, error) {
+	if sort == "" {
+		sort = "-performed_at"
+	}
+	desc := strings.HasPrefix(sort, "-")
+	key := strings.TrimPrefix(sort, "-")
+	if _, ok := workoutSortColumns[key]; !ok {
+		return "", false, fmt.Errorf("invalid sort field %q", key)
+	}
+	return key, desc, nil
 }
 
 func (pg *PostgresWorkoutStore) GetWorkoutByID(id int64) (*Workout, error) {
 	workout := &Workout{}
 	query := `
-	SELECT id, title, description, duration_minutes, calories_burned
+	SELECT id, user_id, title, description, duration_minutes, calories_burned,
+		performed_at, started_at, ended_at, timezone, template_id, program_enrollment_id, program_day_id, created_at, updated_at
 	FROM workouts
 	WHERE id = $1
 	`
-	err := pg.db.QueryRow(query, id).Scan(&workout.ID, &workout.Title, &workout.Description, &workout.DurationMinutes, &workout.CaloriesBurned)
+	err := pg.db.QueryRow(query, id).Scan(
+		&workout.ID,
+		&workout.UserID,
+		&workout.Title,
+		&workout.Description,
+		&workout.DurationMinutes,
+		&workout.CaloriesBurned,
+		&workout.PerformedAt,
+		&workout.StartedAt,
+		&workout.EndedAt,
+		&workout.Timezone,
+		&workout.TemplateID,
+		&workout.ProgramEnrollmentID,
+		&workout.ProgramDayID,
+		&workout.CreatedAt,
+		&workout.UpdatedAt,
+	)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -56,68 +234,30 @@ func (pg *PostgresWorkoutStore) GetWorkoutByID(id int64) (*Workout, error) {
 		return nil, err
 	}
 
-	entryQuery := `
-	SELECT id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index
-	FROM workout_entries
-	WHERE workout_id = $1
-	ORDER BY order_index
-	`
-	rows, err := pg.db.Query(entryQuery, id)
+	err = pg.loadEntries([]*Workout{workout})
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var entry WorkoutEntry
-		err := rows.Scan(
-			&entry.ID,
-			&entry.ExerciseName,
-			&entry.Sets,
-			&entry.Reps,
-			&entry.DurationSeconds,
-			&entry.Weight,
-			&entry.Notes,
-			&entry.OrderIndex,
-		)
-		if err != nil {
-			return nil, err
-		}
-		workout.Entries = append(workout.Entries, entry)
-	}
 	return workout, nil
 }
 
 func (pg *PostgresWorkoutStore) CreateWorkout(workout *Workout) (*Workout, error) {
+	err := workout.NormalizeEntries()
+	if err != nil {
+		return nil, err
+	}
+
 	tx, err := pg.db.Begin()
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
-	query :=
-		`INSERT INTO workouts (user_id, title, description, duration_minutes, calories_burned)
-	VALUES ($1, $2, $3, $4, $5)
-	RETURNING id`
-
-	err = tx.QueryRow(query, workout.UserID, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned).Scan(&workout.ID)
+	err = insertWorkout(tx, workout)
 	if err != nil {
 		return nil, err
 	}
 
-	// we also need to insert the entries
-	for _, entry := range workout.Entries {
-		query := `
-		INSERT INTO workout_entries (workout_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		RETURNING id
-		`
-
-		err = tx.QueryRow(query, workout.ID, entry.ExerciseName, entry.Sets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex).Scan(&entry.ID)
-		if err != nil {
-			return nil, err
-		}
-	}
 	err = tx.Commit()
 	if err != nil {
 		return nil, err
@@ -126,7 +266,43 @@ func (pg *PostgresWorkoutStore) CreateWorkout(workout *Workout) (*Workout, error
 	return workout, err
 }
 
+// insertWorkout writes an already normalized workout with its entries and
+// sets inside tx and recomputes the personal records it touches.
+func insertWorkout(tx *sql.Tx, workout *Workout) error {
+	query :=
+		`INSERT INTO workouts (user_id, title, description, duration_minutes, calories_burned, performed_at, started_at, ended_at, timezone, template_id, program_enrollment_id, program_day_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	RETURNING id, created_at, updated_at`
+
+	err := tx.QueryRow(query, workout.UserID, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned,
+		workout.PerformedAt, workout.StartedAt, workout.EndedAt, workout.Timezone, workout.TemplateID, workout.ProgramEnrollmentID, workout.ProgramDayID).Scan(&workout.ID, &workout.CreatedAt, &workout.UpdatedAt)
+	if uniqueViolationConstraint(err) == "idx_workouts_program_day" {
+		return ErrProgramDayCompleted
+	}
+	if err != nil {
+		return err
+	}
+
+	// we also need to insert the entries along with their sets
+	err = resolveEntryExercises(tx, workout.UserID, workout.Entries)
+	if err != nil {
+		return err
+	}
+	err = insertEntries(tx, workout)
+	if err != nil {
+		return err
+	}
+
+	workout.NewRecords, err = recomputeRecords(tx, workout.UserID, workout.exerciseIDs(), workout.ID)
+	return err
+}
+
 func (pg *PostgresWorkoutStore) UpdateWorkout(workout *Workout) error {
+	err := workout.NormalizeEntries()
+	if err != nil {
+		return err
+	}
+
 	tx, err := pg.db.Begin()
 	if err != nil {
 		return err
@@ -135,57 +311,71 @@ func (pg *PostgresWorkoutStore) UpdateWorkout(workout *Workout) error {
 
 	query := `
 	UPDATE workouts
-	SET title = $1, description = $2, duration_minutes = $3, calories_burned = $4
-	WHERE id = $5
+	SET title = $1, description = $2, duration_minutes = $3, calories_burned = $4,
+		performed_at = $5, started_at = $6, ended_at = $7, timezone = $8, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $9
+	RETURNING updated_at
 	`
 
-	result, err := tx.Exec(query, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned, workout.ID)
+	err = tx.QueryRow(query, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned,
+		workout.PerformedAt, workout.StartedAt, workout.EndedAt, workout.Timezone, workout.ID).Scan(&workout.UpdatedAt)
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	// records of exercises dropped from the workout need recomputing too
+	previousExerciseIDs, err := workoutExerciseIDs(tx, workout.ID)
 	if err != nil {
-		return nil
-	}
-
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+		return err
 	}
 
+	// entries are replaced wholesale, their sets go with them through ON DELETE CASCADE
 	_, err = tx.Exec(`DELETE FROM workout_entries WHERE workout_id = $1`, workout.ID)
 	if err != nil {
 		return err
+	}
 
+	err = resolveEntryExercises(tx, workout.UserID, workout.Entries)
+	if err != nil {
+		return err
+	}
+	err = insertEntries(tx, workout)
+	if err != nil {
+		return err
 	}
-	for _, entry := range workout.Entries {
-		query := `
-		INSERT INTO workout_entries (workout_id, exercise_name, sets, reps, duration_seconds,weight, notes, order_index)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-		`
 
-		_, err := tx.Exec(query, workout.ID, entry.ExerciseName, entry.Sets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex)
-		if err != nil {
-			return err
-		}
+	workout.NewRecords, err = recomputeRecords(tx, workout.UserID, append(previousExerciseIDs, workout.exerciseIDs()...), workout.ID)
+	if err != nil {
+		return err
 	}
 	return tx.Commit()
 }
 
 func (pg *PostgresWorkoutStore) DeleteWorkout(id int64) error {
-	query := `DELETE FROM workouts WHERE id = $1`
-	result, err := pg.db.Exec(query, id)
+	tx, err := pg.db.Begin()
 	if err != nil {
 		return err
 	}
-	rowsAffected, err := result.RowsAffected()
+	defer tx.Rollback()
+
+	exerciseIDs, err := workoutExerciseIDs(tx, int(id))
 	if err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+
+	var userID int
+	query := `DELETE FROM workouts WHERE id = $1 RETURNING user_id`
+	err = tx.QueryRow(query, id).Scan(&userID)
+	if err != nil {
+		return err
 	}
-	return nil
+
+	// records set in the deleted workout went with it, later sets may now be PRs
+	_, err = recomputeRecords(tx, userID, exerciseIDs, 0)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (pg *PostgresWorkoutStore) GetWorkoutOwner(workoutID int64) (int, error) {
@@ -198,3 +388,193 @@ func (pg *PostgresWorkoutStore) GetWorkoutOwner(workoutID int64) (int, error) {
 	}
 	return userID, nil
 }
+
+func (pg *PostgresWorkoutStore) ListWorkouts(filter WorkoutFilter) (*WorkoutPage, error) {
+	sort := filter.Sort
+	if sort == "" {
+		sort = "-performed_at"
+	}
+	sortKey, desc, err := ParseWorkoutSort(sort)
+	if err != nil {
+		return nil, err
+	}
+	sortColumn := workoutSortColumns[sortKey]
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultWorkoutPageSize
+	}
+	if limit > MaxWorkoutPageSize {
+		limit = MaxWorkoutPageSize
+	}
+
+	conditions := []string{"w.user_id = $1"}
+	args := []any{filter.UserID}
+	addCondition := func(format string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, len(args)))
+	}
+
+	if filter.From != nil {
+		addCondition("w.performed_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addCondition("w.performed_at < $%d", *filter.To)
+	}
+	if filter.Title != "" {
+		addCondition(`w.title ILIKE '%%' || $%d || '%%' ESCAPE '\'`, likeEscaper.Replace(filter.Title))
+	}
+	if filter.MinDuration != nil {
+		addCondition("w.duration_minutes >= $%d", *filter.MinDuration)
+	}
+	if filter.MaxDuration != nil {
+		addCondition("w.duration_minutes <= $%d", *filter.MaxDuration)
+	}
+	if filter.MinCalories != nil {
+		addCondition("COALESCE(w.calories_burned, 0) >= $%d", *filter.MinCalories)
+	}
+	if filter.MaxCalories != nil {
+		addCondition("COALESCE(w.calories_burned, 0) <= $%d", *filter.MaxCalories)
+	}
+
+	direction, comparison := "ASC", ">"
+	if desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeWorkoutCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		// a cursor is only meaningful for the ordering it was issued for
+		if cursor.Sort != sort {
+			return nil, ErrInvalidCursor
+		}
+		args = append(args, cursor.Value, cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, w.id) %s ($%d::%s, $%d)",
+			sortColumn.expr, comparison, len(args)-1, sortColumn.cast, len(args)))
+	}
+
+	args = append(args, limit+1)
+	query := fmt.Sprintf(`
+	SELECT w.id, w.user_id, w.title, COALESCE(w.description, ''), w.duration_minutes, COALESCE(w.calories_burned, 0),
+		w.performed_at, w.started_at, w.ended_at, w.timezone, w.template_id, w.program_enrollment_id, w.program_day_id, w.created_at, w.updated_at, (%s)::text
+	FROM workouts w
+	WHERE %s
+	ORDER BY %s %s, w.id %s
+	LIMIT $%d
+	`, sortColumn.expr, strings.Join(conditions, " AND "), sortColumn.expr, direction, direction, len(args))
+
+	rows, err := pg.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &WorkoutPage{Workouts: []*Workout{}}
+	var sortValues []string
+	for rows.Next() {
+		workout := &Workout{Entries: []WorkoutEntry{}}
+		var sortValue string
+		err := rows.Scan(
+			&workout.ID,
+			&workout.UserID,
+			&workout.Title,
+			&workout.Description,
+			&workout.DurationMinutes,
+			&workout.CaloriesBurned,
+			&workout.PerformedAt,
+			&workout.StartedAt,
+			&workout.EndedAt,
+			&workout.Timezone,
+			&workout.TemplateID,
+			&workout.ProgramEnrollmentID,
+			&workout.ProgramDayID,
+			&workout.CreatedAt,
+			&workout.UpdatedAt,
+			&sortValue,
+		)
+		if err != nil {
+			return nil, err
+		}
+		page.Workouts = append(page.Workouts, workout)
+		sortValues = append(sortValues, sortValue)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// we fetched one extra row only to know whether another page exists
+	if len(page.Workouts) > limit {
+		page.Workouts = page.Workouts[:limit]
+		last := page.Workouts[limit-1]
+		page.NextCursor = encodeWorkoutCursor(workoutCursor{Sort: sort, Value: sortValues[limit-1], ID: last.ID})
+	}
+
+	if err := pg.loadEntries(page.Workouts); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// loadEntries fetches the entries (and their sets) of every given workout in
+// a single query instead of issuing one query per workout.
+func (pg *PostgresWorkoutStore) loadEntries(workouts []*Workout) error {
+	if len(workouts) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(workouts))
+	byID := make(map[int]*Workout, len(workouts))
+	for _, workout := range workouts {
+		ids = append(ids, int64(workout.ID))
+		byID[workout.ID] = workout
+	}
+
+	query := `
+	SELECT workout_id, id, exercise_id, exercise_name, sets, reps, duration_seconds, weight, COALESCE(notes, ''), order_index
+	FROM workout_entries
+	WHERE workout_id = ANY($1)
+	ORDER BY workout_id, order_index
+	`
+	rows, err := pg.db.Query(query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var workoutID int
+		var entry WorkoutEntry
+		err := rows.Scan(
+			&workoutID,
+			&entry.ID,
+			&entry.ExerciseID,
+			&entry.ExerciseName,
+			&entry.Sets,
+			&entry.Reps,
+			&entry.DurationSeconds,
+			&entry.Weight,
+			&entry.Notes,
+			&entry.OrderIndex,
+		)
+		if err != nil {
+			return err
+		}
+		if workout, ok := byID[workoutID]; ok {
+			workout.Entries = append(workout.Entries, entry)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var entries []*WorkoutEntry
+	for _, workout := range workouts {
+		for i := range workout.Entries {
+			entries = append(entries, &workout.Entries[i])
+		}
+	}
+	return pg.loadSets(entries)
+}
@@ -2,7 +2,9 @@ package store
 
 import (
 	"database/sql"
+	"os"
 	"testing"
+	"time"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/stretchr/testify/assert"
@@ -10,7 +12,11 @@ import (
 )
 
 func setupTestDB(t *testing.T) *sql.DB {
-	db, err := sql.Open("pgx", "host=localhost user=postgres password=postgres dbname=postgres port=5433 sslmode=disable")
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		dsn = "host=localhost user=postgres password=postgres dbname=postgres port=5433 sslmode=disable"
+	}
+	db, err := sql.Open("pgx", dsn)
 	if err != nil {
 		t.Fatalf("opening test db: %v", err)
 	}
@@ -21,7 +27,7 @@ func setupTestDB(t *testing.T) *sql.DB {
 		t.Fatalf("migrating test db error: %v", err)
 	}
 
-	_, err = db.Exec(`TRUNCATE workouts, workout_entries CASCADE`)
+	_, err = db.Exec(`TRUNCATE workouts, workout_entries, users CASCADE`)
 	if err != nil {
 		t.Fatalf("truncating tables : %v", err)
 	}
@@ -116,6 +122,157 @@ func TestCreateWorkout(t *testing.T) {
 	}
 }
 
+func TestListWorkoutsTitleFilter(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	user := createTestUser(t, db, "filtering")
+	store := NewPostgresWorkoutStore(db)
+	for _, title := range []string{"100% effort", "1000 reps", "leg_day", "legs day", `back\arms`} {
+		_, err := store.CreateWorkout(&Workout{
+			UserID:  user.ID,
+			Title:   title,
+			Entries: []WorkoutEntry{{ExerciseName: "Squat", Sets: 1, Reps: IntPtr(5), OrderIndex: 1}},
+		})
+		require.NoError(t, err)
+	}
+
+	tests := map[string][]string{
+		"%":   {"100% effort"},
+		"_":   {"leg_day"},
+		"g_d": {"leg_day"},
+		`\`:   {`back\arms`},
+		"day": {"leg_day", "legs day"},
+	}
+	for title, want := range tests {
+		t.Run(title, func(t *testing.T) {
+			page, err := store.ListWorkouts(WorkoutFilter{UserID: user.ID, Title: title})
+			require.NoError(t, err)
+			var got []string
+			for _, w := range page.Workouts {
+				got = append(got, w.Title)
+			}
+			assert.ElementsMatch(t, want, got)
+		})
+	}
+}
+
+func TestWorkoutCursor(t *testing.T) {
+	cursor := workoutCursor{Sort: "-created_at", Value: "2025-05-01 10:00:00+00", ID: 42}
+
+	decoded, err := decodeWorkoutCursor(encodeWorkoutCursor(cursor))
+	require.NoError(t, err)
+	assert.Equal(t, cursor, *decoded)
+
+	_, err = decodeWorkoutCursor("not a cursor!")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestParseWorkoutSort(t *testing.T) {
+	key, desc, err := ParseWorkoutSort("")
+	require.NoError(t, err)
+	assert.Equal(t, "performed_at", key)
+	assert.True(t, desc)
+
+	key, desc, err = ParseWorkoutSort("duration_minutes")
+	require.NoError(t, err)
+	assert.Equal(t, "duration_minutes", key)
+	assert.False(t, desc)
+
+	_, _, err = ParseWorkoutSort("-password_hash")
+	assert.Error(t, err)
+}
+
+func TestWorkoutNormalizeTimes(t *testing.T) {
+	start := time.Date(2025, 5, 1, 7, 0, 0, 0, time.UTC)
+
+	t.Run("derives duration and performed_at", func(t *testing.T) {
+		end := start.Add(47 * time.Minute)
+		workout := &Workout{StartedAt: &start, EndedAt: &end, DurationMinutes: 10}
+		require.NoError(t, workout.NormalizeTimes())
+		assert.Equal(t, 47, workout.DurationMinutes)
+		assert.True(t, workout.PerformedAt.Equal(start))
+		assert.Equal(t, "UTC", workout.Timezone)
+	})
+
+	t.Run("rejects ended before started", func(t *testing.T) {
+		end := start.Add(-time.Minute)
+		workout := &Workout{StartedAt: &start, EndedAt: &end}
+		assert.ErrorIs(t, workout.NormalizeTimes(), ErrInvalidWorkoutTimes)
+	})
+
+	t.Run("rejects unknown timezone", func(t *testing.T) {
+		workout := &Workout{Timezone: "Mars/Olympus_Mons"}
+		assert.Error(t, workout.NormalizeTimes())
+	})
+
+	t.Run("rejects the server's local timezone", func(t *testing.T) {
+		workout := &Workout{Timezone: "Local"}
+		assert.Error(t, workout.NormalizeTimes())
+	})
+}
+
+func TestValidTimezone(t *testing.T) {
+	assert.True(t, ValidTimezone("UTC"))
+	assert.True(t, ValidTimezone("Europe/Paris"))
+	assert.False(t, ValidTimezone(""))
+	assert.False(t, ValidTimezone("Local"))
+	assert.False(t, ValidTimezone("Mars/Olympus_Mons"))
+}
+
+func TestWorkoutNormalizeEntries(t *testing.T) {
+	t.Run("pyramid summarised by its top set", func(t *testing.T) {
+		workout := &Workout{Entries: []WorkoutEntry{{
+			ExerciseName: "Bench Press",
+			SetDetails: []WorkoutSet{
+				{SetType: SetTypeWarmup, Reps: IntPtr(12), Weight: FloatPtr(60)},
+				{Reps: IntPtr(10), Weight: FloatPtr(70)},
+				{Reps: IntPtr(8), Weight: FloatPtr(80)},
+			},
+		}}}
+		require.NoError(t, workout.NormalizeEntries())
+
+		entry := workout.Entries[0]
+		assert.Equal(t, 3, entry.Sets)
+		assert.Equal(t, 8, *entry.Reps)
+		assert.Equal(t, 80.0, *entry.Weight)
+		assert.Equal(t, SetTypeWorking, entry.SetDetails[1].SetType)
+		assert.Equal(t, 3, entry.SetDetails[2].SetIndex)
+	})
+
+	t.Run("aggregates expanded into sets", func(t *testing.T) {
+		workout := &Workout{Entries: []WorkoutEntry{{ExerciseName: "Squats", Sets: 3, Reps: IntPtr(12), Weight: FloatPtr(100.5)}}}
+		require.NoError(t, workout.NormalizeEntries())
+		require.Len(t, workout.Entries[0].SetDetails, 3)
+		assert.Equal(t, 12, *workout.Entries[0].SetDetails[2].Reps)
+	})
+
+	t.Run("weight beyond the columns' range rejected", func(t *testing.T) {
+		workout := &Workout{Entries: []WorkoutEntry{{
+			ExerciseName: "Leg Press",
+			SetDetails:   []WorkoutSet{{Reps: IntPtr(10), Weight: FloatPtr(1000)}},
+		}}}
+		require.NoError(t, workout.NormalizeEntries(), "1000 fits since entries share the sets' precision")
+
+		workout = &Workout{Entries: []WorkoutEntry{{
+			ExerciseName: "Leg Press",
+			SetDetails:   []WorkoutSet{{Reps: IntPtr(10), Weight: FloatPtr(10000)}},
+		}}}
+		assert.ErrorIs(t, workout.NormalizeEntries(), ErrInvalidWorkoutSet)
+	})
+
+	t.Run("mixed reps and duration rejected", func(t *testing.T) {
+		workout := &Workout{Entries: []WorkoutEntry{{
+			ExerciseName: "Plank",
+			SetDetails: []WorkoutSet{
+				{Reps: IntPtr(10)},
+				{DurationSeconds: IntPtr(60)},
+			},
+		}}}
+		assert.ErrorIs(t, workout.NormalizeEntries(), ErrInvalidWorkoutSet)
+	})
+}
+
 func IntPtr(i int) *int {
 	return &i
 }
@@ -8,15 +8,50 @@ import (
 )
 
 const (
-	ScopeAuth = "authentication"
+	ScopeAuth           = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeActivation     = "activation"
+	ScopeRefresh        = "refresh"
+	ScopePersonalAccess = "personal-access"
+	ScopeMFAChallenge   = "mfa-challenge"
 )
 
+// PersonalAccessTokenPrefix starts every personal access token so they are
+// told apart from sign-in tokens, and are easy to spot when leaked.
+const PersonalAccessTokenPrefix = "pat_"
+
+// Access scopes limit what a personal access token can be used for. Tokens
+// from a password sign-in are not limited.
+const (
+	AccessWorkoutsRead  = "workouts:read"
+	AccessWorkoutsWrite = "workouts:write"
+	AccessProfileRead   = "profile:read"
+	AccessStatsRead     = "stats:read"
+)
+
+var AccessScopes = []string{AccessWorkoutsRead, AccessWorkoutsWrite, AccessProfileRead, AccessStatsRead}
+
+func ValidAccessScope(scope string) bool {
+	for _, s := range AccessScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
 type Token struct {
+	ID        int       `json:"id"`
 	PlainText string    `json:"plaintext"`
 	Hash      []byte    `json:"-"`
 	UserID    int       `json:"-"`
-	Expiry    time.Time `json:"expiry"`
+	Expiry    time.Time `json:"expiry,omitzero"`
 	Scope     string    `json:"-"`
+	// FamilyID ties access and refresh tokens to the sign-in they came
+	// from; zero for tokens outside of a family.
+	FamilyID     int64    `json:"-"`
+	Name         string   `json:"name,omitempty"`
+	AccessScopes []string `json:"scopes,omitempty"`
 }
 
 func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error) {
@@ -26,14 +61,47 @@ func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error)
 		Scope:  scope,
 	}
 
-	emptyBytes := make([]byte, 32)
-	_, err := rand.Read(emptyBytes)
+	plainText, err := randomPlainText()
 	if err != nil {
 		return nil, err
 	}
+	token.setPlainText(plainText)
+	return token, nil
+}
 
-	token.PlainText = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(emptyBytes)
-	hash := sha256.Sum256([]byte(token.PlainText))
-	token.Hash = hash[:]
+// GeneratePersonalAccessToken creates a token for scripts and integrations
+// that can only be used within accessScopes. A zero ttl means the token
+// never expires.
+func GeneratePersonalAccessToken(userID int, name string, accessScopes []string, ttl time.Duration) (*Token, error) {
+	token := &Token{
+		UserID:       userID,
+		Scope:        ScopePersonalAccess,
+		Name:         name,
+		AccessScopes: accessScopes,
+	}
+	if ttl > 0 {
+		token.Expiry = time.Now().Add(ttl)
+	}
+
+	plainText, err := randomPlainText()
+	if err != nil {
+		return nil, err
+	}
+	token.setPlainText(PersonalAccessTokenPrefix + plainText)
 	return token, nil
 }
+
+func randomPlainText() (string, error) {
+	emptyBytes := make([]byte, 32)
+	_, err := rand.Read(emptyBytes)
+	if err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(emptyBytes), nil
+}
+
+func (t *Token) setPlainText(plainText string) {
+	t.PlainText = plainText
+	hash := sha256.Sum256([]byte(plainText))
+	t.Hash = hash[:]
+}
@@ -0,0 +1,32 @@
+package tokens
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratePersonalAccessToken(t *testing.T) {
+	token, err := GeneratePersonalAccessToken(1, "backup script", []string{AccessWorkoutsRead}, 0)
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(token.PlainText, PersonalAccessTokenPrefix))
+	hash := sha256.Sum256([]byte(token.PlainText))
+	assert.Equal(t, hash[:], token.Hash)
+	assert.Equal(t, ScopePersonalAccess, token.Scope)
+	assert.True(t, token.Expiry.IsZero(), "a zero ttl never expires")
+
+	token, err = GeneratePersonalAccessToken(1, "ci", []string{AccessStatsRead}, time.Hour)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), token.Expiry, time.Minute)
+}
+
+func TestValidAccessScope(t *testing.T) {
+	assert.True(t, ValidAccessScope(AccessWorkoutsWrite))
+	assert.False(t, ValidAccessScope("workouts:delete"))
+	assert.False(t, ValidAccessScope(ScopeAuth))
+}
@@ -0,0 +1,67 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcKey is the SHA1 seed of the RFC 6238 test vectors.
+var rfcKey = []byte("12345678901234567890")
+
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, code(rfcKey, Step(time.Unix(tt.unix, 0)), 8), "t=%d", tt.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := encoding.EncodeToString(rfcKey)
+	at := time.Unix(59, 0)
+
+	step, ok := Validate(secret, "287082", at)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), step)
+
+	_, ok = Validate(secret, "287082", at.Add(Period))
+	assert.True(t, ok, "one step of drift is allowed")
+	_, ok = Validate(secret, "287082", at.Add(2*Period))
+	assert.False(t, ok)
+	_, ok = Validate(secret, "000000", at)
+	assert.False(t, ok)
+	_, ok = Validate(secret, "28708", at)
+	assert.False(t, ok)
+}
+
+func TestGenerateSecretAndURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+
+	now := time.Now()
+	c, err := Code(secret, now)
+	require.NoError(t, err)
+	_, ok := Validate(secret, c, now)
+	assert.True(t, ok)
+
+	u, err := url.Parse(URI("Workouts", "sam", secret))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/Workouts:sam", u.Path)
+	assert.Equal(t, secret, u.Query().Get("secret"))
+	assert.Equal(t, "Workouts", u.Query().Get("issuer"))
+}
@@ -25,7 +25,12 @@ func WriteJSON(w http.ResponseWriter, status int, data Envelope) error {
 }
 
 func ReadIDParam(r *http.Request) (int64, error) {
-	idParam := chi.URLParam(r, "id")
+	return ReadNamedIDParam(r, "id")
+}
+
+// ReadNamedIDParam reads a numeric URL parameter other than {id}, eg. {day_id}.
+func ReadNamedIDParam(r *http.Request, name string) (int64, error) {
+	idParam := chi.URLParam(r, name)
 	if idParam == "" {
 		return 0, errors.New("Invalid id parameter")
 	}
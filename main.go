@@ -1,44 +1,125 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/app"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/config"
 	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/routes"
 )
 
+const usage = `Usage:
+  %[1]s [serve] [flags]             run the API server
+  %[1]s migrate [flags] <command>   manage the database schema
+
+Run '%[1]s serve -h' or '%[1]s migrate -h' for the flags of each.
+`
+
 func main() {
-	// command line parsing for the port given by user
-	// eg. go run main.go -port 8081
-	var port int
-	flag.IntVar(&port, "port", 8080, "go backend server port")
-	flag.Parse()
+	// the command defaults to serve, so flags alone still start the server
+	// eg. go run . -port 8081 -config config.yaml
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		os.Exit(serve(args))
+	case "migrate":
+		os.Exit(migrate(args))
+	case "help":
+		fmt.Printf(usage, os.Args[0])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n"+usage, command, os.Args[0])
+		os.Exit(2)
+	}
+}
+
+// serve runs the API server until it is told to stop and returns the exit
+// code.
+func serve(args []string) int {
+	// settings come from flags, the environment and an optional config file
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	noAutoMigrate := fs.Bool("no-auto-migrate", false, "do not apply pending migrations on startup")
+	cfg, err := config.Load(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *noAutoMigrate {
+		cfg.DB.AutoMigrate = false
+	}
 
 	// initiating instance the new application
-	app, err := app.NewApplication()
+	app, err := app.NewApplication(cfg)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
-	defer app.DB.Close()
+	// the standard log package and logging.FromContext outside of requests
+	// write through the same logger
+	slog.SetDefault(app.Logger)
 
 	r := routes.SetupRoutes(app)
 
 	// Configure the server
 	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", port),
+		Addr:         fmt.Sprintf(":%d", cfg.Port),
 		Handler:      r,
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		app.Logger.Info("Up and Running", "port", cfg.Port)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serverErr:
+		app.Logger.Error("server", "error", err)
+		app.Close()
+		return 1
+	case <-ctx.Done():
 	}
+	// a second signal kills the process straight away
+	stop()
 
-	app.Logger.Printf("Up and Running at Port %d\n", port)
+	app.Logger.Info("shutting down")
+	app.Drain()
+	time.Sleep(cfg.Server.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		app.Logger.Error("draining requests", "error", err)
+	}
 
-	err = server.ListenAndServe()
+	err = app.Close()
 	if err != nil {
-		app.Logger.Fatal(err)
+		app.Logger.Error("closing application", "error", err)
 	}
+	app.Logger.Info("stopped")
+	return 0
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/config"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/internal/store"
+	"github.com/ShubhamkumarAnand/melkey-go/mel_project/migrations"
+	"github.com/pressly/goose/v3"
+)
+
+const migrateUsage = `Usage: %s migrate [flags] <command>
+
+Commands:
+  up            apply every pending migration
+  down          roll back the latest migration
+  redo          roll back the latest migration and apply it again
+  status        list the migrations and whether they are applied
+  version       print the version of the latest applied migration
+  create NAME   add an empty migration to the -dir directory
+
+Flags:
+`
+
+// migrate runs a migration command against the configured database and
+// returns the exit code. The migrations are the ones embedded in the binary,
+// so it always agrees with the server built alongside it.
+func migrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dir := fs.String("dir", "migrations", "directory new migrations are created in")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), migrateUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	cfg, err := config.Load(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	command := fs.Arg(0)
+	switch {
+	case command == "create" && fs.NArg() == 2:
+		path, err := store.CreateMigration(*dir, fs.Arg(1))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("created %s\n", path)
+		return 0
+	case fs.NArg() == 1 && (command == "up" || command == "down" || command == "redo" || command == "status" || command == "version"):
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	db, err := store.Open(cfg.DB)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer db.Close()
+
+	migrator, err := store.NewMigrator(db, migrations.FS)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// an interrupt stops waiting for the migration lock
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch command {
+	case "up":
+		var results []*goose.MigrationResult
+		results, err = migrator.Up(ctx)
+		printMigrationResults(results)
+		if err == nil && len(results) == 0 {
+			fmt.Println("no pending migrations")
+		}
+	case "down":
+		var result *goose.MigrationResult
+		result, err = migrator.Down(ctx)
+		printMigrationResults([]*goose.MigrationResult{result})
+	case "redo":
+		var results []*goose.MigrationResult
+		results, err = migrator.Redo(ctx)
+		printMigrationResults(results)
+	case "status":
+		var statuses []*goose.MigrationStatus
+		statuses, err = migrator.Status(ctx)
+		if err == nil {
+			printMigrationStatus(statuses)
+		}
+	case "version":
+		var version int64
+		version, err = migrator.Version(ctx)
+		if err == nil {
+			fmt.Println(version)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func printMigrationResults(results []*goose.MigrationResult) {
+	for _, result := range results {
+		if result == nil || result.Error != nil {
+			continue
+		}
+		action := "applied"
+		if result.Direction == "down" {
+			action = "rolled back"
+		}
+		fmt.Printf("%s %s (%s)\n", action, result.Source.Path, result.Duration.Round(time.Millisecond))
+	}
+}
+
+func printMigrationStatus(statuses []*goose.MigrationStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATE\tAPPLIED AT\tMIGRATION")
+	for _, status := range statuses {
+		appliedAt := "-"
+		if !status.AppliedAt.IsZero() {
+			appliedAt = status.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", status.Source.Version, status.State, appliedAt, status.Source.Path)
+	}
+	w.Flush()
+}
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE workouts
+ADD COLUMN performed_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN started_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN ended_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+ADD CONSTRAINT valid_workout_times CHECK (
+	started_at IS NULL
+	OR ended_at IS NULL
+	OR ended_at >= started_at
+);
+
+UPDATE workouts
+SET performed_at = COALESCE(created_at, CURRENT_TIMESTAMP);
+
+ALTER TABLE workouts
+ALTER COLUMN performed_at SET DEFAULT CURRENT_TIMESTAMP,
+ALTER COLUMN performed_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_workouts_user_performed_at ON workouts (user_id, performed_at);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_workouts_user_performed_at;
+
+ALTER TABLE workouts
+DROP CONSTRAINT valid_workout_times,
+DROP COLUMN performed_at,
+DROP COLUMN started_at,
+DROP COLUMN ended_at,
+DROP COLUMN timezone;
+
+-- +goose StatementEnd
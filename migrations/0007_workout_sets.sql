@@ -0,0 +1,53 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS workout_sets (
+	id BIGSERIAL PRIMARY KEY,
+	entry_id BIGINT NOT NULL REFERENCES workout_entries (id) ON DELETE CASCADE,
+	set_index INTEGER NOT NULL,
+	set_type VARCHAR(16) NOT NULL DEFAULT 'working',
+	reps INTEGER,
+	duration_seconds INTEGER,
+	weight DECIMAL(6, 2),
+	rpe DECIMAL(3, 1),
+	rir INTEGER,
+	rest_seconds INTEGER,
+	created_at TIMESTAMP
+	WITH
+		TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT valid_workout_set CHECK (
+			(
+				reps IS NOT NULL
+				OR duration_seconds IS NOT NULL
+			)
+			AND (
+				reps IS NULL
+				OR duration_seconds IS NULL
+			)
+		),
+		CONSTRAINT valid_set_type CHECK (
+			set_type IN ('warmup', 'working', 'drop', 'failure')
+		),
+		CONSTRAINT valid_rpe CHECK (
+			rpe IS NULL
+			OR rpe BETWEEN 1 AND 10
+		),
+		CONSTRAINT valid_rir CHECK (
+			rir IS NULL
+			OR rir >= 0
+		)
+);
+
+CREATE INDEX IF NOT EXISTS idx_workout_sets_entry_id ON workout_sets (entry_id, set_index);
+
+-- existing entries only know the aggregate, so expand them into identical working sets
+INSERT INTO workout_sets (entry_id, set_index, set_type, reps, duration_seconds, weight)
+SELECT e.id, s.n, 'working', e.reps, e.duration_seconds, e.weight
+FROM workout_entries e
+CROSS JOIN LATERAL generate_series(1, GREATEST(e.sets, 1)) AS s (n);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE workout_sets;
+
+-- +goose StatementEnd
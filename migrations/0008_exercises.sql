@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS exercises (
+	id BIGSERIAL PRIMARY KEY,
+	-- NULL for the shared catalog, set for a user's custom exercises
+	user_id BIGINT REFERENCES users (id) ON DELETE CASCADE,
+	name VARCHAR(255) NOT NULL,
+	normalized_name VARCHAR(255) NOT NULL,
+	aliases TEXT[] NOT NULL DEFAULT '{}',
+	primary_muscles TEXT[] NOT NULL DEFAULT '{}',
+	secondary_muscles TEXT[] NOT NULL DEFAULT '{}',
+	equipment VARCHAR(64) NOT NULL DEFAULT 'none',
+	movement_pattern VARCHAR(64) NOT NULL DEFAULT 'other',
+	metric_type VARCHAR(16) NOT NULL DEFAULT 'reps',
+	created_at TIMESTAMP
+	WITH
+		TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT valid_metric_type CHECK (metric_type IN ('reps', 'duration'))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_exercises_catalog_name ON exercises (normalized_name)
+WHERE
+	user_id IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_exercises_custom_name ON exercises (user_id, normalized_name)
+WHERE
+	user_id IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_exercises_aliases ON exercises USING GIN (aliases);
+
+ALTER TABLE workout_entries
+ADD COLUMN exercise_id BIGINT REFERENCES exercises (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_workout_entries_exercise_id ON workout_entries (exercise_id);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE workout_entries
+DROP COLUMN exercise_id;
+
+DROP TABLE exercises;
+
+-- +goose StatementEnd
@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS personal_records (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	exercise_id BIGINT NOT NULL REFERENCES exercises (id) ON DELETE CASCADE,
+	record_type VARCHAR(32) NOT NULL,
+	value DECIMAL(10, 2) NOT NULL,
+	weight DECIMAL(6, 2),
+	reps INTEGER,
+	workout_id BIGINT NOT NULL REFERENCES workouts (id) ON DELETE CASCADE,
+	set_id BIGINT REFERENCES workout_sets (id) ON DELETE SET NULL,
+	achieved_at TIMESTAMP
+	WITH
+		TIME ZONE NOT NULL,
+		created_at TIMESTAMP
+	WITH
+		TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT valid_record_type CHECK (
+			record_type IN (
+				'max_weight',
+				'max_reps',
+				'estimated_1rm',
+				'max_duration'
+			)
+		)
+);
+
+CREATE INDEX IF NOT EXISTS idx_personal_records_user_exercise ON personal_records (user_id, exercise_id, record_type, achieved_at);
+
+CREATE INDEX IF NOT EXISTS idx_personal_records_workout_id ON personal_records (workout_id);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE personal_records;
+
+-- +goose StatementEnd
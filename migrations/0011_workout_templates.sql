@@ -0,0 +1,64 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS workout_templates (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name VARCHAR(255) NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	duration_minutes INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP
+	WITH
+		TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP
+	WITH
+		TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_workout_templates_user_id ON workout_templates (user_id);
+
+CREATE TABLE IF NOT EXISTS template_entries (
+	id BIGSERIAL PRIMARY KEY,
+	template_id BIGINT NOT NULL REFERENCES workout_templates (id) ON DELETE CASCADE,
+	exercise_id BIGINT REFERENCES exercises (id) ON DELETE SET NULL,
+	exercise_name VARCHAR(255) NOT NULL,
+	sets INTEGER NOT NULL,
+	reps INTEGER,
+	duration_seconds INTEGER,
+	weight DECIMAL(6, 2),
+	notes TEXT NOT NULL DEFAULT '',
+	order_index INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_template_entries_template_id ON template_entries (template_id, order_index);
+
+CREATE TABLE IF NOT EXISTS template_sets (
+	id BIGSERIAL PRIMARY KEY,
+	entry_id BIGINT NOT NULL REFERENCES template_entries (id) ON DELETE CASCADE,
+	set_index INTEGER NOT NULL,
+	set_type VARCHAR(16) NOT NULL DEFAULT 'working',
+	reps INTEGER,
+	duration_seconds INTEGER,
+	weight DECIMAL(6, 2),
+	rpe DECIMAL(3, 1),
+	rir INTEGER,
+	rest_seconds INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_template_sets_entry_id ON template_sets (entry_id, set_index);
+
+ALTER TABLE workouts
+ADD COLUMN template_id BIGINT REFERENCES workout_templates (id) ON DELETE SET NULL;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE workouts
+DROP COLUMN template_id;
+
+DROP TABLE template_sets;
+
+DROP TABLE template_entries;
+
+DROP TABLE workout_templates;
+
+-- +goose StatementEnd
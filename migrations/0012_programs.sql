@@ -0,0 +1,66 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS programs (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name VARCHAR(255) NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	weeks INTEGER NOT NULL CHECK (weeks > 0),
+	created_at TIMESTAMP
+	WITH
+		TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP
+	WITH
+		TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_programs_user_id ON programs (user_id);
+
+CREATE TABLE IF NOT EXISTS program_days (
+	id BIGSERIAL PRIMARY KEY,
+	program_id BIGINT NOT NULL REFERENCES programs (id) ON DELETE CASCADE,
+	week_number INTEGER NOT NULL CHECK (week_number > 0),
+	day_number INTEGER NOT NULL CHECK (day_number BETWEEN 1 AND 7),
+	-- templates in use by a program cannot be deleted out from under it
+	template_id BIGINT NOT NULL REFERENCES workout_templates (id) ON DELETE RESTRICT,
+	UNIQUE (program_id, week_number, day_number)
+);
+
+CREATE TABLE IF NOT EXISTS user_program_enrollments (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	program_id BIGINT NOT NULL REFERENCES programs (id) ON DELETE CASCADE,
+	start_date DATE NOT NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'active',
+	created_at TIMESTAMP
+	WITH
+		TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT valid_enrollment_status CHECK (status IN ('active', 'cancelled'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_program_enrollments_user_id ON user_program_enrollments (user_id, status);
+
+-- a workout logged for a scheduled day is what marks that day complete
+ALTER TABLE workouts
+ADD COLUMN program_enrollment_id BIGINT REFERENCES user_program_enrollments (id) ON DELETE SET NULL,
+ADD COLUMN program_day_id BIGINT REFERENCES program_days (id) ON DELETE SET NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_workouts_program_day ON workouts (program_enrollment_id, program_day_id)
+WHERE
+	program_enrollment_id IS NOT NULL
+	AND program_day_id IS NOT NULL;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE workouts
+DROP COLUMN program_enrollment_id,
+DROP COLUMN program_day_id;
+
+DROP TABLE user_program_enrollments;
+
+DROP TABLE program_days;
+
+DROP TABLE programs;
+
+-- +goose StatementEnd
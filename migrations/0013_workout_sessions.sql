@@ -0,0 +1,56 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS workout_sessions (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	title VARCHAR(255) NOT NULL,
+	timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+	template_id BIGINT REFERENCES workout_templates (id) ON DELETE SET NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'active',
+	default_rest_seconds INTEGER CHECK (default_rest_seconds >= 0),
+	rest_started_at TIMESTAMP WITH TIME ZONE,
+	rest_target_seconds INTEGER CHECK (rest_target_seconds >= 0),
+	workout_id BIGINT REFERENCES workouts (id) ON DELETE SET NULL,
+	started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_activity_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	ended_at TIMESTAMP WITH TIME ZONE,
+	CONSTRAINT valid_session_status CHECK (status IN ('active', 'finished', 'abandoned'))
+);
+
+-- a user logs one session at a time
+CREATE UNIQUE INDEX IF NOT EXISTS idx_workout_sessions_active_user ON workout_sessions (user_id)
+WHERE
+	status = 'active';
+
+CREATE INDEX IF NOT EXISTS idx_workout_sessions_stale ON workout_sessions (last_activity_at)
+WHERE
+	status = 'active';
+
+CREATE TABLE IF NOT EXISTS workout_session_sets (
+	id BIGSERIAL PRIMARY KEY,
+	session_id BIGINT NOT NULL REFERENCES workout_sessions (id) ON DELETE CASCADE,
+	-- client_id lets a phone retry a set it is not sure was saved
+	client_id VARCHAR(64),
+	exercise_id BIGINT REFERENCES exercises (id) ON DELETE SET NULL,
+	exercise_name VARCHAR(255) NOT NULL,
+	set_type VARCHAR(16) NOT NULL DEFAULT 'working',
+	reps INTEGER,
+	duration_seconds INTEGER,
+	weight DECIMAL(6, 2),
+	rpe DECIMAL(3, 1),
+	rir INTEGER,
+	rest_seconds INTEGER,
+	logged_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (session_id, client_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_workout_session_sets_session_id ON workout_session_sets (session_id, logged_at);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE workout_session_sets;
+
+DROP TABLE workout_sessions;
+
+-- +goose StatementEnd
@@ -0,0 +1,117 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Changes to workouts and live sessions are published on the workout_events
+-- channel. NOTIFY inside a transaction is only delivered on commit, so
+-- listeners never hear about rolled back changes. Payloads are kept small to
+-- stay far below the 8000 byte NOTIFY limit; clients fetch details over REST.
+CREATE OR REPLACE FUNCTION notify_workout_event() RETURNS trigger AS $$
+DECLARE
+	rec RECORD;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		rec := OLD;
+	ELSE
+		rec := NEW;
+	END IF;
+
+	PERFORM pg_notify('workout_events', json_build_object(
+		'type', 'workout.' || CASE TG_OP WHEN 'INSERT' THEN 'created' WHEN 'UPDATE' THEN 'updated' ELSE 'deleted' END,
+		'user_id', rec.user_id,
+		'workout_id', rec.id,
+		'data', json_build_object('id', rec.id, 'title', rec.title, 'performed_at', rec.performed_at),
+		'at', CURRENT_TIMESTAMP
+	)::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER workouts_notify
+AFTER INSERT OR UPDATE OR DELETE ON workouts
+FOR EACH ROW EXECUTE FUNCTION notify_workout_event();
+
+CREATE OR REPLACE FUNCTION notify_session_event() RETURNS trigger AS $$
+DECLARE
+	event_type TEXT;
+BEGIN
+	IF TG_OP = 'INSERT' THEN
+		event_type := 'session.started';
+	ELSIF NEW.status <> OLD.status THEN
+		event_type := 'session.' || NEW.status;
+	ELSIF NEW.rest_started_at IS DISTINCT FROM OLD.rest_started_at
+		OR NEW.rest_target_seconds IS DISTINCT FROM OLD.rest_target_seconds THEN
+		event_type := 'session.rest_updated';
+	ELSE
+		-- last_activity_at alone is bookkeeping, not news
+		RETURN NULL;
+	END IF;
+
+	PERFORM pg_notify('workout_events', json_build_object(
+		'type', event_type,
+		'user_id', NEW.user_id,
+		'session_id', NEW.id,
+		'workout_id', NEW.workout_id,
+		'data', json_build_object(
+			'id', NEW.id,
+			'title', NEW.title,
+			'status', NEW.status,
+			'rest_started_at', NEW.rest_started_at,
+			'rest_target_seconds', NEW.rest_target_seconds
+		),
+		'at', CURRENT_TIMESTAMP
+	)::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER workout_sessions_notify
+AFTER INSERT OR UPDATE ON workout_sessions
+FOR EACH ROW EXECUTE FUNCTION notify_session_event();
+
+CREATE OR REPLACE FUNCTION notify_session_set_event() RETURNS trigger AS $$
+DECLARE
+	rec RECORD;
+	owner_id BIGINT;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		rec := OLD;
+	ELSE
+		rec := NEW;
+	END IF;
+
+	SELECT user_id INTO owner_id FROM workout_sessions WHERE id = rec.session_id;
+	IF owner_id IS NULL THEN
+		-- the whole session is being deleted
+		RETURN NULL;
+	END IF;
+
+	PERFORM pg_notify('workout_events', json_build_object(
+		'type', CASE TG_OP WHEN 'INSERT' THEN 'session.set_logged' ELSE 'session.set_deleted' END,
+		'user_id', owner_id,
+		'session_id', rec.session_id,
+		'data', row_to_json(rec),
+		'at', CURRENT_TIMESTAMP
+	)::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER workout_session_sets_notify
+AFTER INSERT OR DELETE ON workout_session_sets
+FOR EACH ROW EXECUTE FUNCTION notify_session_set_event();
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER workout_session_sets_notify ON workout_session_sets;
+
+DROP TRIGGER workout_sessions_notify ON workout_sessions;
+
+DROP TRIGGER workouts_notify ON workouts;
+
+DROP FUNCTION notify_session_set_event();
+
+DROP FUNCTION notify_session_event();
+
+DROP FUNCTION notify_workout_event();
+
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+ADD COLUMN activated BOOLEAN NOT NULL DEFAULT false,
+ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE;
+
+-- accounts created before activation existed keep working; their email
+-- simply stays unverified
+UPDATE users SET activated = true;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+DROP COLUMN activated,
+DROP COLUMN email_verified_at;
+
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- the hash stays the primary key for lookups; id is a non-secret handle
+-- clients can use to refer to a token, eg. to revoke another device
+ALTER TABLE tokens
+ADD COLUMN id BIGSERIAL UNIQUE,
+ADD COLUMN created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+ADD COLUMN last_used_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_tokens_user_id_scope ON tokens (user_id, scope);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_tokens_user_id_scope;
+
+ALTER TABLE tokens
+DROP COLUMN id,
+DROP COLUMN created_at,
+DROP COLUMN last_used_at,
+DROP COLUMN user_agent,
+DROP COLUMN ip;
+
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+-- every sign-in starts a family: the refresh tokens rotated out of it and
+-- the access tokens issued along the way share its id, so reuse of a
+-- rotated refresh token can revoke all of them at once
+CREATE SEQUENCE IF NOT EXISTS token_family_seq;
+
+ALTER TABLE tokens
+ADD COLUMN family_id BIGINT,
+ADD COLUMN rotated_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_tokens_family_id ON tokens (family_id);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_tokens_family_id;
+
+ALTER TABLE tokens
+DROP COLUMN family_id,
+DROP COLUMN rotated_at;
+
+DROP SEQUENCE token_family_seq;
+
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- personal access tokens carry a name, the scopes they are limited to and
+-- may never expire
+ALTER TABLE tokens
+ADD COLUMN name TEXT NOT NULL DEFAULT '',
+ADD COLUMN access_scopes TEXT[],
+ALTER COLUMN expiry DROP NOT NULL;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM tokens WHERE expiry IS NULL;
+
+ALTER TABLE tokens
+DROP COLUMN name,
+DROP COLUMN access_scopes,
+ALTER COLUMN expiry SET NOT NULL;
+
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- every sign in attempt, kept for throttling and as an audit trail, eg.
+--   SELECT * FROM login_attempts WHERE NOT success ORDER BY attempted_at DESC;
+-- username is what was typed in, so attempts on accounts that do not exist
+-- are throttled the same way
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    username TEXT NOT NULL,
+    user_id BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    ip TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    success BOOLEAN NOT NULL,
+    reason TEXT NOT NULL,
+    attempted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_username ON login_attempts (username, attempted_at);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts (ip, attempted_at);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE login_attempts;
+
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- entries summarise their top set, so they need the same range as the sets
+ALTER TABLE workout_entries ALTER COLUMN weight TYPE DECIMAL(6, 2);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE workout_entries ALTER COLUMN weight TYPE DECIMAL(5, 2);
+
+-- +goose StatementEnd
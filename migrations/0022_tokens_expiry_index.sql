@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- expired tokens, rotated refresh tokens among them, are purged periodically
+CREATE INDEX IF NOT EXISTS idx_tokens_expiry ON tokens (expiry);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_tokens_expiry;
+
+-- +goose StatementEnd
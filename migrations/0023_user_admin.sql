@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- admins can read the sign in audit trail; there is no endpoint granting the
+-- flag, it is set by hand:
+--   UPDATE users SET is_admin = true WHERE username = '...';
+ALTER TABLE users
+ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT false;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users
+DROP COLUMN is_admin;
+
+-- +goose StatementEnd
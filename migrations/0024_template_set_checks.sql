@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+-- template sets follow the same rules as the workout sets they turn into
+ALTER TABLE template_sets
+ADD CONSTRAINT valid_template_set CHECK (
+	(
+		reps IS NOT NULL
+		OR duration_seconds IS NOT NULL
+	)
+	AND (
+		reps IS NULL
+		OR duration_seconds IS NULL
+	)
+),
+ADD CONSTRAINT valid_template_set_type CHECK (
+	set_type IN ('warmup', 'working', 'drop', 'failure')
+),
+ADD CONSTRAINT valid_template_rpe CHECK (
+	rpe IS NULL
+	OR rpe BETWEEN 1 AND 10
+),
+ADD CONSTRAINT valid_template_rir CHECK (
+	rir IS NULL
+	OR rir >= 0
+);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE template_sets
+DROP CONSTRAINT valid_template_set,
+DROP CONSTRAINT valid_template_set_type,
+DROP CONSTRAINT valid_template_rpe,
+DROP CONSTRAINT valid_template_rir;
+
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- records are matched to their set by position, which unlike the set id
+-- survives the workout being edited
+ALTER TABLE personal_records
+ADD COLUMN entry_index INTEGER,
+ADD COLUMN set_index INTEGER;
+
+UPDATE personal_records pr
+SET entry_index = e.order_index, set_index = s.set_index
+FROM workout_sets s
+INNER JOIN workout_entries e ON e.id = s.entry_id
+WHERE s.id = pr.set_id;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE personal_records
+DROP COLUMN entry_index,
+DROP COLUMN set_index;
+
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- sessions are listed and revoked by family, so access tokens issued before
+-- families existed each get one of their own
+UPDATE tokens
+SET family_id = nextval('token_family_seq')
+WHERE family_id IS NULL AND scope = 'authentication';
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+-- the families are harmless to older code, which leaves them alone
+SELECT 1;
+
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- "Local" and other names postgres does not know used to be accepted and
+-- make every time zone conversion of the user's workouts fail
+UPDATE workouts
+SET timezone = 'UTC'
+WHERE timezone NOT IN (SELECT name FROM pg_timezone_names);
+
+UPDATE workout_sessions
+SET timezone = 'UTC'
+WHERE timezone NOT IN (SELECT name FROM pg_timezone_names);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+-- the fixed time zones are valid for older code too
+SELECT 1;
+
+-- +goose StatementEnd
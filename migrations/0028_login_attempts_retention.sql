@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- old attempts are purged by age, and admins list the newest first
+CREATE INDEX IF NOT EXISTS idx_login_attempts_attempted_at ON login_attempts (attempted_at);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_login_attempts_attempted_at;
+
+-- +goose StatementEnd